@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"opencloudsave/core"
+)
+
+type apiServeCommand struct {
+	Listen string `long:"listen" default:"127.0.0.1:7373" description:"The loopback address to listen on"`
+}
+
+func (c *apiServeCommand) Execute(args []string) error {
+	token, tokenPath, err := core.GetAPIToken()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ops := &core.Options{
+		DryRun:  globalOps.DryRun,
+		Verbose: globalOps.Verbose,
+	}
+	server := core.NewAPIServer(core.MakeCloudManager(), core.MakeGameDefManager(getUserOverrideLocation()), ops, token)
+	return core.ServeAPI(ctx, c.Listen, server, func(addr net.Addr) {
+		// Tools wait for this line to know the API is up
+		if isJSONOutput() {
+			writeJSON(map[string]interface{}{"type": "listening", "url": "http://" + addr.String(), "tokenFile": tokenPath})
+			return
+		}
+		fmt.Printf("Listening on http://%v, the token is in %v\n", addr, tokenPath)
+	})
+}
+
+type apiTokenCommand struct {
+	Rotate bool `long:"rotate" description:"Replace the token, clients using the old one are refused"`
+}
+
+func (c *apiTokenCommand) Execute(args []string) error {
+	token, tokenPath, err := core.GetAPIToken()
+	if err != nil {
+		return err
+	}
+
+	if c.Rotate {
+		token, err = core.RotateAPIToken()
+		if err != nil {
+			return err
+		}
+	}
+
+	printResult(map[string]interface{}{"token": token, "tokenFile": tokenPath}, func() {
+		fmt.Println(token)
+	})
+	return nil
+}
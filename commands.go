@@ -0,0 +1,1258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"opencloudsave/core"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// Flags of the flat CLI that have been replaced by subcommands. They keep
+// working, but are hidden from the help and print a warning when used.
+var deprecatedFlags = []struct {
+	name        string
+	replacement string
+}{
+	{"gamenames", "sync GAME..."},
+	{"no-gui", "sync GAME..."},
+	{"add-custom-games", "add GAME --json JSON"},
+	{"print-gamedefs", "list --all"},
+	{"sync-user-settings", "settings sync"},
+	{"set-cloud", "cloud set ID"},
+	{"cloud-setting", "cloud set ID --setting KEY:VALUE"},
+	{"cloud-name", "cloud set PROVIDER --name NAME"},
+	{"rename-cloud", "cloud rename ID NAME"},
+	{"remove-cloud", "cloud remove ID"},
+	{"game-cloud", "edit GAME --cloud ID"},
+	{"authorize-headless", "cloud authorize"},
+	{"reconnect", "cloud reconnect"},
+	{"doctor", "doctor"},
+	{"export-diagnostics", "diagnostics ZIP"},
+	{"list-clouds", "cloud show"},
+	{"set-secondary-cloud", "cloud secondary ID"},
+	{"heal-secondary", "cloud heal"},
+	{"restore-from-secondary", "restore"},
+	{"set-remote-root", "cloud root PATH"},
+	{"local-storage", "cloud set local --setting path:DIR"},
+	{"experimental", "records search"},
+}
+
+// globalOps holds the options shared by every command, like --log-location
+// and --dry-run, along with the deprecated flags.
+var globalOps *core.Options
+
+// The name of the running command and the data of its result, which is
+// written as JSON when the command finishes. See printResult.
+var activeCommand string
+var commandData interface{}
+
+func newParser(ops *core.Options) *flags.Parser {
+	globalOps = ops
+	parser := flags.NewParser(ops, flags.Default)
+	parser.SubcommandsOptional = true
+
+	for _, deprecated := range deprecatedFlags {
+		option := parser.FindOptionByLongName(deprecated.name)
+		if option != nil {
+			option.Hidden = true
+		}
+	}
+
+	parser.AddCommand("sync", "Sync the saves of games", "Syncs the saves of the given games with the current cloud, or with the account set for each game. In a terminal, the changes of each sync are shown for confirmation first, use --yes to skip this.", &syncCommand{})
+	parser.AddCommand("setup", "Set up a cloud in the terminal", "Asks for the cloud to sync to and its settings, creates the remote and tests the connection.", &setupCommand{})
+	parser.AddCommand("daemon", "Sync games whenever their saves change", "Watches the save folders of every tracked game and syncs a game once its saves were left unchanged for the quiet period. Save folders created later are picked up. Stops on SIGTERM after syncing the changes still pending.", &daemonCommand{})
+	parser.AddCommand("run", "Sync a game around its launch command", "Downloads the newer saves of the game, runs the command after -- and uploads the saves once it exits. The exit code of the command is kept. In Steam, set the launch options to: opencloudsave run --game <game> -- %command%. When the saves can not be downloaded within the timeout, e.g. offline, a warning is shown and the game is started anyway.", &runCommand{})
+	parser.AddCommand("status", "Show the current cloud and sync state", "Shows the current and secondary cloud, the cloud save folder, accounts that need to sign in again and whether each game's saves are newer locally or in the cloud. Only file listings are read, nothing is synced.", &statusCommand{})
+	parser.AddCommand("list", "List the games that are synced", "Lists the tracked games. Use --all to include archived games.", &listCommand{})
+	parser.AddCommand("add", "Add a custom game", "Adds a game that is not in the built-in list, with the folders its saves are kept in.", &addCommand{})
+	parser.AddCommand("edit", "Edit a game", "Changes the save folders, cloud folder or account of a game. Only the given options are changed.", &editCommand{})
+	parser.AddCommand("remove", "Stop syncing games", "Archives games so they are no longer synced. Their saves stay in the cloud.", &removeCommand{})
+	parser.AddCommand("restore", "Restore saves from the secondary cloud", "Copies saves from the secondary cloud into the current cloud. Restores every game unless games are given.", &restoreCommand{})
+	parser.AddCommand("snapshots", "List the saves kept on the secondary cloud", "Lists the game folders on the secondary cloud, which restore can bring back.", &snapshotsCommand{})
+	parser.AddCommand("doctor", "Check everything a sync depends on", "Checks rclone, the settings, the current cloud, the game manifest and Steam, and suggests how to fix anything that is wrong. Exits with an error when a check fails.", &doctorCommand{})
+	parser.AddCommand("diagnostics", "Export a diagnostics bundle", "Writes the logs, settings, rclone config and save paths to a zip for attaching to bug reports. Passwords, tokens and your username are removed.", &diagnosticsCommand{})
+
+	cloud, _ := parser.AddCommand("cloud", "Manage cloud providers and accounts", "Sets up, shows and tests the clouds saves are synced to.", &struct{}{})
+	cloud.AddCommand("set", "Set the current cloud", "Sets the current cloud by provider or account id, see cloud show. Settings are applied to the cloud first, existing remotes are updated in place.", &cloudSetCommand{})
+	cloud.AddCommand("show", "Show the providers, their settings and the accounts", "Lists the available cloud providers, their settings and the configured accounts.", &cloudShowCommand{})
+	cloud.AddCommand("test", "Check that the current cloud can be reached", "Checks that the remote of the current cloud exists and that its sign in is still valid.", &cloudTestCommand{})
+	cloud.AddCommand("secondary", "Set the cloud saves are mirrored to", "Mirrors saves to a second cloud after each sync. Use none to disable mirroring.", &cloudSecondaryCommand{})
+	cloud.AddCommand("heal", "Repair the secondary cloud", "Compares the secondary cloud with the current cloud and copies anything that is missing.", &cloudHealCommand{})
+	cloud.AddCommand("root", "Set the cloud save folder", "Sets the folder on the cloud that holds all saves, e.g. Backups/GameSaves. Existing saves are moved to the new folder, on every account games sync to.", &cloudRootCommand{})
+	cloud.AddCommand("rename", "Rename an account", "Renames an account. The account id stays the same.", &cloudRenameCommand{})
+	cloud.AddCommand("remove", "Remove an account", "Removes an account along with its rclone remote and settings.", &cloudRemoveCommand{})
+	cloud.AddCommand("authorize", "Sign in without a browser on this device", "Prints an rclone authorize command to run on another computer and reads the resulting token from stdin.", &cloudAuthorizeCommand{})
+	cloud.AddCommand("reconnect", "Sign in again", "Signs in again keeping the cloud's settings. Syncs that failed because the sign in expired are run again.", &cloudReconnectCommand{})
+
+	sessions, _ := parser.AddCommand("sessions", "Sync around game sessions", "Notices when tracked games start and exit, to sync their saves around each session.", &struct{}{})
+	sessions.AddCommand("watch", "Sync games when they start and exit", "Looks for running games, by their Steam app id, Proton prefix or install folder. Newer saves are downloaded from the cloud when a game starts, unless its local saves changed as well, and its saves are uploaded once it exits.", &sessionsWatchCommand{})
+	sessions.AddCommand("list", "List the recorded sessions", "Lists the latest game sessions along with the syncs before and after them.", &sessionsListCommand{})
+
+	api, _ := parser.AddCommand("api", "Serve the local control API", "Serves an HTTP/JSON API on a loopback address for integrations like launcher plugins, widgets and scripts. See docs/http-api.md.", &struct{}{})
+	api.AddCommand("serve", "Serve the API until stopped", "Serves the API on the loopback address given by --listen. Requests need the token from the token file. Syncs that are running are finished when stopped.", &apiServeCommand{})
+	api.AddCommand("token", "Print the API token", "Prints the token requests to the API need, creating it if needed. Use --rotate to replace it.", &apiTokenCommand{})
+
+	schedule, _ := parser.AddCommand("schedule", "Sync games on a schedule", "Manages schedules that sync games periodically, e.g. every 2 hours or daily at 03:00. Schedules are kept in the settings and run by schedule run, or by systemd timers, see schedule systemd.", &struct{}{})
+	schedule.AddCommand("list", "List the schedules", "Lists the schedules along with their last and next run.", &scheduleListCommand{})
+	schedule.AddCommand("add", "Add a schedule", "Adds a schedule syncing every tracked game, the games selected for multisync or the given games. Use either --every or --at. A schedule of the same name is replaced.", &scheduleAddCommand{})
+	schedule.AddCommand("remove", "Remove a schedule", "Removes the schedule called NAME.", &scheduleRemoveCommand{})
+	schedule.AddCommand("run", "Run the schedules", "Runs every schedule when it is due, until stopped. Runs missed while the computer was off or asleep are caught up once. With --once, the schedules that are due are run and the command exits.", &scheduleRunCommand{})
+	schedule.AddCommand("systemd", "Generate systemd user units running the schedules", "Prints a systemd user service and timer that run schedule run --once every 5 minutes, so schedules are run without the app open and survive reboots. Use --install to write them to the systemd user folder. Pass --vault-passphrase-file to let them unlock the secret vault. The units do not change along with the schedules.", &scheduleSystemdCommand{})
+
+	lease, _ := parser.AddCommand("lease", "Show and break the leases of games", "A device syncing a game keeps a lease in the game's cloud folder, so other devices do not write to it at the same time. Leases expire when their device stops renewing them, e.g. after a crash, and have to be broken before the game can be synced again.", &struct{}{})
+	lease.AddCommand("show", "Show who is syncing games", "Shows the device holding the lease of each game and when it expires.", &leaseShowCommand{})
+	lease.AddCommand("break", "Break the leases of games", "Removes the expired leases of the games, so they can be synced again. Use --force to break leases that did not expire yet.", &leaseBreakCommand{})
+
+	settings, _ := parser.AddCommand("settings", "Manage the app settings", "Manages the settings shared between devices.", &struct{}{})
+	settings.AddCommand("sync", "Sync the game definitions with the cloud", "Syncs the custom game definitions with the current cloud, so every device uses the same ones.", &settingsSyncCommand{})
+	settings.AddCommand("hooks", "Show or set the hooks of every game", "Shows the commands run around the syncs of every game, or sets them with the given options. The hooks of single games are set with add and edit. See docs/hooks.md.", &settingsHooksCommand{})
+
+	records, _ := parser.AddCommand("records", "Search the ludusavi game manifest", "Looks up games in the ludusavi manifest.", &struct{}{})
+	records.AddCommand("search", "Search games by name", "Lists the games of the ludusavi manifest whose name contains QUERY.", &recordsSearchCommand{})
+
+	return parser
+}
+
+// warnDeprecatedFlags points users of the flat CLI to the subcommands.
+func warnDeprecatedFlags(parser *flags.Parser) {
+	for _, deprecated := range deprecatedFlags {
+		option := parser.FindOptionByLongName(deprecated.name)
+		if option != nil && option.IsSet() {
+			fmt.Fprintf(os.Stderr, "Warning: --%v is deprecated and will be removed, use `%v` instead\n", deprecated.name, deprecated.replacement)
+		}
+	}
+}
+
+func getUserOverrideLocation() string {
+	if len(globalOps.UserOverride) > 0 {
+		return globalOps.UserOverride[0]
+	}
+
+	return ""
+}
+
+func resolveCloud(id string) (string, error) {
+	if id == "" {
+		cloudperfs, err := core.GetCurrentCloudPerfs()
+		if err != nil {
+			return "", fmt.Errorf("no cloud is set, please set one with `cloud set`")
+		}
+		return cloudperfs.Cloud, nil
+	}
+
+	return core.ResolveStorageProviderId(id)
+}
+
+func isJSONOutput() bool {
+	return globalOps != nil && globalOps.Output == core.OutputJSON
+}
+
+func writeJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(value)
+	if err != nil {
+		core.ErrorLogger.Println(err)
+	}
+}
+
+// printResult hands data to the JSON result of the running command, or
+// calls text to print it for humans.
+func printResult(data interface{}, text func()) {
+	if isJSONOutput() {
+		commandData = data
+		return
+	}
+
+	text()
+}
+
+// writeCommandResult writes the JSON document ending a command. Text output
+// has nothing to add, errors are printed by go-flags.
+func writeCommandResult(err error) {
+	if !isJSONOutput() || activeCommand == "gui" {
+		return
+	}
+
+	if _, ok := err.(*flags.Error); ok {
+		err = core.WithErrorKind(core.ErrorKindUsage, err)
+	}
+
+	writeJSON(core.NewCommandResult(activeCommand, commandData, err))
+}
+
+// getCommandName returns the name of the command go-flags is running, e.g.
+// "cloud set".
+func getCommandName(parser *flags.Parser) string {
+	names := []string{}
+	for command := parser.Active; command != nil; command = command.Active {
+		names = append(names, command.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// getLegacyCommandName names a run of the deprecated flags after the command
+// replacing them, so their JSON results look the same.
+func getLegacyCommandName(parser *flags.Parser) string {
+	for _, deprecated := range deprecatedFlags {
+		option := parser.FindOptionByLongName(deprecated.name)
+		if option == nil || !option.IsSet() {
+			continue
+		}
+
+		names := []string{}
+		for _, word := range strings.Fields(deprecated.replacement) {
+			if strings.ToUpper(word) == word || strings.HasPrefix(word, "-") {
+				break
+			}
+			names = append(names, word)
+		}
+		return strings.Join(names, " ")
+	}
+
+	return "gui"
+}
+
+// lockHolders names the commands as other processes report them when they
+// find a game locked, e.g. "Desktop GUI is syncing Factorio".
+var lockHolders = map[string]string{
+	"gui":            "Desktop GUI",
+	"daemon":         "The daemon",
+	"sessions watch": "The session watcher",
+	"schedule run":   "The scheduler",
+	"api serve":      "The HTTP API",
+	"run":            "The game launcher",
+}
+
+func getLockHolder(command string) string {
+	holder, ok := lockHolders[command]
+	if !ok {
+		return "opencloudsave " + command
+	}
+	return holder
+}
+
+type syncSummary struct {
+	Game      string `json:"game"`
+	Ok        bool   `json:"ok"`
+	Bytes     int64  `json:"bytes"`
+	Files     int    `json:"files"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"errorKind,omitempty"`
+}
+
+// runOperation runs a sync and prints its messages, or its progress events
+// as JSON lines. It fails if any of the syncs failed.
+func runOperation(ops *core.Options, dm core.GameDefManager) error {
+	channels := core.MakeDefaultChannelProvider()
+	go func() {
+		core.RequestMainOperation(context.Background(), core.MakeCloudManager(), ops, dm, channels)
+		close(channels.Logs)
+	}()
+
+	summaries := []*syncSummary{}
+	summaryMap := make(map[string]*syncSummary)
+	failed := 0
+	var lastErr error
+	for msg := range channels.Logs {
+		if msg.Err != nil {
+			failed++
+			lastErr = msg.Err
+		}
+
+		if msg.Event != nil {
+			summary, ok := summaryMap[msg.Event.Game]
+			if !ok {
+				summary = &syncSummary{Game: msg.Event.Game, Ok: true}
+				summaryMap[msg.Event.Game] = summary
+				summaries = append(summaries, summary)
+			}
+
+			switch msg.Event.Phase {
+			case core.PhaseDone:
+				summary.Bytes += msg.Event.Bytes
+				summary.Files += msg.Event.Files
+			case core.PhaseError:
+				summary.Ok = false
+				summary.Error = msg.Event.Error
+				summary.ErrorKind = msg.Event.ErrorKind
+			}
+		}
+
+		if isJSONOutput() {
+			if msg.Event != nil {
+				writeJSON(msg.Event)
+			}
+			continue
+		}
+
+		if msg.Err != nil {
+			fmt.Fprintln(os.Stderr, msg.Err)
+		} else if msg.Message != "" {
+			fmt.Println(msg.Message)
+		}
+	}
+
+	printResult(map[string]interface{}{"games": summaries}, func() {})
+	if failed == 1 {
+		return lastErr
+	}
+	if failed > 0 {
+		return core.WithErrorKind(core.ErrorKindSync, fmt.Errorf("%v syncs failed", failed))
+	}
+
+	return nil
+}
+
+type syncCommand struct {
+	Wait time.Duration `long:"wait" default:"0s" description:"How long to wait for other devices syncing the games, e.g. 2m. By default the games are skipped"`
+	Args struct {
+		Games []string `positional-arg-name:"GAME" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+	// Syncs the games without asking, for the flags that predate the prompts
+	noConfirm bool
+}
+
+func (c *syncCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	for _, game := range c.Args.Games {
+		_, ok := dm.GetGameDefMap()[strings.TrimSpace(game)]
+		if !ok {
+			return core.WithErrorKind(core.ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", game))
+		}
+	}
+
+	games := c.Args.Games
+	dryRun := len(globalOps.DryRun) > 0 && globalOps.DryRun[0]
+	if shouldConfirm() && !dryRun && !c.noConfirm {
+		p := newPrompter()
+		games = []string{}
+		for _, game := range c.Args.Games {
+			ok, err := confirmSync(p, dm, strings.TrimSpace(game))
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				games = append(games, game)
+			} else {
+				fmt.Fprintf(p.out, "Skipping %v\n", game)
+			}
+		}
+
+		if len(games) == 0 {
+			printResult(map[string]interface{}{"games": []*syncSummary{}}, func() {})
+			return nil
+		}
+	}
+
+	ops := &core.Options{
+		Gamenames: games,
+		DryRun:    globalOps.DryRun,
+		Verbose:   globalOps.Verbose,
+		Output:    globalOps.Output,
+		LeaseWait: c.Wait,
+	}
+	return runOperation(ops, dm)
+}
+
+type statusCommand struct {
+	Selected bool `long:"selected" description:"Only show the games selected for multisync"`
+	Args     struct {
+		Games []string `positional-arg-name:"GAME"`
+	} `positional-args:"yes"`
+}
+
+type cloudStatus struct {
+	Id          string `json:"id"`
+	DisplayName string `json:"displayName,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type status struct {
+	Version     string                 `json:"version"`
+	Cloud       *cloudStatus           `json:"cloud"`
+	Secondary   *cloudStatus           `json:"secondary,omitempty"`
+	RemoteRoot  string                 `json:"remoteRoot,omitempty"`
+	DryRun      bool                   `json:"dryRun"`
+	BiSync      bool                   `json:"bisync"`
+	NeedsReauth []string               `json:"needsReauth"`
+	Games       []*core.GameSyncStatus `json:"games"`
+}
+
+func getCloudStatus(id string) *cloudStatus {
+	provider, err := core.GetStorageProvider(id)
+	if err != nil {
+		return &cloudStatus{Id: id, Error: err.Error()}
+	}
+
+	return &cloudStatus{Id: provider.Id, DisplayName: provider.DisplayName}
+}
+
+func (c *statusCommand) Execute(args []string) error {
+	result := &status{
+		Version:     strings.ReplaceAll(strings.TrimSpace(core.VersionRevision), "\n", " "),
+		NeedsReauth: core.GetStoragesNeedingReauth(),
+	}
+
+	cloudperfs, err := core.GetCurrentCloudPerfs()
+	if err == nil {
+		result.Cloud = getCloudStatus(cloudperfs.Cloud)
+		if cloudperfs.SecondaryCloud != "" {
+			result.Secondary = getCloudStatus(cloudperfs.SecondaryCloud)
+		}
+		result.RemoteRoot = cloudperfs.GetRemoteRoot()
+		result.DryRun = cloudperfs.PerformDryRun
+		result.BiSync = cloudperfs.UseBiSync
+
+		dm := core.MakeGameDefManager(getUserOverrideLocation())
+		result.Games, err = core.GetSyncStatuses(context.Background(), core.MakeCloudManager(), dm, c.getGames(dm))
+		if err != nil {
+			return err
+		}
+	}
+
+	printResult(result, func() {
+		fmt.Printf("Version:\t%v\n", result.Version)
+		if result.Cloud == nil {
+			fmt.Println("Cloud:\t\tnone, set one with `cloud set`")
+			return
+		}
+
+		printCloud := func(label string, cloud *cloudStatus) {
+			if cloud.Error != "" {
+				fmt.Printf("%v\t%v (%v)\n", label, cloud.Id, cloud.Error)
+				return
+			}
+			fmt.Printf("%v\t%v (%v)\n", label, cloud.DisplayName, cloud.Id)
+		}
+
+		printCloud("Cloud:\t", result.Cloud)
+		if result.Secondary != nil {
+			printCloud("Secondary:", result.Secondary)
+		}
+		fmt.Printf("Cloud folder:\t%v\n", result.RemoteRoot)
+		fmt.Printf("Dry run:\t%v\n", result.DryRun)
+		fmt.Printf("Bisync:\t\t%v\n", result.BiSync)
+
+		for _, id := range result.NeedsReauth {
+			fmt.Printf("Sign in expired:\t%v, run `cloud reconnect --cloud %v`\n", id, id)
+		}
+
+		if len(result.Games) > 0 {
+			fmt.Println()
+		}
+		for _, game := range result.Games {
+			printSyncStatus(game)
+		}
+	})
+
+	return nil
+}
+
+// getGames returns the games given on the command line, or else every
+// tracked game.
+func (c *statusCommand) getGames(dm core.GameDefManager) []string {
+	if len(c.Args.Games) > 0 {
+		return c.Args.Games
+	}
+
+	games := []string{}
+	for key, gamedef := range dm.GetGameDefMap() {
+		if gamedef.Hidden || (c.Selected && !gamedef.SelectInMultisyncMenu) {
+			continue
+		}
+		games = append(games, key)
+	}
+	sort.Strings(games)
+
+	return games
+}
+
+var syncStatusLabels = map[string]string{
+	core.SyncStatusUpToDate:     "up to date",
+	core.SyncStatusLocalNewer:   "local newer",
+	core.SyncStatusRemoteNewer:  "cloud newer",
+	core.SyncStatusBothChanged:  "both changed",
+	core.SyncStatusLocalOnly:    "local only",
+	core.SyncStatusRemoteOnly:   "cloud only",
+	core.SyncStatusNotInstalled: "not installed",
+}
+
+func formatSide(side *core.SideSummary) string {
+	if side == nil || side.Files == 0 {
+		return "no files"
+	}
+
+	return fmt.Sprintf("%v files, %v bytes, newest %v", side.Files, side.Size, side.Newest.Local().Format("2006-01-02 15:04"))
+}
+
+func printSyncStatus(game *core.GameSyncStatus) {
+	if game.Error != "" {
+		fmt.Printf("%v:\terror: %v\n", game.Game, strings.TrimSpace(game.Error))
+		return
+	}
+
+	fmt.Printf("%v:\t%v\n", game.Game, syncStatusLabels[game.Status])
+	fmt.Printf("\tlocal:\t%v\n", formatSide(game.Local))
+	fmt.Printf("\tcloud:\t%v\n", formatSide(game.Remote))
+}
+
+type listCommand struct {
+	All bool `long:"all" description:"Include archived games"`
+}
+
+func (c *listCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	keys := []string{}
+	for key, gamedef := range dm.GetGameDefMap() {
+		if !gamedef.Hidden || c.All {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if isJSONOutput() {
+		games := make(map[string]*core.GameDef)
+		for _, key := range keys {
+			games[key] = dm.GetGameDefMap()[key]
+		}
+		printResult(games, nil)
+		return nil
+	}
+
+	for _, key := range keys {
+		gamedef := dm.GetGameDefMap()[key]
+		details := []string{}
+		if gamedef.Hidden {
+			details = append(details, "archived")
+		}
+		if gamedef.Storage != "" {
+			details = append(details, "cloud: "+gamedef.Storage)
+		}
+		if gamedef.RemotePath != "" {
+			details = append(details, "folder: "+gamedef.RemotePath)
+		}
+
+		if len(details) > 0 {
+			fmt.Printf("%v\t(%v)\n", key, strings.Join(details, ", "))
+		} else {
+			fmt.Println(key)
+		}
+	}
+
+	return nil
+}
+
+// gameDefOptions are the settings of a game shared by add and edit.
+type gameDefOptions struct {
+	DisplayName  string   `long:"display-name" description:"The name shown in the app"`
+	LinuxPath    []string `long:"linux-path" description:"A folder holding the game's saves on Linux. May be given more than once"`
+	WindowsPath  []string `long:"windows-path" description:"A folder holding the game's saves on Windows. May be given more than once"`
+	DarwinPath   []string `long:"darwin-path" description:"A folder holding the game's saves on macOS. May be given more than once"`
+	Include      string   `long:"include" description:"Only sync files matching this rclone filter, e.g. *.sav"`
+	SteamId      string   `long:"steam-id" description:"The game's Steam app id"`
+	CustomFlags  string   `long:"flags" description:"Extra flags passed to rclone when syncing the game"`
+	RemotePath   string   `long:"remote-path" description:"The folder the game's saves are kept in on the cloud, relative to the cloud save folder"`
+	Cloud        string   `long:"cloud" description:"The account the game syncs to, see cloud show. Use default for the current cloud"`
+	InstallPath  string   `long:"install-path" description:"The folder the game is installed in, to notice it running. Not needed for Steam games"`
+	JsonOverride string   `long:"json" description:"The whole game definition as JSON, in the format of gamedef_map.json"`
+	hookOptions
+}
+
+// hookOptions set the hooks of a game, or the global ones. See
+// docs/hooks.md.
+type hookOptions struct {
+	PreSync    string `long:"pre-sync" description:"A command run before syncing, the sync is skipped when it fails. Use none to remove it"`
+	PostSync   string `long:"post-sync" description:"A command run after syncing. Use none to remove it"`
+	OnConflict string `long:"on-conflict" description:"A command run before syncing when the saves changed both locally and in the cloud. Use none to remove it"`
+	OnFailure  string `long:"on-failure" description:"A command run when syncing failed. Use none to remove it"`
+}
+
+func applyHook(hook *string, command string) {
+	if command == "none" {
+		*hook = ""
+	} else if command != "" {
+		*hook = command
+	}
+}
+
+func (o *hookOptions) apply(hooks *core.SyncHooks) *core.SyncHooks {
+	if hooks == nil {
+		hooks = &core.SyncHooks{}
+	}
+
+	applyHook(&hooks.PreSync, o.PreSync)
+	applyHook(&hooks.PostSync, o.PostSync)
+	applyHook(&hooks.OnConflict, o.OnConflict)
+	applyHook(&hooks.OnFailure, o.OnFailure)
+	if hooks.IsEmpty() {
+		return nil
+	}
+	return hooks
+}
+
+// applyGame sets the hooks of game, which are kept on this device only.
+func (o *hookOptions) applyGame(game string) error {
+	if *o == (hookOptions{}) {
+		return nil
+	}
+
+	return core.SetGameSyncHooks(game, o.apply(core.GetGameSyncHooks(game)))
+}
+
+func makeDatapaths(paths []string, include string) []*core.Datapath {
+	result := []*core.Datapath{}
+	for _, path := range paths {
+		result = append(result, &core.Datapath{
+			Path:    path,
+			Include: include,
+		})
+	}
+
+	return result
+}
+
+func (o *gameDefOptions) apply(gamedef *core.GameDef) error {
+	if o.JsonOverride != "" {
+		return json.Unmarshal([]byte(o.JsonOverride), gamedef)
+	}
+
+	if o.DisplayName != "" {
+		gamedef.DisplayName = o.DisplayName
+	}
+	if len(o.LinuxPath) > 0 {
+		gamedef.LinuxPath = makeDatapaths(o.LinuxPath, o.Include)
+	}
+	if len(o.WindowsPath) > 0 {
+		gamedef.WinPath = makeDatapaths(o.WindowsPath, o.Include)
+	}
+	if len(o.DarwinPath) > 0 {
+		gamedef.DarwinPath = makeDatapaths(o.DarwinPath, o.Include)
+	}
+	if o.SteamId != "" {
+		gamedef.SteamId = o.SteamId
+	}
+	if o.CustomFlags != "" {
+		gamedef.CustomFlags = o.CustomFlags
+	}
+	if o.RemotePath != "" {
+		gamedef.RemotePath = o.RemotePath
+	}
+	if o.InstallPath != "" {
+		gamedef.InstallPath = o.InstallPath
+	}
+
+	if o.Cloud == "default" {
+		gamedef.Storage = ""
+	} else if o.Cloud != "" {
+		_, err := core.GetStorageProvider(o.Cloud)
+		if err != nil {
+			return err
+		}
+		gamedef.Storage = o.Cloud
+	}
+
+	return nil
+}
+
+type addCommand struct {
+	gameDefOptions
+	Args struct {
+		Game string `positional-arg-name:"GAME" description:"The name the game is synced under"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *addCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	existing, ok := dm.GetGameDefMap()[c.Args.Game]
+	if ok && !existing.Hidden {
+		return fmt.Errorf("%v already exists, use edit to change it", c.Args.Game)
+	}
+
+	if c.JsonOverride == "" && len(c.LinuxPath)+len(c.WindowsPath)+len(c.DarwinPath) == 0 {
+		return fmt.Errorf("a game needs at least one save folder, e.g. --linux-path ~/.local/share/MyGame/saves")
+	}
+
+	gamedef := &core.GameDef{
+		DisplayName: c.Args.Game,
+	}
+	err := c.apply(gamedef)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(gamedef)
+	if err != nil {
+		return err
+	}
+
+	err = dm.AddUserOverride(c.Args.Game, string(data))
+	if err != nil {
+		return err
+	}
+
+	err = c.applyGame(c.Args.Game)
+	if err != nil {
+		return err
+	}
+
+	printResult(gamedef, func() {
+		fmt.Println("Game Added!")
+	})
+	return nil
+}
+
+type editCommand struct {
+	gameDefOptions
+	Args struct {
+		Game string `positional-arg-name:"GAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *editCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	gamedef, ok := dm.GetGameDefMap()[c.Args.Game]
+	if !ok {
+		return core.WithErrorKind(core.ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", c.Args.Game))
+	}
+
+	err := c.apply(gamedef)
+	if err != nil {
+		return err
+	}
+
+	err = dm.CommitUserOverrides()
+	if err != nil {
+		return err
+	}
+
+	err = c.applyGame(c.Args.Game)
+	if err != nil {
+		return err
+	}
+
+	printResult(gamedef, func() {
+		fmt.Println("Game Updated!")
+	})
+	return nil
+}
+
+type removeCommand struct {
+	Args struct {
+		Games []string `positional-arg-name:"GAME" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *removeCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	for _, game := range c.Args.Games {
+		_, ok := dm.GetGameDefMap()[game]
+		if !ok {
+			return core.WithErrorKind(core.ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", game))
+		}
+		dm.RemoveGameDef(game)
+	}
+
+	err := dm.CommitUserOverrides()
+	if err != nil {
+		return err
+	}
+
+	for _, game := range c.Args.Games {
+		err = core.SetGameSyncHooks(game, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	printResult(map[string]interface{}{"games": c.Args.Games}, func() {
+		fmt.Println("Games Removed!")
+	})
+	return nil
+}
+
+// getSecondary returns the secondary storage. The accounts games sync to are
+// created by the operations themselves, as games may use different ones.
+func getSecondary(ctx context.Context, cm *core.CloudManager) (core.Storage, error) {
+	secondary, err := core.GetSecondaryCloudStorage()
+	if err != nil {
+		return nil, err
+	}
+	if secondary == nil {
+		return nil, fmt.Errorf("no secondary cloud is set, please set one with `cloud secondary`")
+	}
+
+	err = cm.CreateDriveIfNotExists(ctx, secondary)
+	if err != nil {
+		return nil, err
+	}
+
+	return secondary, nil
+}
+
+type restoreCommand struct {
+	Args struct {
+		Games []string `positional-arg-name:"GAME"`
+	} `positional-args:"yes"`
+}
+
+func (c *restoreCommand) Execute(args []string) error {
+	ctx := context.Background()
+	cm := core.MakeCloudManager()
+	secondary, err := getSecondary(ctx, cm)
+	if err != nil {
+		return err
+	}
+
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	if len(c.Args.Games) == 0 {
+		result, err := cm.RestoreFromSecondary(ctx, dm, secondary)
+		if err != nil {
+			return err
+		}
+
+		printResult(map[string]interface{}{"output": result}, func() {
+			fmt.Println(result)
+		})
+		return nil
+	}
+
+	results := make(map[string]string)
+	for _, game := range c.Args.Games {
+		gamedef, ok := dm.GetGameDefMap()[game]
+		if !ok {
+			return core.WithErrorKind(core.ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", game))
+		}
+
+		remotePath, err := core.GetGameRemotePath(game, gamedef)
+		if err != nil {
+			return err
+		}
+
+		storage, err := core.GetGameStorage(gamedef)
+		if err != nil {
+			return err
+		}
+
+		err = cm.CreateDriveIfNotExists(ctx, storage)
+		if err != nil {
+			return err
+		}
+
+		result, err := cm.RestoreGameFromSecondary(ctx, storage, secondary, remotePath)
+		if err != nil {
+			return err
+		}
+		results[game] = result
+	}
+
+	printResult(map[string]interface{}{"games": results}, func() {
+		for _, game := range c.Args.Games {
+			fmt.Println(results[game])
+		}
+	})
+	return nil
+}
+
+type snapshotsCommand struct{}
+
+func (c *snapshotsCommand) Execute(args []string) error {
+	secondary, err := core.GetSecondaryCloudStorage()
+	if err != nil {
+		return err
+	}
+	if secondary == nil {
+		return fmt.Errorf("no secondary cloud is set, please set one with `cloud secondary`")
+	}
+
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	saves, err := core.MakeCloudManager().ListSecondarySaves(context.Background(), dm, secondary)
+	if err != nil {
+		return err
+	}
+
+	printResult(saves, func() {
+		for _, save := range saves {
+			fmt.Printf("%v\t%v\n", save.Name, save.ModTime)
+		}
+	})
+
+	return nil
+}
+
+type doctorCommand struct{}
+
+func printChecks(checks []*core.DoctorCheck) {
+	for _, check := range checks {
+		fmt.Printf("[%v]\t%v: %v\n", strings.ToUpper(check.Status), check.Name, check.Message)
+		if check.Hint != "" && check.Status != core.DoctorPass {
+			fmt.Printf("\t-> %v\n", check.Hint)
+		}
+	}
+}
+
+func (c *doctorCommand) Execute(args []string) error {
+	checks := core.RunDoctor(context.Background(), core.MakeCloudManager())
+	printResult(checks, func() {
+		printChecks(checks)
+	})
+	if core.DoctorFailed(checks) {
+		return fmt.Errorf("some checks failed")
+	}
+
+	return nil
+}
+
+type diagnosticsCommand struct {
+	Args struct {
+		Zip string `positional-arg-name:"ZIP"`
+	} `positional-args:"yes"`
+}
+
+func (c *diagnosticsCommand) Execute(args []string) error {
+	path := c.Args.Zip
+	if path == "" {
+		path = core.DefaultDiagnosticsFilename()
+	}
+
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	err := core.ExportDiagnostics(context.Background(), dm, path)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"path": path}, func() {
+		fmt.Println("Diagnostics written to " + path)
+	})
+	return nil
+}
+
+type cloudSetCommand struct {
+	Name     string            `long:"name" description:"Adds a named account of the provider, e.g. a second Google Drive. An existing account with this name is reused"`
+	Settings map[string]string `long:"setting" description:"KEY:VALUE Configures a setting of the cloud. See cloud show for the available settings"`
+	Args     struct {
+		Cloud string `positional-arg-name:"ID" description:"A provider id, e.g. google, or an account id"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *cloudSetCommand) Execute(args []string) error {
+	cloud, err := core.ResolveStorageProviderId(c.Args.Cloud)
+	if err != nil {
+		return err
+	}
+
+	if c.Name != "" {
+		instance, err := core.GetOrAddStorageInstance(cloud, c.Name)
+		if err != nil {
+			return err
+		}
+		cloud = instance.Id
+	}
+
+	if len(c.Settings) > 0 {
+		_, err = core.ConfigureStorageProvider(context.Background(), core.MakeCloudManager(), cloud, c.Settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = core.UpdateCloudProvider(cloud)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"cloud": cloud}, func() {
+		fmt.Println("Cloud Set!")
+	})
+	return nil
+}
+
+type cloudShowCommand struct{}
+
+func (c *cloudShowCommand) Execute(args []string) error {
+	providers := core.GetStorageProviders()
+	instances := core.GetStorageInstances()
+	data := map[string]interface{}{
+		"providers": providers,
+		"accounts":  instances,
+	}
+
+	printResult(data, func() {
+		for _, provider := range providers {
+			fmt.Printf("%v\t%v\n", provider.Id, provider.DisplayName)
+			for _, field := range provider.Fields {
+				fmt.Printf("\t%v\t%v (required: %v, secret: %v, default: %q)\n", field.Key, field.Label, field.Required, field.Secret, field.Default)
+			}
+		}
+
+		fmt.Println()
+		fmt.Println("Accounts:")
+		for _, instance := range instances {
+			fmt.Printf("%v\t%v (%v)\n", instance.Id, instance.Name, instance.Provider)
+		}
+	})
+
+	return nil
+}
+
+type cloudTestCommand struct{}
+
+func (c *cloudTestCommand) Execute(args []string) error {
+	checks := core.CheckCurrentCloud(context.Background(), core.MakeCloudManager())
+	printResult(checks, func() {
+		printChecks(checks)
+	})
+	if core.DoctorFailed(checks) {
+		return fmt.Errorf("the cloud could not be reached")
+	}
+
+	return nil
+}
+
+type cloudSecondaryCommand struct {
+	Args struct {
+		Cloud string `positional-arg-name:"ID" description:"A provider or account id, or none"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *cloudSecondaryCommand) Execute(args []string) error {
+	cloud := ""
+	if c.Args.Cloud != "none" {
+		var err error
+		cloud, err = core.ResolveStorageProviderId(c.Args.Cloud)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := core.UpdateSecondaryCloudProvider(cloud)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"secondary": cloud}, func() {
+		fmt.Println("Secondary Cloud Set!")
+	})
+	return nil
+}
+
+type cloudHealCommand struct{}
+
+func (c *cloudHealCommand) Execute(args []string) error {
+	ctx := context.Background()
+	cm := core.MakeCloudManager()
+	secondary, err := getSecondary(ctx, cm)
+	if err != nil {
+		return err
+	}
+
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	result, err := cm.HealSecondary(ctx, dm, secondary)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"output": result}, func() {
+		fmt.Println(result)
+	})
+	return nil
+}
+
+type cloudRootCommand struct {
+	Args struct {
+		Path string `positional-arg-name:"PATH"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *cloudRootCommand) Execute(args []string) error {
+	storage, err := core.GetCurrentCloudStorage()
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	err = cm.CreateDriveIfNotExists(context.Background(), storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := core.UpdateRemoteRoot(context.Background(), cm, core.MakeGameDefManager(getUserOverrideLocation()), c.Args.Path)
+	if err != nil {
+		return err
+	}
+
+	core.InfoLogger.Println(result)
+	printResult(map[string]interface{}{"remoteRoot": c.Args.Path}, func() {
+		fmt.Println("Remote Root Set!")
+	})
+	return nil
+}
+
+type cloudRenameCommand struct {
+	Args struct {
+		Id   string `positional-arg-name:"ID"`
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *cloudRenameCommand) Execute(args []string) error {
+	err := core.RenameStorageInstance(c.Args.Id, c.Args.Name)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"id": c.Args.Id, "name": c.Args.Name}, func() {
+		fmt.Println("Cloud Renamed!")
+	})
+	return nil
+}
+
+type cloudRemoveCommand struct {
+	Args struct {
+		Id string `positional-arg-name:"ID"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *cloudRemoveCommand) Execute(args []string) error {
+	err := core.RemoveStorageInstance(context.Background(), core.MakeCloudManager(), c.Args.Id)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"id": c.Args.Id}, func() {
+		fmt.Println("Cloud Removed!")
+	})
+	return nil
+}
+
+type cloudAuthorizeCommand struct {
+	Cloud string `long:"cloud" description:"The provider or account to sign in to. Defaults to the current cloud"`
+}
+
+func (c *cloudAuthorizeCommand) Execute(args []string) error {
+	cloud, err := resolveCloud(c.Cloud)
+	if err != nil {
+		return err
+	}
+
+	return authorizeHeadless(cloud)
+}
+
+type cloudReconnectCommand struct {
+	Cloud string `long:"cloud" description:"The provider or account to sign in to. Defaults to the current cloud"`
+}
+
+func (c *cloudReconnectCommand) Execute(args []string) error {
+	cloud, err := resolveCloud(c.Cloud)
+	if err != nil {
+		return err
+	}
+
+	return reconnect(cloud)
+}
+
+type settingsSyncCommand struct{}
+
+func (c *settingsSyncCommand) Execute(args []string) error {
+	return syncUserSettings(getUserOverrideLocation())
+}
+
+type settingsHooksCommand struct {
+	hookOptions
+}
+
+func (c *settingsHooksCommand) Execute(args []string) error {
+	hooks := c.hookOptions.apply(core.GetSyncHooks())
+	if c.hookOptions != (hookOptions{}) {
+		err := core.SetSyncHooks(hooks)
+		if err != nil {
+			return err
+		}
+	}
+	if hooks == nil {
+		hooks = &core.SyncHooks{}
+	}
+
+	printResult(hooks, func() {
+		if hooks.IsEmpty() {
+			fmt.Println("No hooks are set")
+			return
+		}
+
+		for _, hook := range []struct{ event, command string }{
+			{core.HookPreSync, hooks.PreSync},
+			{core.HookPostSync, hooks.PostSync},
+			{core.HookOnConflict, hooks.OnConflict},
+			{core.HookOnFailure, hooks.OnFailure},
+		} {
+			if hook.command != "" {
+				fmt.Printf("%v: %v\n", hook.event, hook.command)
+			}
+		}
+	})
+	return nil
+}
+
+type recordsSearchCommand struct {
+	Limit int `long:"limit" default:"50" description:"The maximum number of games to list"`
+	Args  struct {
+		Query string `positional-arg-name:"QUERY"`
+	} `positional-args:"yes"`
+}
+
+func (c *recordsSearchCommand) Execute(args []string) error {
+	query := strings.ToLower(c.Args.Query)
+	type match struct {
+		Name    string `json:"name"`
+		SteamId int    `json:"steamId,omitempty"`
+	}
+
+	matches := []match{}
+	err := core.GetGameRecordManager().VisitGameRecords(func(key string, record *core.GameRecord) error {
+		if strings.Contains(strings.ToLower(key), query) {
+			matches = append(matches, match{Name: key, SteamId: record.Steam.Id})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Name < matches[j].Name
+	})
+
+	total := len(matches)
+	if c.Limit > 0 && total > c.Limit {
+		matches = matches[:c.Limit]
+	}
+
+	data := map[string]interface{}{
+		"total":   total,
+		"matches": matches,
+	}
+	printResult(data, func() {
+		for _, m := range matches {
+			if m.SteamId != 0 {
+				fmt.Printf("%v\t(steam: %v)\n", m.Name, m.SteamId)
+			} else {
+				fmt.Println(m.Name)
+			}
+		}
+
+		if total > len(matches) {
+			fmt.Printf("... and %v more, use --limit to see them\n", total-len(matches))
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,727 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const APITokenFilename = "api_token"
+
+const APIHistoryFilename = "api_history.json"
+const DefaultAPIAddress = "127.0.0.1:7373"
+
+// Older syncs are dropped from the history
+const maxRecordedAPISyncs = 100
+
+const (
+	APISyncRunning  = "running"
+	APISyncDone     = "done"
+	APISyncFailed   = "failed"
+	APISyncCanceled = "canceled"
+)
+
+var errAPISyncRunning = errors.New("a sync is already running")
+
+// APISync is a sync started through the API.
+type APISync struct {
+	Id        string     `json:"id"`
+	Games     []string   `json:"games"`
+	DryRun    bool       `json:"dryRun"`
+	State     string     `json:"state"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Failed    []string   `json:"failed,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ErrorKind string     `json:"errorKind,omitempty"`
+}
+
+// APIEvent is sent to the clients following a sync, see handleSyncEvents.
+type APIEvent struct {
+	// progress for ProgressEvents, message for log lines
+	Type    string         `json:"type"`
+	Event   *ProgressEvent `json:"event,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// APISyncRequest is the body of POST /v1/syncs.
+type APISyncRequest struct {
+	// The games to sync. The games selected for multisync are synced when
+	// Selected is set, every tracked game when neither is
+	Games    []string `json:"games,omitempty"`
+	Selected bool     `json:"selected,omitempty"`
+	DryRun   bool     `json:"dryRun,omitempty"`
+}
+
+// APIGame is a tracked game as listed by GET /v1/games.
+type APIGame struct {
+	Game        string `json:"game"`
+	DisplayName string `json:"displayName"`
+	Selected    bool   `json:"selected"`
+	Storage     string `json:"storage,omitempty"`
+}
+
+// APIHistory is returned by GET /v1/history.
+type APIHistory struct {
+	Syncs     []*APISync              `json:"syncs"`
+	Sessions  []*GameSession          `json:"sessions"`
+	Schedules map[string]*ScheduleRun `json:"schedules"`
+}
+
+type apiError struct {
+	Error     string `json:"error"`
+	ErrorKind string `json:"errorKind,omitempty"`
+}
+
+// apiJob is a sync along with the events sent while it runs.
+type apiJob struct {
+	sync    *APISync
+	events  []*APIEvent
+	cancel  context.CancelFunc
+	done    chan struct{}
+	updated chan struct{}
+}
+
+// APIServer serves the local HTTP API integrations drive syncs with. Every
+// request needs the token, see GetAPIToken. Only one sync runs at a time.
+type APIServer struct {
+	cm    *CloudManager
+	dm    GameDefManager
+	ops   *Options
+	token string
+
+	mtx    sync.Mutex
+	jobs   map[string]*apiJob
+	order  []string
+	nextId int
+	wg     sync.WaitGroup
+}
+
+var apiHistoryMtx sync.Mutex
+
+func getAPITokenPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + APITokenFilename, nil
+}
+
+// GetAPIToken returns the token of the API, which is created on first use.
+// Clients read it from the token file, which only the user can read.
+func GetAPIToken() (string, string, error) {
+	path, err := getAPITokenPath()
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), path, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+
+	token, err := RotateAPIToken()
+	return token, path, err
+}
+
+// RotateAPIToken replaces the token of the API, clients using the old one
+// are refused from then on.
+func RotateAPIToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := getAPITokenPath()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(path, []byte(token+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return token, os.Chmod(path, 0600)
+}
+
+func getAPIHistoryPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + APIHistoryFilename, nil
+}
+
+// GetAPISyncHistory returns the syncs that finished, oldest first.
+func GetAPISyncHistory() ([]*APISync, error) {
+	apiHistoryMtx.Lock()
+	defer apiHistoryMtx.Unlock()
+	return readAPISyncHistory()
+}
+
+func readAPISyncHistory() ([]*APISync, error) {
+	result := []*APISync{}
+	path, err := getAPIHistoryPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func recordAPISync(apiSync *APISync) error {
+	apiHistoryMtx.Lock()
+	defer apiHistoryMtx.Unlock()
+
+	syncs, err := readAPISyncHistory()
+	if err != nil {
+		return err
+	}
+
+	syncs = append(syncs, apiSync)
+	if len(syncs) > maxRecordedAPISyncs {
+		syncs = syncs[len(syncs)-maxRecordedAPISyncs:]
+	}
+
+	data, err := json.Marshal(syncs)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getAPIHistoryPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// NewAPIServer returns the API, syncing with ops and accepting token.
+func NewAPIServer(cm *CloudManager, dm GameDefManager, ops *Options, token string) *APIServer {
+	return &APIServer{
+		cm:    cm,
+		dm:    dm,
+		ops:   ops,
+		token: token,
+		jobs:  make(map[string]*apiJob),
+	}
+}
+
+// isLoopbackHost tells whether host, with or without a port, is this
+// computer.
+func isLoopbackHost(host string) bool {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = strings.Trim(host, "[]")
+	}
+
+	if strings.EqualFold(hostname, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeAPI serves server on address, which must be a loopback address, until
+// ctx is done. Syncs that are running are finished before it returns.
+// listening is called once connections are accepted.
+func ServeAPI(ctx context.Context, address string, server *APIServer, listening func(addr net.Addr)) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return WithErrorKind(ErrorKindUsage, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return WithErrorKind(ErrorKindUsage, fmt.Errorf("the API only listens on loopback addresses like %v", DefaultAPIAddress))
+	}
+
+	err = CheckSecretsUnlocked()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		// Clients following syncs are let go, the syncs go on until they
+		// are finished
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if listening != nil {
+		listening(listener.Addr())
+	}
+
+	err = httpServer.Serve(listener)
+	server.wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(value)
+	if err != nil {
+		ErrorLogger.Println(err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, &apiError{Error: err.Error(), ErrorKind: GetErrorKind(err)})
+}
+
+func (s *APIServer) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		// EventSource in browsers can not set headers
+		token = r.URL.Query().Get("token")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Keeps web pages from reaching the API through DNS rebinding
+	if !isLoopbackHost(r.Host) {
+		writeAPIError(w, http.StatusForbidden, fmt.Errorf("the API is only served to localhost"))
+		return
+	}
+
+	if !s.authorized(r) {
+		writeAPIError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token, see api token"))
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	parts := strings.Split(strings.TrimPrefix(path, "/v1/"), "/")
+	if !strings.HasPrefix(path, "/v1/") {
+		parts = nil
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "games" && r.Method == http.MethodGet:
+		s.handleGames(w, r)
+	case len(parts) == 1 && parts[0] == "status" && r.Method == http.MethodGet:
+		s.handleStatus(w, r)
+	case len(parts) == 1 && parts[0] == "history" && r.Method == http.MethodGet:
+		s.handleHistory(w, r)
+	case len(parts) == 1 && parts[0] == "syncs" && r.Method == http.MethodGet:
+		s.handleSyncs(w, r)
+	case len(parts) == 1 && parts[0] == "syncs" && r.Method == http.MethodPost:
+		s.handleStartSync(w, r)
+	case len(parts) == 2 && parts[0] == "syncs" && r.Method == http.MethodGet:
+		s.handleSync(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "syncs" && parts[2] == "events" && r.Method == http.MethodGet:
+		s.handleSyncEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "syncs" && parts[2] == "cancel" && r.Method == http.MethodPost:
+		s.handleCancelSync(w, r, parts[1])
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no endpoint %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// trackedGames returns the tracked games, picking up games that were added
+// or changed by other instances.
+func (s *APIServer) trackedGames() []string {
+	err := s.dm.ApplyUserOverrides()
+	if err != nil {
+		WarnLogger.Println(err)
+	}
+
+	games := []string{}
+	for gamename, gamedef := range s.dm.GetGameDefMap() {
+		if !gamedef.Hidden {
+			games = append(games, gamename)
+		}
+	}
+	sort.Strings(games)
+
+	return games
+}
+
+func (s *APIServer) handleGames(w http.ResponseWriter, r *http.Request) {
+	games := []*APIGame{}
+	for _, gamename := range s.trackedGames() {
+		gamedef := s.dm.GetGameDefMap()[gamename]
+		games = append(games, &APIGame{
+			Game:        gamename,
+			DisplayName: gamedef.DisplayName,
+			Selected:    gamedef.SelectInMultisyncMenu,
+			Storage:     gamedef.Storage,
+		})
+	}
+
+	writeAPIJSON(w, http.StatusOK, games)
+}
+
+// handleStatus compares the local and remote saves of the games given by
+// the game query parameters, or of every tracked game.
+func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	games := r.URL.Query()["game"]
+	if len(games) == 0 {
+		games = s.trackedGames()
+	}
+
+	statuses, err := GetSyncStatuses(r.Context(), s.cm, s.dm, games)
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, statuses)
+}
+
+func (s *APIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	history := &APIHistory{}
+	var err error
+	history.Syncs, err = GetAPISyncHistory()
+	if err == nil {
+		history.Sessions, err = GetSessions()
+	}
+	if err == nil {
+		history.Schedules, err = GetScheduleRuns()
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, history)
+}
+
+// handleSyncs lists the syncs started since the API was started.
+func (s *APIServer) handleSyncs(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	syncs := []APISync{}
+	for _, id := range s.order {
+		syncs = append(syncs, *s.jobs[id].sync)
+	}
+	s.mtx.Unlock()
+
+	writeAPIJSON(w, http.StatusOK, syncs)
+}
+
+func (s *APIServer) getJob(w http.ResponseWriter, id string) *apiJob {
+	s.mtx.Lock()
+	job, ok := s.jobs[id]
+	s.mtx.Unlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no sync %v", id))
+		return nil
+	}
+
+	return job
+}
+
+func (s *APIServer) handleSync(w http.ResponseWriter, r *http.Request, id string) {
+	job := s.getJob(w, id)
+	if job == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	snapshot := *job.sync
+	s.mtx.Unlock()
+	writeAPIJSON(w, http.StatusOK, &snapshot)
+}
+
+func (s *APIServer) handleStartSync(w http.ResponseWriter, r *http.Request) {
+	request := &APISyncRequest{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, WithErrorKind(ErrorKindUsage, err))
+			return
+		}
+	}
+
+	tracked := s.trackedGames()
+	games := request.Games
+	for _, game := range games {
+		if !containsString(tracked, game) {
+			writeAPIError(w, http.StatusBadRequest, WithErrorKind(ErrorKindUnknownGame, fmt.Errorf("unknown game %v", game)))
+			return
+		}
+	}
+	if len(games) == 0 {
+		for _, game := range tracked {
+			if !request.Selected || s.dm.GetGameDefMap()[game].SelectInMultisyncMenu {
+				games = append(games, game)
+			}
+		}
+	}
+
+	started, err := s.startSync(games, request.DryRun)
+	if errors.Is(err, errAPISyncRunning) {
+		writeAPIError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusAccepted, started)
+}
+
+// startSync syncs games in the background and returns the sync.
+func (s *APIServer) startSync(games []string, dryRun bool) (*APISync, error) {
+	if GetCurrentStorageProvider() == nil {
+		return nil, WithErrorKind(ErrorKindNoCloud, ErrNoCloud)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, job := range s.jobs {
+		if job.sync.State == APISyncRunning {
+			return nil, errAPISyncRunning
+		}
+	}
+
+	s.nextId++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &apiJob{
+		sync: &APISync{
+			Id:      fmt.Sprintf("%v-%v", time.Now().Unix(), s.nextId),
+			Games:   games,
+			DryRun:  dryRun,
+			State:   APISyncRunning,
+			Started: time.Now().Round(0),
+		},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		updated: make(chan struct{}),
+	}
+	s.jobs[job.sync.Id] = job
+	s.order = append(s.order, job.sync.Id)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.runSync(ctx, job)
+	}()
+
+	snapshot := *job.sync
+	return &snapshot, nil
+}
+
+// addEvent adds event to the events of job and wakes up its followers.
+func (s *APIServer) addEvent(job *apiJob, event *APIEvent) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job.events = append(job.events, event)
+	close(job.updated)
+	job.updated = make(chan struct{})
+}
+
+func (s *APIServer) runSync(ctx context.Context, job *apiJob) {
+	syncops := *s.ops
+	syncops.Gamenames = job.sync.Games
+	// Makes every step of the sync a progress event
+	syncops.Output = OutputJSON
+	if job.sync.DryRun {
+		syncops.DryRun = []bool{true}
+	}
+
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RequestMainOperation(ctx, s.cm, &syncops, s.dm, channels)
+		close(channels.Logs)
+	}()
+
+	var syncErr error
+	failed := []string{}
+	for msg := range channels.Logs {
+		event := &APIEvent{Type: "message", Message: msg.Message}
+		if msg.Event != nil {
+			event = &APIEvent{Type: "progress", Event: msg.Event}
+			if msg.Event.Phase == PhaseError && !containsString(failed, msg.Event.Game) {
+				failed = append(failed, msg.Event.Game)
+			}
+		} else if msg.Err != nil {
+			event.Error = msg.Err.Error()
+			if syncErr == nil {
+				syncErr = msg.Err
+			}
+		} else if msg.Message == "" {
+			continue
+		}
+		s.addEvent(job, event)
+	}
+
+	s.mtx.Lock()
+	finished := time.Now().Round(0)
+	job.sync.Finished = &finished
+	job.sync.Failed = failed
+	switch {
+	case ctx.Err() != nil:
+		job.sync.State = APISyncCanceled
+	case syncErr != nil || len(failed) > 0:
+		job.sync.State = APISyncFailed
+	default:
+		job.sync.State = APISyncDone
+	}
+	if syncErr != nil {
+		job.sync.Error = syncErr.Error()
+		job.sync.ErrorKind = GetErrorKind(syncErr)
+	}
+	snapshot := *job.sync
+	close(job.done)
+	close(job.updated)
+	job.updated = make(chan struct{})
+	s.mtx.Unlock()
+
+	err := recordAPISync(&snapshot)
+	if err != nil {
+		ErrorLogger.Println(err)
+	}
+}
+
+func (s *APIServer) handleCancelSync(w http.ResponseWriter, r *http.Request, id string) {
+	job := s.getJob(w, id)
+	if job == nil {
+		return
+	}
+
+	job.cancel()
+	<-job.done
+
+	s.mtx.Lock()
+	snapshot := *job.sync
+	s.mtx.Unlock()
+	writeAPIJSON(w, http.StatusOK, &snapshot)
+}
+
+// handleSyncEvents streams the events of a sync as Server-Sent Events, from
+// its start. The stream ends with a result event holding the finished sync.
+func (s *APIServer) handleSyncEvents(w http.ResponseWriter, r *http.Request, id string) {
+	job := s.getJob(w, id)
+	if job == nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sent := 0
+	for {
+		s.mtx.Lock()
+		events := job.events[sent:]
+		updated := job.updated
+		var result *APISync
+		select {
+		case <-job.done:
+			snapshot := *job.sync
+			result = &snapshot
+		default:
+		}
+		s.mtx.Unlock()
+
+		for _, event := range events {
+			writeServerSentEvent(w, event.Type, event)
+		}
+		sent += len(events)
+
+		if result != nil {
+			writeServerSentEvent(w, "result", result)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updated:
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, name string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		ErrorLogger.Println(err)
+		return
+	}
+
+	fmt.Fprintf(w, "event: %v\ndata: %s\n\n", name, data)
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A fake rclone whose syncs never finish
+const fakeSlowSyncRclone = `#!/bin/sh
+case "$*" in
+*lsjson*)
+	echo "[]"
+	;;
+*)
+	exec sleep 10
+	;;
+esac
+`
+
+func setupAPIServer(t *testing.T, rclone string) *httptest.Server {
+	server := httptest.NewServer(NewAPIServer(MakeCloudManager(), setupRunGame(t, rclone), &Options{}, "secret"))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func apiRequest(t *testing.T, server *httptest.Server, method string, path string, body string, result interface{}) int {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	response, err := server.Client().Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	if result != nil {
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(result))
+	}
+	return response.StatusCode
+}
+
+// readSyncEvents follows the events of a sync until its result, returning
+// the event names and the finished sync.
+func readSyncEvents(t *testing.T, server *httptest.Server, id string) ([]string, *APISync) {
+	response, err := server.Client().Get(fmt.Sprintf("%v/v1/syncs/%v/events?token=secret", server.URL, id))
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	names := []string{}
+	scanner := bufio.NewScanner(response.Body)
+	name := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			name = strings.TrimPrefix(line, "event: ")
+			names = append(names, name)
+		}
+		if strings.HasPrefix(line, "data: ") && name == "result" {
+			result := &APISync{}
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), result))
+			return names, result
+		}
+	}
+
+	t.Fatal("the events ended without a result")
+	return nil, nil
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	server := setupAPIServer(t, fakeSyncRclone)
+
+	response, err := server.Client().Get(server.URL + "/v1/games")
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	response, err = server.Client().Get(server.URL + "/v1/games?token=wrong")
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/v1/games", nil)
+	assert.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Host = "attacker.example:7373"
+	response, err = server.Client().Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	assert.Equal(t, http.StatusNotFound, apiRequest(t, server, http.MethodGet, "/v1/nothing", "", nil))
+}
+
+func TestAPISync(t *testing.T) {
+	server := setupAPIServer(t, fakeSyncRclone)
+
+	games := []*APIGame{}
+	assert.Equal(t, http.StatusOK, apiRequest(t, server, http.MethodGet, "/v1/games", "", &games))
+	assert.Contains(t, games, &APIGame{Game: "Hollow", DisplayName: "Hollow", Selected: true})
+
+	statuses := []*GameSyncStatus{}
+	assert.Equal(t, http.StatusOK, apiRequest(t, server, http.MethodGet, "/v1/status?game=Hollow", "", &statuses))
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, SyncStatusUpToDate, statuses[0].Status)
+
+	failure := &apiError{}
+	assert.Equal(t, http.StatusBadRequest, apiRequest(t, server, http.MethodPost, "/v1/syncs", `{"games":["Hdes"]}`, failure))
+	assert.Equal(t, ErrorKindUnknownGame, failure.ErrorKind)
+
+	started := &APISync{}
+	assert.Equal(t, http.StatusAccepted, apiRequest(t, server, http.MethodPost, "/v1/syncs", `{"selected":true}`, started))
+	assert.Equal(t, []string{"Hollow"}, started.Games)
+
+	names, result := readSyncEvents(t, server, started.Id)
+	assert.Contains(t, names, "progress")
+	assert.Equal(t, "result", names[len(names)-1])
+	assert.Equal(t, APISyncDone, result.State)
+
+	// Followers joining late get every event
+	late, _ := readSyncEvents(t, server, started.Id)
+	assert.Equal(t, names, late)
+
+	history := &APIHistory{}
+	assert.Equal(t, http.StatusOK, apiRequest(t, server, http.MethodGet, "/v1/history", "", history))
+	assert.Len(t, history.Syncs, 1)
+	assert.Equal(t, started.Id, history.Syncs[0].Id)
+	assert.Equal(t, APISyncDone, history.Syncs[0].State)
+}
+
+func TestAPICancelSync(t *testing.T) {
+	server := setupAPIServer(t, fakeSlowSyncRclone)
+
+	started := &APISync{}
+	assert.Equal(t, http.StatusAccepted, apiRequest(t, server, http.MethodPost, "/v1/syncs", `{"games":["Hollow"],"dryRun":true}`, started))
+	assert.True(t, started.DryRun)
+
+	// One sync runs at a time
+	assert.Equal(t, http.StatusConflict, apiRequest(t, server, http.MethodPost, "/v1/syncs", "", nil))
+
+	start := time.Now()
+	canceled := &APISync{}
+	assert.Equal(t, http.StatusOK, apiRequest(t, server, http.MethodPost, "/v1/syncs/"+started.Id+"/cancel", "", canceled))
+	assert.Equal(t, APISyncCanceled, canceled.State)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	syncs := []*APISync{}
+	assert.Equal(t, http.StatusOK, apiRequest(t, server, http.MethodGet, "/v1/syncs", "", &syncs))
+	assert.Len(t, syncs, 1)
+	assert.Equal(t, APISyncCanceled, syncs[0].State)
+
+	assert.Equal(t, http.StatusNotFound, apiRequest(t, server, http.MethodPost, "/v1/syncs/nope/cancel", "", nil))
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	assert.True(t, isLoopbackHost("127.0.0.1:7373"))
+	assert.True(t, isLoopbackHost("localhost:7373"))
+	assert.True(t, isLoopbackHost("[::1]:7373"))
+	assert.True(t, isLoopbackHost("localhost"))
+	assert.False(t, isLoopbackHost("192.168.1.2:7373"))
+	assert.False(t, isLoopbackHost("example.com"))
+}
@@ -6,14 +6,28 @@ import (
 )
 
 type BoxStorage struct {
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (gs *BoxStorage) GetName() string {
+	if gs.Remote != "" {
+		return gs.Remote
+	}
+
 	return "opencloudsave-box"
 }
 
 func (gs *BoxStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
-	return makeCommand(ctx, getCloudApp(), "config", "create", gs.GetName(), "box")
+	return makeOAuthCreationCommand(ctx, gs)
+}
+
+func (gs *BoxStorage) GetRcloneType() string {
+	return "box"
+}
+
+func (gs *BoxStorage) GetConfigParameters() map[string]string {
+	return map[string]string{}
 }
 
 var boxStorage *BoxStorage
@@ -25,3 +39,15 @@ func GetBoxStorage() Storage {
 
 	return boxStorage
 }
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          BOX,
+		DisplayName: "Box",
+		OAuth:       true,
+		Storage:     GetBoxStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &BoxStorage{Remote: remote}
+		},
+	})
+}
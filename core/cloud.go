@@ -25,6 +25,10 @@ type CloudOperationOptions struct {
 	UpdateOnly  bool
 	Checksum    bool
 	CustomFlags string
+	// Patterns of files that are neither synced nor deleted, e.g. the lease
+	Excludes []string
+	// Makes rclone print how much it transferred, see parseRcloneStats
+	Stats bool
 }
 
 type CloudFile struct {
@@ -41,11 +45,23 @@ func getCloudApp() string {
 }
 
 func makeCommand(ctx context.Context, cmd_string string, arg ...string) *exec.Cmd {
+	return makeStorageCommand(ctx, nil, cmd_string, arg...)
+}
+
+// makeStorageCommand runs rclone with the config holding the remote of
+// storage, see getRcloneConfigPath.
+func makeStorageCommand(ctx context.Context, storage Storage, cmd_string string, arg ...string) *exec.Cmd {
 	if printCommands {
-		InfoLogger.Println("Running Command ", cmd_string, arg)
+		InfoLoggerFor(ctx).Println("Running Command ", cmd_string, arg)
+	}
+
+	configPath := getRcloneConfigPath(storage)
+	if configPath != "" {
+		arg = append([]string{"--config", configPath}, arg...)
 	}
 
 	cmd := exec.CommandContext(ctx, cmd_string, arg...)
+	cmd.Env = getRcloneEnvironment()
 	platform.StripWindow(cmd)
 	return cmd
 }
@@ -78,7 +94,13 @@ func (cm *CloudManager) CreateDriveIfNotExists(ctx context.Context, storage Stor
 }
 
 func (cm *CloudManager) DeleteStorageDrive(ctx context.Context, storage Storage) error {
-	cmd := makeCommand(ctx, getCloudApp(), "config", "delete", storage.GetName())
+	// External remotes belong to the user's own rclone config
+	if isExternalStorage(storage) {
+		return nil
+	}
+
+	DeleteStorageSecrets(storage)
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "config", "delete", storage.GetName())
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
@@ -91,29 +113,44 @@ func (cm *CloudManager) DeleteStorageDrive(ctx context.Context, storage Storage)
 }
 
 func (cm *CloudManager) ContainsStorageDrive(ctx context.Context, storage Storage) bool {
-	cmd := makeCommand(ctx, getCloudApp(), "config", "dump")
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "listremotes")
 	stdout, err := cmd.Output()
 
 	if err != nil {
 		InfoLogger.Println(err.Error())
 		return false
 	}
-	InfoLogger.Println(string(stdout))
 
-	var data map[string]interface{}
-	err = json.Unmarshal(stdout, &data)
-	if err != nil {
-		ErrorLogger.Println(err)
-		return false
+	for _, line := range strings.Split(string(stdout), "\n") {
+		if strings.TrimSuffix(strings.TrimSpace(line), ":") == storage.GetName() {
+			return true
+		}
 	}
 
-	_, ok := data[storage.GetName()]
-	return ok
+	return false
 }
 
 func (cm *CloudManager) MakeStorageDrive(ctx context.Context, storage Storage) error {
 	cmd := storage.GetCreationCommand(ctx)
-	InfoLogger.Println("Running creation command", cmd)
+	InfoLoggerFor(ctx).Println("Running creation command", cmd)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf(stderr.String())
+	}
+
+	return nil
+}
+
+func (cm *CloudManager) UpdateStorageDrive(ctx context.Context, storage Storage) error {
+	updatable, ok := storage.(UpdatableStorage)
+	if !ok {
+		return fmt.Errorf("cloud storage %v can not be updated in place", storage.GetName())
+	}
+
+	cmd := updatable.GetUpdateCommand(ctx)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
@@ -126,29 +163,34 @@ func (cm *CloudManager) MakeStorageDrive(ctx context.Context, storage Storage) e
 }
 
 func (cm *CloudManager) DoesRemoteDirExist(ctx context.Context, storage Storage, remotePath string) (bool, error) {
-	path := fmt.Sprintf("%v:%v", storage.GetName(), remotePath)
-	cmd := makeCommand(ctx, getCloudApp(), "lsjson", path+"/")
+	path := getRemoteLocation(storage, remotePath)
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "lsjson", path+"/")
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
 	if err != nil {
-		ErrorLogger.Println(stderr.String())
-		return false, nil
+		exiterr, ok := err.(*exec.ExitError)
+		if ok && exiterr.ExitCode() == rcloneDirNotFoundExitCode {
+			return false, nil
+		}
+
+		ErrorLoggerFor(ctx).Println(stderr.String())
+		return false, checkAuthError(storage, stderr.String())
 	}
 
 	return true, nil
 }
 
 func (cm *CloudManager) MakeRemoteDir(ctx context.Context, storage Storage, remotePath string) error {
-	path := fmt.Sprintf("%v:%v/", storage.GetName(), remotePath)
-	cmd := makeCommand(ctx, getCloudApp(), "mkdir", path)
+	path := getRemoteLocation(storage, remotePath+"/")
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "mkdir", path)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf(stderr.String())
+		return checkAuthError(storage, stderr.String())
 	}
 
 	return nil
@@ -183,8 +225,14 @@ func (cm *CloudManager) ListFiles(ctx context.Context, ops *CloudOperationOption
 }
 
 func (cm *CloudManager) DeleteCloudEntry(ctx context.Context, storage Storage) error {
+	// External remotes belong to the user's own rclone config
+	if isExternalStorage(storage) {
+		return nil
+	}
+
+	DeleteStorageSecrets(storage)
 	name := storage.GetName()
-	cmd := makeCommand(ctx, getCloudApp(), "config", "delete", name)
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "config", "delete", name)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
@@ -197,6 +245,7 @@ func (cm *CloudManager) DeleteCloudEntry(ctx context.Context, storage Storage) e
 }
 
 func (cm *CloudManager) ObscurePassword(ctx context.Context, password string) (string, error) {
+	registerLogSecret(password)
 	cmd := makeCommand(ctx, getCloudApp(), "obscure", password)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
@@ -208,11 +257,14 @@ func (cm *CloudManager) ObscurePassword(ctx context.Context, password string) (s
 	if err != nil {
 		return "", fmt.Errorf(stderr.String())
 	}
-	return output.String(), nil
+
+	obscured := strings.TrimSpace(output.String())
+	registerLogSecret(obscured)
+	return obscured, nil
 }
 
 func (cm *CloudManager) PerformSyncOperation(ctx context.Context, storage Storage, ops *CloudOperationOptions, localPath string, remotePath string) (string, error) {
-	InfoLogger.Println("Performing Sync Operation")
+	InfoLoggerFor(ctx).Println("Performing Sync Operation")
 	os.MkdirAll(localPath, os.ModePerm)
 	exists, err := cm.DoesRemoteDirExist(ctx, storage, remotePath)
 	if err != nil {
@@ -234,7 +286,7 @@ func (cm *CloudManager) PerformSyncOperation(ctx context.Context, storage Storag
 }
 
 func (cm *CloudManager) syncDir(ctx context.Context, storage Storage, ops *CloudOperationOptions, localPath string, remotePath string) (string, error) {
-	path := fmt.Sprintf("%v:%v", storage.GetName(), remotePath)
+	path := getRemoteLocation(storage, remotePath)
 	exists, err := cm.DoesRemoteDirExist(ctx, storage, remotePath)
 	if err != nil {
 		return "", err
@@ -280,7 +332,20 @@ func constructArgs(ops *CloudOperationOptions) []string {
 		args = append(args, "--dry-run")
 	}
 
-	if ops.Include != "" {
+	if ops.Stats {
+		args = append(args, "--stats-log-level=NOTICE")
+	}
+
+	if len(ops.Excludes) > 0 {
+		// rclone applies --include before --exclude, so both are given as
+		// filters, which are applied in order
+		for _, exclude := range ops.Excludes {
+			args = append(args, fmt.Sprintf("--filter=- %v", exclude))
+		}
+		if ops.Include != "" {
+			args = append(args, fmt.Sprintf("--filter=+ %v", ops.Include), "--filter=- **")
+		}
+	} else if ops.Include != "" {
 		args = append(args, fmt.Sprintf("--include=%v", ops.Include))
 	}
 
@@ -305,7 +370,7 @@ func (cm *CloudManager) syncAction(ctx context.Context, action string, storage S
 
 	args = append(args, action, localPath, remotePath)
 
-	cmd := makeCommand(ctx, getCloudApp(), args...)
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), args...)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
@@ -313,7 +378,7 @@ func (cm *CloudManager) syncAction(ctx context.Context, action string, storage S
 	cmd.Stdout = &stdout
 	err := cmd.Run()
 	if err != nil {
-		return "", fmt.Errorf(stderr.String())
+		return "", checkAuthError(storage, stderr.String())
 	}
 
 	result := stderr.String()
@@ -330,10 +395,10 @@ func (cm *CloudManager) syncAction(ctx context.Context, action string, storage S
 func (cm *CloudManager) bisyncDir(ctx context.Context, storage Storage, ops *CloudOperationOptions, localPath string, remotePath string) (string, error) {
 	args := constructArgs(ops)
 
-	path := fmt.Sprintf("%v:%v", storage.GetName(), remotePath)
+	path := getRemoteLocation(storage, remotePath)
 	args = append(args, "bisync", localPath, path)
 
-	cmd := makeCommand(ctx, getCloudApp(), args...)
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), args...)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
@@ -344,9 +409,9 @@ func (cm *CloudManager) bisyncDir(ctx context.Context, storage Storage, ops *Clo
 	if err != nil {
 		exiterr := err.(*exec.ExitError)
 		if exiterr.ExitCode() == 2 {
-			InfoLogger.Println("Need to run resync")
+			InfoLoggerFor(ctx).Println("Need to run resync")
 			args = append(args, "--resync")
-			cmd := makeCommand(ctx, getCloudApp(), args...)
+			cmd := makeStorageCommand(ctx, storage, getCloudApp(), args...)
 			var resyncstderr strings.Builder
 			cmd.Stderr = &resyncstderr
 
@@ -355,7 +420,7 @@ func (cm *CloudManager) bisyncDir(ctx context.Context, storage Storage, ops *Clo
 
 			err = cmd.Run()
 			if err != nil {
-				return "", fmt.Errorf(resyncstderr.String())
+				return "", checkAuthError(storage, resyncstderr.String())
 			}
 
 			result := resyncstderr.String()
@@ -365,7 +430,7 @@ func (cm *CloudManager) bisyncDir(ctx context.Context, storage Storage, ops *Clo
 			return result, nil
 		}
 
-		return "", fmt.Errorf(stderr.String())
+		return "", checkAuthError(storage, stderr.String())
 	}
 
 	result := stderr.String()
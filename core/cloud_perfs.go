@@ -5,20 +5,68 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
-const GOOGLE = 0
-const ONEDRIVE = 1
-const DROPBOX = 2
-const BOX = 3
-const NEXT = 4
-const FTP = 5
+const GOOGLE = "google"
+const ONEDRIVE = "onedrive"
+const DROPBOX = "dropbox"
+const BOX = "box"
+const NEXT = "nextcloud"
+const FTP = "ftp"
+const LOCAL = "local"
+const EXTERNAL = "external"
 
 type CloudPerfs struct {
-	Cloud                        int  `json:"cloud"`
-	PerformDryRun                bool `json:"performDryRun"`
-	UseBiSync                    bool `json:"useBiSync"`
-	ShouldNotPromptForLargeSyncs bool `json:"shouldNotPromptForLargeSyncs"`
+	Cloud                        string `json:"cloud"`
+	PerformDryRun                bool   `json:"performDryRun"`
+	UseBiSync                    bool   `json:"useBiSync"`
+	ShouldNotPromptForLargeSyncs bool   `json:"shouldNotPromptForLargeSyncs"`
+	SecondaryCloud               string `json:"secondaryCloud,omitempty"`
+	RemoteRoot                   string `json:"remoteRoot,omitempty"`
+	// Synced periodically by RunScheduler
+	Schedules []*SyncSchedule `json:"schedules,omitempty"`
+
+	// Set when the perfs were read in the legacy numeric format
+	migrated bool
+	// The folder of the local storage, which older versions kept here. It is
+	// moved to the provider settings, as it only applies to this device.
+	legacyLocalStoragePath string
+}
+
+func (cloudperfs *CloudPerfs) UnmarshalJSON(data []byte) error {
+	type cloudPerfsAlias CloudPerfs
+	aux := &struct {
+		Cloud            json.RawMessage `json:"cloud"`
+		SecondaryCloud   json.RawMessage `json:"secondaryCloud,omitempty"`
+		LocalStoragePath string          `json:"localStoragePath,omitempty"`
+		*cloudPerfsAlias
+	}{
+		cloudPerfsAlias: (*cloudPerfsAlias)(cloudperfs),
+	}
+
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+
+	cloudperfs.Cloud, err = parseCloudId(aux.Cloud)
+	if err != nil {
+		return err
+	}
+
+	cloudperfs.SecondaryCloud, err = parseCloudId(aux.SecondaryCloud)
+	if err != nil {
+		return err
+	}
+
+	cloudperfs.legacyLocalStoragePath = aux.LocalStoragePath
+	cloudperfs.migrated = isLegacyCloudId(aux.Cloud) || isLegacyCloudId(aux.SecondaryCloud) || aux.LocalStoragePath != ""
+	return nil
+}
+
+func isLegacyCloudId(raw json.RawMessage) bool {
+	return len(raw) > 0 && raw[0] != '"' && string(raw) != "null"
 }
 
 func getCloudPerfDir() (string, error) {
@@ -46,7 +94,12 @@ func readCloudPerfs() (*CloudPerfs, error) {
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(path)
+	var data []byte
+	err = withSettingsLock(func() error {
+		var err error
+		data, err = os.ReadFile(path)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -55,10 +108,28 @@ func readCloudPerfs() (*CloudPerfs, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if cloudperfs.legacyLocalStoragePath != "" {
+		err = migrateLocalStoragePath(cloudperfs.legacyLocalStoragePath)
+		if err != nil {
+			return nil, err
+		}
+		cloudperfs.legacyLocalStoragePath = ""
+	}
+
+	if cloudperfs.migrated {
+		InfoLogger.Println("Migrating cloud perfs to provider ids")
+		err = saveCloudPerfs(cloudperfs)
+		if err != nil {
+			return nil, err
+		}
+		cloudperfs.migrated = false
+	}
+
 	return cloudperfs, nil
 }
 
-func writeCloudPerfs(cloudperfs *CloudPerfs) error {
+func saveCloudPerfs(cloudperfs *CloudPerfs) error {
 	data, err := json.Marshal(cloudperfs)
 	if err != nil {
 		return err
@@ -78,15 +149,25 @@ func writeCloudPerfs(cloudperfs *CloudPerfs) error {
 		return err
 	}
 
-	err = os.WriteFile(path, data, os.ModePerm)
+	return withSettingsLock(func() error {
+		return os.WriteFile(path, data, os.ModePerm)
+	})
+}
+
+func writeCloudPerfs(cloudperfs *CloudPerfs) error {
+	err := saveCloudPerfs(cloudperfs)
+	if err != nil {
+		return err
+	}
+
+	path, err := getCloudPath()
 	if err != nil {
 		return err
 	}
 
 	cm := MakeCloudManager()
 	storage := GetCurrentStorageProvider()
-	ops := GetDefaultCloudOptions()
-	go cm.PerformSyncOperation(context.Background(), storage, ops, path, ToplevelCloudFolder+"user_settings/")
+	go syncSettingsFiles(context.Background(), cm, storage, filepath.Dir(path), filepath.Base(path), cloudperfs.GetRemoteRoot()+"user_settings/")
 
 	return nil
 }
@@ -118,29 +199,32 @@ func GetCurrentCloudStorage() (Storage, error) {
 		return nil, err
 	}
 
-	switch cloudperfs.Cloud {
-	case GOOGLE:
-		return GetGoogleDriveStorage(), nil
-	case ONEDRIVE:
-		return GetOneDriveStorage(), nil
-	case DROPBOX:
-		return GetDropBoxStorage(), nil
-	case BOX:
-		return GetBoxStorage(), nil
-	case NEXT:
-		return GetNextCloudStorage(), nil
-	case FTP:
-		return GetFtpDriveStorage(), nil
-	default:
-		return nil, fmt.Errorf("failed to identify cloud solution")
+	return GetCloudStorage(cloudperfs.Cloud)
+}
+
+func GetCloudStorage(cloud string) (Storage, error) {
+	provider, err := GetStorageProvider(cloud)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify cloud solution: %v", err)
 	}
+
+	return provider.Storage(), nil
 }
 
-func UpdateCloudProvider(cloud int) error {
-	cloudperfs, err := GetCurrentCloudPerfs()
+// UpdateCloudProvider sets the current cloud. On a new device the perfs are
+// created with their defaults.
+func UpdateCloudProvider(cloud string) error {
+	cloudperfs := GetCurrentCloudPerfsOrDefault()
+	_, err := GetStorageProvider(cloud)
 	if err != nil {
 		return err
 	}
+
+	if cloudperfs.SecondaryCloud != "" && validateSecondaryCloud(cloud, cloudperfs.SecondaryCloud) != nil {
+		InfoLogger.Println("Disabling secondary cloud " + cloudperfs.SecondaryCloud)
+		cloudperfs.SecondaryCloud = ""
+	}
+
 	cloudperfs.Cloud = cloud
 	return CommitCloudPerfs(cloudperfs)
 }
@@ -4,8 +4,10 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 //go:embed version.txt
@@ -18,17 +20,40 @@ type Options struct {
 	UserOverride     []string          `short:"o" long:"user-override" description:"--user-override <FILE> Provide location for custom user override JSON file for game definitions"`
 	PrintGameDefs    []bool            `short:"p" long:"print-gamedefs" description:"Print current gamedef map as JSON"`
 	SyncUserSettings []bool            `short:"s" long:"sync-user-settings" description:"Attempt to sync user settings from the current cloud provider. If no cloud provider is set, will be a NO-OP."`
-	SetCloud         []string          `short:"c" long:"set-cloud" description:"Sets the current cloud. 0 - GOOGLE, 1 - ONEDRIVE, 2 - DROPBOX, 3 - BOX, 4 - NEXTCLOUD, 5 - FTP"`
+	SetCloud         []string          `short:"c" long:"set-cloud" description:"Sets the current cloud by provider id (see --list-clouds), e.g. --set-cloud google. The legacy numeric ids are still accepted"`
+	CloudSettings    map[string]string `long:"cloud-setting" description:"<KEY>:<VALUE> Configures a setting of the cloud given by --set-cloud, or of the current cloud. Existing remotes are updated in place. See --list-clouds for the available settings"`
+	VaultPassphrase  []string          `long:"vault-passphrase-file" description:"--vault-passphrase-file <FILE> Unlocks the encrypted secret vault with the passphrase stored in FILE. Only needed when no OS keyring is available"`
+	CloudName        []string          `long:"cloud-name" description:"--cloud-name <NAME> Used with --set-cloud <PROVIDER> to add a named account, e.g. a second Google Drive. An existing account with this name is reused"`
+	RenameCloud      map[string]string `long:"rename-cloud" description:"<ID>:<NAME> Renames an account. See --list-clouds for the account ids"`
+	RemoveCloud      []string          `long:"remove-cloud" description:"--remove-cloud <ID> Removes an account along with its rclone remote and settings"`
+	GameCloud        map[string]string `long:"game-cloud" description:"<GAME>:<ID> Syncs a game to the given account instead of the current cloud. Use default as the id to sync it to the current cloud again"`
+	AuthorizeRemote  []bool            `long:"authorize-headless" description:"Signs in to the cloud given by --set-cloud, or to the current cloud, without a browser on this device. Prints an rclone authorize command to run on another computer and reads the resulting token from stdin"`
+	Reconnect        []bool            `long:"reconnect" description:"Signs in again to the cloud given by --set-cloud, or to the current cloud, keeping its settings. Use this when the sign in expired or was revoked. Syncs that failed because of it are run again"`
+	Doctor           []bool            `long:"doctor" description:"Checks rclone, the settings, the current cloud, the game manifest and Steam, and suggests how to fix anything that is wrong. Exits with an error when a check fails"`
+	Diagnostics      []string          `long:"export-diagnostics" description:"--export-diagnostics <ZIP> Writes the logs, settings, rclone config and save paths to a zip for attaching to bug reports. Passwords, tokens and your username are removed"`
+	ListClouds       []bool            `long:"list-clouds" description:"Lists the available cloud providers, their settings and the configured accounts"`
+	SetSecondary     []string          `long:"set-secondary-cloud" description:"Mirrors saves to a second cloud after each sync. Takes the same values as --set-cloud, or none to disable mirroring"`
+	HealSecondary    []bool            `long:"heal-secondary" description:"Compares the secondary cloud with the current cloud and copies anything that is missing"`
+	RestoreSecondary []bool            `long:"restore-from-secondary" description:"Copies all saves from the secondary cloud into the current cloud"`
+	RemoteRoot       []string          `long:"set-remote-root" description:"--set-remote-root <PATH> Sets the folder on the cloud that holds all saves, e.g. Backups/GameSaves. Existing saves are moved to the new folder"`
+	LocalStorage     []string          `long:"local-storage" description:"--local-storage <DIR> Use a local or mounted folder as the cloud. The folder must be writable and must not overlap any save path"`
 	DryRun           []bool            `short:"d" long:"dry-run" description:"Does not actually perform any network operations."`
 	Verbose          []bool            `short:"v" long:"verbose" description:"Enable verbose logging"`
 	LogLocation      []string          `short:"l" long:"log-location" description:"Specifies path to logfile. Defaults to User's Cache Dir / opencloudsave.log"`
+	Yes              []bool            `short:"y" long:"yes" description:"Answers yes to every prompt, e.g. the confirmation before a sync. Prompts are only shown in a terminal"`
+	Output           string            `long:"output" choice:"text" choice:"json" default:"text" description:"The format of results. json writes progress as one JSON event per line and the result as one JSON document, see docs/cli-json.md"`
 	Experimental     []bool            `short:"e" long:"experimental" description:"E"`
+
+	// How long to wait for other devices syncing a game, see AcquireLease
+	LeaseWait time.Duration `no-flag:"yes"`
 }
 
 type Message struct {
 	Finished bool
 	Message  string
 	Err      error
+	// Set for the steps of a game's sync
+	Event *ProgressEvent
 }
 
 type ChannelProvider struct {
@@ -99,43 +124,199 @@ func RequestMainOperation(ctx context.Context, cm *CloudManager, ops *Options, d
 		return
 	}
 
-	storage := GetCurrentStorageProvider()
-	if storage == nil {
+	if GetCurrentStorageProvider() == nil {
 		logs <- Message{
 			Finished: true,
-			Err:      fmt.Errorf("no cloud provider set"),
+			Err:      WithErrorKind(ErrorKindNoCloud, ErrNoCloud),
 		}
 
 		return
 	}
 
+	secondary := GetSecondaryStorageProvider()
+
 	LogMessage(logs, "Starting Upload Process...")
 
 	gamedefs := dm.GetGameDefMap()
+	// The remotes of the accounts games sync to, created once per run
+	created := make(map[string]bool)
+	// The lock and the lease of the game being synced, released before the
+	// next game
+	var gameLock *FileLock
+	var gameLease *HeldLease
+	release := func() {
+		err := gameLease.Release()
+		if err != nil {
+			WarnLogger.Println(err)
+		}
+		gameLease = nil
+		gameLock.Unlock()
+		gameLock = nil
+	}
+	defer release()
 	for _, gamename := range ops.Gamenames {
+		release()
 		gamename = strings.TrimSpace(gamename)
 		gamedef := gamedefs[gamename]
-		LogMessage(logs, "Performing Check on %v", gamename)
 
-		syncpaths, err := dm.GetSyncpathForGame(gamename)
-		LogMessage(logs, "Identified Paths for %v: %v", gamename, syncpaths)
-		if err != nil {
-			fmt.Println(err)
+		// Every log line of this game's sync carries the same id
+		ctx := WithSyncId(ctx, NewSyncId())
+		InfoLoggerFor(ctx).Println("Starting sync of " + gamename)
+		newEvent := func(phase string) *ProgressEvent {
+			event := NewProgressEvent(gamename, phase)
+			event.SyncId = GetSyncId(ctx)
+			return event
+		}
+		failGame := func(kind string, path string, err error) {
+			err = WithErrorKind(kind, err)
+			event := newEvent(PhaseError)
+			event.Path = path
+			event.Error = err.Error()
+			event.ErrorKind = GetErrorKind(err)
 			logs <- Message{
-				Err: err,
+				Err:      err,
+				Finished: true,
+				Event:    event,
 			}
+		}
+
+		logs <- Message{
+			Message: fmt.Sprintf("Performing Check on %v", gamename),
+			Event:   newEvent(PhaseStart),
+		}
+
+		if gamedef == nil {
+			failGame(ErrorKindUnknownGame, "", fmt.Errorf("failed to find game (%v)", gamename))
 			continue
 		}
 
-		for _, syncpath := range syncpaths {
+		// Other processes, like the daemon or the GUI, may be syncing it
+		held, err := lockGameForSync(ctx, gamename)
+		var lockedErr *GameLockedError
+		if errors.As(err, &lockedErr) {
+			failGame(ErrorKindLocked, "", err)
+			continue
+		} else if err != nil {
+			failGame(ErrorKindUnknown, "", err)
+			continue
+		}
+		gameLock = held
+
+		storage, err := GetGameStorage(gamedef)
+		if err != nil {
+			failGame(ErrorKindStorage, "", err)
+			continue
+		}
+
+		remotePath, err := GetGameRemotePath(gamename, gamedef)
+		if err != nil {
+			failGame(ErrorKindPaths, "", err)
+			continue
+		}
+
+		syncpaths, err := dm.GetSyncpathForGame(gamename)
+		if err != nil {
+			failGame(ErrorKindPaths, "", err)
+			continue
+		}
+		logs <- Message{
+			Message: fmt.Sprintf("Identified Paths for %v: %v", gamename, syncpaths),
+			Event:   newEvent(PhasePaths),
+		}
+
+		dryRun := len(ops.DryRun) > 0 && ops.DryRun[0]
+
+		// Dry runs leave the rclone config alone too
+		if !dryRun && !created[storage.GetName()] {
+			err = cm.CreateDriveIfNotExists(ctx, storage)
+			if err != nil {
+				failGame(ErrorKindStorage, "", err)
+				continue
+			}
+			created[storage.GetName()] = true
+		}
+
+		// Dry runs change nothing hooks would act on
+		var hooks []*SyncHooks
+		var hookEvent *HookEvent
+		if !dryRun {
+			hooks = getSyncHooks(gamename)
+		}
+		if len(hooks) > 0 {
+			hookEvent = &HookEvent{
+				Game:       gamename,
+				SyncId:     GetSyncId(ctx),
+				RemotePath: getRemoteLocation(storage, remotePath),
+				Direction:  SyncDirectionUnknown,
+			}
+			for _, syncpath := range syncpaths {
+				hookEvent.LocalPaths = append(hookEvent.LocalPaths, syncpath.Path)
+			}
+		}
+		// Hooks failing after the sync started do not fail it
+		warnHook := func(err error) {
+			WarnLoggerFor(ctx).Println(err)
+			LogMessage(logs, "%v", err)
+		}
+		failHooks := func(result string, err error) {
+			if hookEvent == nil {
+				return
+			}
+			hookEvent.Result = result
+			hookEvent.Error = err.Error()
+			herr := runHooks(ctx, hooks, HookOnFailure, hookEvent, logs)
+			if herr != nil {
+				warnHook(herr)
+			}
+		}
+
+		// Other devices may be writing to the same cloud folder
+		if !dryRun {
+			lease, err := cm.AcquireLease(ctx, storage, gamename, remotePath, ops.LeaseWait)
+			if isLeaseHeldError(err) {
+				failGame(ErrorKindLeased, "", err)
+				failHooks(HookResultFailed, err)
+				continue
+			} else if err != nil {
+				failGame(ErrorKindSync, "", err)
+				failHooks(HookResultFailed, err)
+				continue
+			}
+			gameLease = lease
+			// The sync stops when another device takes the lease over
+			ctx = lease.Context()
+		}
+
+		if hookEvent != nil {
+			status := GetSyncStatus(ctx, cm, dm, gamename)
+			hookEvent.Direction = getSyncDirection(status)
+			if status.Status == SyncStatusBothChanged {
+				err = runHooks(ctx, hooks, HookOnConflict, hookEvent, logs)
+				if err != nil {
+					warnHook(err)
+				}
+			}
+
+			err = runHooks(ctx, hooks, HookPreSync, hookEvent, logs)
+			if err != nil {
+				failGame(ErrorKindHook, "", err)
+				failHooks(HookResultAborted, err)
+				continue
+			}
+		}
+
+		var syncErr error
+		for i, syncpath := range syncpaths {
 			LogMessage(logs, "Examining Path %v", syncpath.Path)
-			remotePath := fmt.Sprintf("%v%v/", ToplevelCloudFolder, gamename)
-			LogMessage(logs, "Performing Sync: "+remotePath)
+			event := newEvent(PhaseSync)
+			event.Path = syncpath.Path
+			logs <- Message{
+				Message: "Performing Sync: " + remotePath,
+				Event:   event,
+			}
 
 			syncops := GetDefaultCloudOptions()
-			if len(ops.DryRun) > 0 && ops.DryRun[0] {
-				syncops.DryRun = true
-			}
+			syncops.DryRun = dryRun
 
 			if len(ops.Verbose) > 0 && ops.Verbose[0] {
 				syncops.Verbose = true
@@ -143,21 +324,74 @@ func RequestMainOperation(ctx context.Context, cm *CloudManager, ops *Options, d
 
 			syncops.CustomFlags = gamedef.CustomFlags
 			syncops.Include = syncpath.Include
+			syncops.Excludes = []string{"/" + LeaseFilename}
+			// Hooks are told how much changed
+			syncops.Stats = ops.Output == OutputJSON || hookEvent != nil
 
 			result, err := cm.PerformSyncOperation(ctx, storage, syncops, syncpath.Path, remotePath)
+			if lost := gameLease.Lost(); err != nil && lost != nil {
+				failGame(ErrorKindLeased, syncpath.Path, lost)
+				syncErr = lost
+				break
+			}
 			if err != nil {
-				ErrorLogger.Println(err)
-				logs <- Message{
-					Err:      err,
-					Finished: true,
+				ErrorLoggerFor(ctx).Println(err)
+				if isNeedsReauthError(err) && !syncops.DryRun {
+					qerr := QueueSyncForReauth(storage, gamename)
+					if qerr != nil {
+						ErrorLoggerFor(ctx).Println(qerr)
+					}
 				}
+				failGame(ErrorKindSync, syncpath.Path, err)
+				syncErr = err
 				continue
 			}
 
+			// Every save folder syncs into the same cloud folder, which is
+			// mirrored once all of them were synced
+			last := i == len(syncpaths)-1
+			if last && syncErr == nil && secondary != nil && secondary.GetName() != storage.GetName() && !syncops.DryRun {
+				event := newEvent(PhaseMirror)
+				logs <- Message{
+					Message: fmt.Sprintf("Mirroring %v to secondary cloud", remotePath),
+					Event:   event,
+				}
+				_, err = cm.MirrorToSecondary(ctx, storage, secondary, remotePath)
+				if err != nil {
+					// A failing secondary must never fail the primary sync
+					WarnLoggerFor(ctx).Println(err)
+					event := newEvent(PhaseMirror)
+					event.Error = err.Error()
+					event.ErrorKind = ErrorKindMirror
+					logs <- Message{
+						Message: fmt.Sprintf("Secondary cloud mirror failed: %v", err),
+						Event:   event,
+					}
+				}
+			}
+
 			LogMessage(logs, "All Operations Complete")
+			event = newEvent(PhaseDone)
+			event.Path = syncpath.Path
+			event.Bytes, event.Files = parseRcloneStats(result)
+			if hookEvent != nil {
+				hookEvent.Bytes += event.Bytes
+				hookEvent.Files += event.Files
+			}
 			logs <- Message{
 				Message:  result,
 				Finished: true,
+				Event:    event,
+			}
+		}
+
+		if syncErr != nil {
+			failHooks(HookResultFailed, syncErr)
+		} else if hookEvent != nil {
+			hookEvent.Result = HookResultDone
+			err = runHooks(ctx, hooks, HookPostSync, hookEvent, logs)
+			if err != nil {
+				warnHook(err)
 			}
 		}
 	}
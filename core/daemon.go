@@ -0,0 +1,462 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"opencloudsave/platform"
+)
+
+const DefaultDaemonQuietPeriod = 10 * time.Second
+const DefaultDaemonRescanInterval = time.Minute
+
+type DaemonOptions struct {
+	// How long a game's saves must be left unchanged before they are synced,
+	// so saves are not uploaded while they are written
+	QuietPeriod time.Duration
+	// How often the games are resolved again, to watch save folders that
+	// were created since. Games that can not be watched are checked for
+	// changes as often.
+	RescanInterval time.Duration
+	// Passed on to RequestMainOperation for every sync
+	SyncOptions *Options
+}
+
+// daemonSync is a sync the daemon finished.
+type daemonSync struct {
+	game string
+	// Another process held the lock of the game
+	locked bool
+}
+
+type daemon struct {
+	cm       *CloudManager
+	dm       GameDefManager
+	ops      *DaemonOptions
+	channels *ChannelProvider
+	watcher  *platform.Watcher
+
+	// Save folders by the games syncing them
+	roots map[string][]string
+	// Save folders the watcher watches
+	watched map[string]bool
+	// Fingerprints of the save folders of games that can not be watched
+	polled map[string]string
+	// When each game with unsynced changes last changed
+	pending map[string]time.Time
+	// The game being synced. Its saves change by the sync itself, so changes
+	// made meanwhile are told apart by their modification time once it is
+	// done, see onSynced.
+	syncing string
+	// When the sync of the game being synced started
+	syncStarted time.Time
+	// The saves of the game being synced changed during the sync
+	syncChanged bool
+	finished    chan *daemonSync
+	// The watch limit is retried on every rescan but only reported once
+	reportedWatchLimit bool
+}
+
+// RunDaemon syncs a game whenever its saves changed and were then left alone
+// for the quiet period. The save folders are watched where the platform
+// supports it and checked for changes otherwise. Messages of the syncs are
+// sent to channels. RunDaemon returns once ctx is done, after syncing the
+// changes that were still pending.
+func RunDaemon(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *DaemonOptions, channels *ChannelProvider) error {
+	if GetCurrentStorageProvider() == nil {
+		return WithErrorKind(ErrorKindNoCloud, ErrNoCloud)
+	}
+
+	err := CheckSecretsUnlocked()
+	if err != nil {
+		return err
+	}
+
+	d := &daemon{
+		cm:       cm,
+		dm:       dm,
+		ops:      ops,
+		channels: channels,
+		roots:    make(map[string][]string),
+		watched:  make(map[string]bool),
+		polled:   make(map[string]string),
+		pending:  make(map[string]time.Time),
+		finished: make(chan *daemonSync),
+	}
+
+	watcher, err := platform.NewWatcher()
+	if err != nil {
+		WarnLogger.Println(err)
+		LogMessage(channels.Logs, "Save folders can not be watched (%v), checking them for changes every %v instead", err, ops.RescanInterval)
+	} else {
+		d.watcher = watcher
+		defer watcher.Close()
+	}
+
+	d.rescan()
+	LogMessage(channels.Logs, "Watching %v save folders of %v tracked games", len(d.watched), len(d.games()))
+
+	var events chan platform.WatchEvent
+	var errs chan error
+	if d.watcher != nil {
+		events = d.watcher.Events
+		errs = d.watcher.Errors
+	}
+
+	tick := time.NewTicker(d.tickInterval())
+	defer tick.Stop()
+	rescan := time.NewTicker(ops.RescanInterval)
+	defer rescan.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.stop()
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			d.onEvent(event)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			d.onWatchError(err)
+		case synced := <-d.finished:
+			d.onSynced(synced)
+		case <-tick.C:
+			d.startDueSync()
+		case <-rescan.C:
+			// The game definitions are not changed while a sync reads them
+			if d.syncing == "" {
+				d.rescan()
+			}
+		}
+	}
+}
+
+func (d *daemon) tickInterval() time.Duration {
+	interval := d.ops.QuietPeriod / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+// games returns the tracked games that have save folders.
+func (d *daemon) games() []string {
+	seen := make(map[string]bool)
+	for _, games := range d.roots {
+		for _, game := range games {
+			seen[game] = true
+		}
+	}
+
+	result := []string{}
+	for game := range seen {
+		result = append(result, game)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// rescan resolves the save folders of every tracked game again and watches
+// the ones that are not watched yet.
+func (d *daemon) rescan() {
+	// Picks up games that were added or changed by other instances
+	err := d.dm.ApplyUserOverrides()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		ErrorLogger.Println(err)
+	}
+
+	d.roots = make(map[string][]string)
+	for gamename, gamedef := range d.dm.GetGameDefMap() {
+		if gamedef.Hidden {
+			continue
+		}
+
+		syncpaths, err := d.dm.GetSyncpathForGame(gamename)
+		if err != nil {
+			InfoLogger.Println(err)
+			continue
+		}
+
+		for _, syncpath := range syncpaths {
+			root := filepath.Clean(syncpath.Path)
+			d.roots[root] = append(d.roots[root], gamename)
+		}
+	}
+
+	// Removed folders lose their watch
+	for root := range d.watched {
+		if _, err := os.Stat(root); err != nil {
+			delete(d.watched, root)
+		}
+	}
+
+	unwatched := make(map[string]bool)
+	for root, games := range d.roots {
+		if d.watched[root] {
+			continue
+		}
+		if _, err := os.Stat(root); err != nil {
+			// Not created until the game is installed or first saves
+			continue
+		}
+
+		if d.watcher != nil {
+			err := d.watcher.Add(root)
+			if err == nil {
+				InfoLogger.Println("Watching " + root)
+				d.watched[root] = true
+				continue
+			}
+			d.onWatchError(err)
+		}
+
+		for _, game := range games {
+			unwatched[game] = true
+		}
+	}
+
+	d.poll(unwatched)
+}
+
+// poll compares the save folders of games that can not be watched with
+// their last fingerprint.
+func (d *daemon) poll(games map[string]bool) {
+	for game := range d.polled {
+		games[game] = true
+	}
+
+	for game := range games {
+		fingerprint := d.fingerprint(game)
+		previous, ok := d.polled[game]
+		d.polled[game] = fingerprint
+		if ok && previous != fingerprint {
+			d.markChanged(game)
+		}
+	}
+}
+
+// fingerprint summarizes the files in the save folders of game, so changes
+// can be noticed without watching them.
+func (d *daemon) fingerprint(game string) string {
+	var fingerprint strings.Builder
+	for root, games := range d.roots {
+		if !containsString(games, game) {
+			continue
+		}
+
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+
+			fmt.Fprintf(&fingerprint, "%v:%v:%v\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+
+	return fingerprint.String()
+}
+
+// changedSince reports whether a file in the save folders of game was
+// modified after since.
+func (d *daemon) changedSince(game string, since time.Time) bool {
+	changed := false
+	for root, games := range d.roots {
+		if !containsString(games, game) {
+			continue
+		}
+
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if changed || err != nil || entry.IsDir() {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err == nil && info.ModTime().After(since) {
+				changed = true
+			}
+			return nil
+		})
+	}
+
+	return changed
+}
+
+func (d *daemon) onWatchError(err error) {
+	if errors.Is(err, platform.ErrWatchLimit) {
+		WarnLogger.Println(err)
+		if d.reportedWatchLimit {
+			return
+		}
+		d.reportedWatchLimit = true
+		LogMessage(d.channels.Logs, "%v. Folders that could not be watched are checked for changes every %v instead", err, d.ops.RescanInterval)
+		return
+	}
+
+	ErrorLogger.Println(err)
+}
+
+func (d *daemon) onEvent(event platform.WatchEvent) {
+	if event.Overflow {
+		// Changes were lost, every watched game may have changed
+		for root := range d.watched {
+			for _, game := range d.roots[root] {
+				d.markChanged(game)
+			}
+		}
+		return
+	}
+
+	for root, games := range d.roots {
+		if event.Path != root && !strings.HasPrefix(event.Path, root+string(os.PathSeparator)) {
+			continue
+		}
+
+		for _, game := range games {
+			d.markChanged(game)
+		}
+	}
+}
+
+func (d *daemon) markChanged(game string) {
+	if d.syncing == game {
+		d.syncChanged = true
+		return
+	}
+
+	if _, ok := d.pending[game]; !ok {
+		InfoLogger.Println("Saves of " + game + " changed")
+	}
+	d.pending[game] = time.Now()
+}
+
+// startDueSync syncs the game whose saves were left alone the longest, once
+// the quiet period passed. Only one game is synced at a time.
+func (d *daemon) startDueSync() {
+	if d.syncing != "" {
+		return
+	}
+
+	due := ""
+	var dueSince time.Time
+	for game, changed := range d.pending {
+		if time.Since(changed) < d.ops.QuietPeriod {
+			continue
+		}
+		if due == "" || changed.Before(dueSince) {
+			due = game
+			dueSince = changed
+		}
+	}
+	if due == "" {
+		return
+	}
+
+	delete(d.pending, due)
+	d.syncing = due
+	d.syncStarted = time.Now()
+	d.syncChanged = false
+	go func() {
+		d.finished <- d.sync(due)
+	}()
+}
+
+func (d *daemon) sync(game string) *daemonSync {
+	LogMessage(d.channels.Logs, "Saves of %v changed, syncing", game)
+	ops := *d.ops.SyncOptions
+	ops.Gamenames = []string{game}
+	ops.LeaseWait = DefaultLeaseWait
+
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		// A sync that started is finished even when the daemon is stopped
+		RequestMainOperation(context.Background(), d.cm, &ops, d.dm, channels)
+		close(channels.Logs)
+	}()
+
+	result := &daemonSync{game: game}
+	for msg := range channels.Logs {
+		d.channels.Logs <- msg
+		if msg.Event != nil && msg.Event.ErrorKind == ErrorKindLocked {
+			result.locked = true
+		}
+	}
+
+	return result
+}
+
+func (d *daemon) onSynced(synced *daemonSync) {
+	d.syncing = ""
+
+	// Files the sync downloaded keep the modification time they have in the
+	// cloud, newer files were saved while syncing
+	_, polled := d.polled[synced.game]
+	if (d.syncChanged || polled) && d.changedSince(synced.game, d.syncStarted) {
+		LogMessage(d.channels.Logs, "Saves of %v changed while syncing", synced.game)
+		d.markChanged(synced.game)
+	}
+
+	// Files the sync downloaded are not changes of their own
+	if polled {
+		d.polled[synced.game] = d.fingerprint(synced.game)
+	}
+
+	// Another process is syncing the game, its changes are synced once it
+	// is done
+	if synced.locked {
+		LogMessage(d.channels.Logs, "Syncing %v again after the quiet period", synced.game)
+		d.markChanged(synced.game)
+	}
+}
+
+// stop waits for the running sync and syncs the changes still pending, so
+// they are not lost when the daemon is stopped on logout.
+func (d *daemon) stop() {
+	if d.syncing != "" {
+		d.onSynced(<-d.finished)
+	}
+
+	games := []string{}
+	for game := range d.pending {
+		games = append(games, game)
+	}
+	sort.Strings(games)
+
+	if len(games) > 0 {
+		LogMessage(d.channels.Logs, "Syncing the changes to %v before stopping", strings.Join(games, ", "))
+	}
+	for _, game := range games {
+		d.sync(game)
+	}
+
+	LogMessage(d.channels.Logs, "Daemon stopped")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDaemon(t *testing.T) {
+	installed := t.TempDir()
+	later := filepath.Join(t.TempDir(), "later")
+	dm := setupRunGame(t, fakeSyncRclone, testGameDef("Installed", installed), testGameDef("Later", later))
+
+	ops := &DaemonOptions{
+		QuietPeriod:    200 * time.Millisecond,
+		RescanInterval: 200 * time.Millisecond,
+		SyncOptions:    &Options{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channels := MakeDefaultChannelProvider()
+	stopped := make(chan error)
+	go func() {
+		stopped <- RunDaemon(ctx, MakeCloudManager(), dm, ops, channels)
+		close(channels.Logs)
+	}()
+
+	var mtx sync.Mutex
+	syncs := make(map[string]int)
+	watching := make(chan struct{})
+	var once sync.Once
+	go func() {
+		for msg := range channels.Logs {
+			once.Do(func() { close(watching) })
+
+			if msg.Event != nil && msg.Event.Phase == PhaseStart {
+				mtx.Lock()
+				syncs[msg.Event.Game]++
+				mtx.Unlock()
+			}
+		}
+	}()
+	<-watching
+
+	countSyncs := func(game string) int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return syncs[game]
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(installed, "slot1.sav"), []byte("save"), 0644))
+	assert.Eventually(t, func() bool { return countSyncs("Installed") == 1 }, 5*time.Second, 50*time.Millisecond)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(installed, "profiles"), 0755))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(filepath.Join(installed, "profiles", "slot2.sav"), []byte("save"), 0644))
+	assert.Eventually(t, func() bool { return countSyncs("Installed") == 2 }, 5*time.Second, 50*time.Millisecond, "New folders should be watched")
+
+	assert.NoError(t, os.MkdirAll(later, 0755))
+	time.Sleep(500 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(filepath.Join(later, "slot1.sav"), []byte("save"), 0644))
+	assert.Eventually(t, func() bool { return countSyncs("Later") == 1 }, 5*time.Second, 50*time.Millisecond, "Save folders created later should be watched")
+
+	// Changes still pending are synced when stopping
+	assert.NoError(t, os.WriteFile(filepath.Join(installed, "slot3.sav"), []byte("save"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The daemon did not stop")
+	}
+	assert.Eventually(t, func() bool { return countSyncs("Installed") == 3 }, time.Second, 50*time.Millisecond)
+	assert.Equal(t, 1, countSyncs("Later"))
+}
+
+func TestDaemonKeepsChangesMadeWhileSyncing(t *testing.T) {
+	// Downloads keep the modification time they have in the cloud
+	dm := setupRunGame(t, `#!/bin/sh
+case "$*" in
+*lsjson*)
+	echo "[]"
+	;;
+*"$SAVES"*)
+	echo "cloud" > "$SAVES/cloud.sav"
+	touch -d 2020-01-01 "$SAVES/cloud.sav"
+	sleep 0.5
+	;;
+esac
+`)
+	saves := dm.GetGameDefMap()["Hollow"].LinuxPath[0].Path
+	t.Setenv("SAVES", saves)
+
+	ops := &DaemonOptions{
+		QuietPeriod:    200 * time.Millisecond,
+		RescanInterval: time.Minute,
+		SyncOptions:    &Options{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RunDaemon(ctx, MakeCloudManager(), dm, ops, channels)
+		close(channels.Logs)
+	}()
+
+	var mtx sync.Mutex
+	syncs := 0
+	watching := make(chan struct{})
+	syncing := make(chan struct{}, 10)
+	var once sync.Once
+	go func() {
+		for msg := range channels.Logs {
+			once.Do(func() { close(watching) })
+
+			if msg.Event != nil && msg.Event.Phase == PhaseStart {
+				mtx.Lock()
+				syncs++
+				mtx.Unlock()
+			}
+			if msg.Event != nil && msg.Event.Phase == PhaseSync {
+				syncing <- struct{}{}
+			}
+		}
+	}()
+	<-watching
+
+	countSyncs := func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return syncs
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(saves, "slot1.sav"), []byte("save"), 0644))
+	<-syncing
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(filepath.Join(saves, "slot1.sav"), []byte("saved while syncing"), 0644))
+	assert.Eventually(t, func() bool { return countSyncs() == 2 }, 5*time.Second, 50*time.Millisecond, "Changes made while syncing should be synced")
+
+	// Files the sync downloaded do not start another sync
+	time.Sleep(time.Second)
+	assert.Equal(t, 2, countSyncs())
+}
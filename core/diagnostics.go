@@ -0,0 +1,244 @@
+package core
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDiagnosticsFilename returns the suggested name of a diagnostics
+// bundle created now.
+func DefaultDiagnosticsFilename() string {
+	return fmt.Sprintf("opencloudsave-diagnostics-%v.zip", time.Now().Format("2006-01-02-150405"))
+}
+
+type diagnosticsBundle struct {
+	zw       *zip.Writer
+	redactor *redactor
+}
+
+func (b *diagnosticsBundle) writeText(name string, text string) error {
+	w, err := b.zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, b.redactor.Redact(text))
+	return err
+}
+
+func (b *diagnosticsBundle) writeJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return b.writeText(name, err.Error())
+	}
+
+	return b.writeText(name, string(data))
+}
+
+// writeLog copies a logfile line by line, so large logs are not read into
+// memory at once.
+func (b *diagnosticsBundle) writeLog(name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return b.writeText(name, err.Error())
+	}
+	defer file.Close()
+
+	w, err := b.zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			_, werr := io.WriteString(w, b.redactor.Redact(line))
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getLogFiles returns the current logfile followed by the backups
+// lumberjack rotated out of it.
+func getLogFiles() []string {
+	path := GetLogPath()
+	if path == "" {
+		return []string{}
+	}
+
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext)
+	backups, err := filepath.Glob(prefix + "-*" + ext)
+	if err != nil {
+		backups = []string{}
+	}
+	sort.Strings(backups)
+
+	return append([]string{path}, backups...)
+}
+
+// getRcloneConfigDump returns the remotes of the rclone config in use, with
+// the values of secret keys replaced.
+func getRcloneConfigDump(ctx context.Context, r *redactor) (interface{}, error) {
+	cmd := makeCommand(ctx, getCloudApp(), "config", "dump")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf(stderr.String())
+	}
+
+	remotes := make(map[string]map[string]string)
+	err = json.Unmarshal(stdout, &remotes)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, settings := range remotes {
+		remotes[name] = r.redactSettings(settings)
+	}
+
+	return remotes, nil
+}
+
+func getRcloneVersion(ctx context.Context) string {
+	cmd := makeCommand(ctx, getCloudApp(), "version")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("Could not run %v: %v\n%v", getCloudApp(), err, string(output))
+	}
+
+	return string(output)
+}
+
+func getResolvedSyncPaths(dm GameDefManager) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, gamedef := range dm.GetGameDefMap() {
+		if gamedef.Hidden {
+			continue
+		}
+
+		syncpaths, err := gamedef.GetSyncpaths()
+		if err != nil {
+			result[key] = err.Error()
+			continue
+		}
+
+		remotePath, err := GetGameRemotePath(key, gamedef)
+		if err != nil {
+			remotePath = err.Error()
+		}
+
+		result[key] = map[string]interface{}{
+			"paths":      syncpaths,
+			"remotePath": remotePath,
+			"storage":    gamedef.Storage,
+		}
+	}
+
+	return result
+}
+
+// ExportDiagnostics writes a zip for bug reports to zipPath. It holds the
+// app and rclone versions, the settings, the rclone config, the resolved save
+// paths and the logs. Secrets, home directories and the username are removed
+// from every file.
+func ExportDiagnostics(ctx context.Context, dm GameDefManager, zipPath string) error {
+	file, err := os.OpenFile(zipPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bundle := &diagnosticsBundle{
+		zw:       zip.NewWriter(file),
+		redactor: newRedactor(),
+	}
+
+	// The config dump goes first, as it teaches the redactor the tokens
+	// rclone stored, which may also show up in the other files
+	remotes, err := getRcloneConfigDump(ctx, bundle.redactor)
+	if err != nil {
+		remotes = err.Error()
+	}
+
+	var cloudperfs interface{}
+	cloudperfs, err = readCloudPerfs()
+	if err != nil {
+		cloudperfs = err.Error()
+	}
+
+	settings := make(map[string]map[string]string)
+	all, err := readAllProviderSettings()
+	if err != nil {
+		InfoLogger.Println(err)
+	}
+	for id, values := range all {
+		settings[id] = bundle.redactor.redactSettings(values)
+	}
+
+	version := fmt.Sprintf("%v %v\nos: %v/%v\n", APP_NAME, strings.TrimSpace(VersionRevision), runtime.GOOS, runtime.GOARCH)
+	err = bundle.writeText("version.txt", version)
+	if err != nil {
+		return err
+	}
+
+	err = bundle.writeText("rclone_version.txt", getRcloneVersion(ctx))
+	if err != nil {
+		return err
+	}
+
+	err = bundle.writeJSON("rclone_config.json", remotes)
+	if err != nil {
+		return err
+	}
+
+	err = bundle.writeJSON("cloud_perfs.json", cloudperfs)
+	if err != nil {
+		return err
+	}
+
+	err = bundle.writeJSON("provider_settings.json", settings)
+	if err != nil {
+		return err
+	}
+
+	err = bundle.writeJSON("sync_paths.json", getResolvedSyncPaths(dm))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range getLogFiles() {
+		err = bundle.writeLog("logs/"+filepath.Base(path), path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = bundle.zw.Close()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeRclone = `#!/bin/sh
+case "$*" in
+*"config dump"*)
+	cat <<'END'
+{"opencloudsave-google":{"type":"drive","scope":"drive.file","token":"{\"access_token\":\"ya29.accesstoken\",\"refresh_token\":\"1//refreshtoken\",\"expiry\":\"2023-01-01\"}"},"opencloudsave-nextcloud":{"type":"webdav","pass":"obscuredDumpPass"}}
+END
+	;;
+*version*)
+	echo "rclone v1.62.2"
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+func useFakeRclone(t *testing.T, script string) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rclone"), []byte(script), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func readZip(t *testing.T, path string) map[string]string {
+	reader, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	result := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		assert.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		result[file.Name] = string(data)
+	}
+
+	return result
+}
+
+func TestExportDiagnosticsRemovesSecrets(t *testing.T) {
+	useFakeRclone(t, fakeRclone)
+	home := filepath.Join(t.TempDir(), "alicesmith")
+	assert.NoError(t, os.MkdirAll(home, os.ModePerm))
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	store := useMemorySecretStore(t)
+
+	logDir := filepath.Join(home, "logs")
+	assert.NoError(t, os.MkdirAll(logDir, os.ModePerm))
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(logDir, "opencloudsave.log")))
+	t.Cleanup(func() {
+		logFilePath = ""
+	})
+
+	assert.NoError(t, store.Set(getStorageSecretKey(GetNextCloudStorage(), "bearer_token"), "storedBearerToken"))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: LOCAL}))
+
+	settingsPath, err := getProviderSettingsPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(settingsPath, []byte(`{"local":{"path":"`+filepath.Join(home, "nas")+`"},"nextcloud":{"url":"https://cloud.example.com/remote.php/dav/files/alicesmith","password":"legacyPlainPass"}}`), 0600))
+
+	InfoLogger.Println("Running Command rclone [sync " + filepath.Join(home, "Saves") + " opencloudsave-google:opencloudsaves/]")
+	InfoLogger.Println("RCLONE_CONFIG_OPENCLOUDSAVE_NEXTCLOUD_PASS=plainEnvPass")
+	InfoLogger.Println("Failed to list: access token ya29.accesstoken was rejected")
+	InfoLogger.Println("Authorization: Bearer storedBearerToken")
+	// Named like lumberjack's backups, which are removed after 30 days
+	backup := filepath.Join(logDir, "opencloudsave-"+time.Now().Add(-time.Hour).Format("2006-01-02T15-04-05.000")+".log")
+	assert.NoError(t, os.WriteFile(backup, []byte("refreshing with 1//refreshtoken for alicesmith\n"), 0644))
+
+	dm := MakeGameDefManager(filepath.Join(t.TempDir(), "overrides.json"))
+	zipPath := filepath.Join(t.TempDir(), DefaultDiagnosticsFilename())
+	assert.NoError(t, ExportDiagnostics(context.Background(), dm, zipPath))
+
+	files := readZip(t, zipPath)
+	for _, name := range []string{"version.txt", "rclone_version.txt", "rclone_config.json", "cloud_perfs.json", "provider_settings.json", "sync_paths.json", "logs/opencloudsave.log", "logs/" + filepath.Base(backup)} {
+		assert.Contains(t, files, name)
+	}
+	assert.Contains(t, files["rclone_version.txt"], "rclone v1.62.2")
+	assert.Contains(t, files["rclone_config.json"], "drive.file", "Settings that are not secret should be kept")
+	assert.Contains(t, files["provider_settings.json"], "~/nas")
+	assert.Contains(t, files["logs/opencloudsave.log"], "Running Command")
+
+	secrets := []string{
+		"storedBearerToken",
+		"ya29.accesstoken",
+		"1//refreshtoken",
+		"obscuredDumpPass",
+		"legacyPlainPass",
+		"plainEnvPass",
+		"alicesmith",
+		home,
+	}
+	for name, content := range files {
+		for _, secret := range secrets {
+			assert.NotContains(t, strings.ToLower(content), strings.ToLower(secret), "%v should not contain %v", name, secret)
+		}
+	}
+}
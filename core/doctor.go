@@ -0,0 +1,369 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/andygrunwald/vdf"
+)
+
+const (
+	DoctorPass = "pass"
+	DoctorWarn = "warn"
+	DoctorFail = "fail"
+)
+
+// rclone bisync was added in v1.58
+const minBisyncMajor = 1
+const minBisyncMinor = 58
+
+var rcloneVersionMatcher = regexp.MustCompile(`rclone v(\d+)\.(\d+)`)
+
+// DoctorCheck is the result of one of the checks run by RunDoctor. Hint
+// tells the user how to fix a check that did not pass.
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+func passCheck(name string, format string, args ...any) *DoctorCheck {
+	return &DoctorCheck{Name: name, Status: DoctorPass, Message: fmt.Sprintf(format, args...)}
+}
+
+func warnCheck(name string, hint string, format string, args ...any) *DoctorCheck {
+	return &DoctorCheck{Name: name, Status: DoctorWarn, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+func failCheck(name string, hint string, format string, args ...any) *DoctorCheck {
+	return &DoctorCheck{Name: name, Status: DoctorFail, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// DoctorFailed reports whether any of the checks failed.
+func DoctorFailed(checks []*DoctorCheck) bool {
+	for _, check := range checks {
+		if check.Status == DoctorFail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunDoctor checks everything a sync depends on. Checks that need a working
+// rclone or a selected cloud are skipped with a warning when those are missing.
+func RunDoctor(ctx context.Context, cm *CloudManager) []*DoctorCheck {
+	checks := []*DoctorCheck{}
+
+	rclone := checkRclone(ctx)
+	checks = append(checks, rclone, checkConfigDir())
+
+	perfs, cloudperfs := checkCloudPerfs()
+	checks = append(checks, perfs)
+
+	if rclone.Status == DoctorFail || cloudperfs == nil {
+		reason := "rclone was not found"
+		if cloudperfs == nil {
+			reason = "no cloud is selected"
+		}
+		checks = append(checks,
+			warnCheck("Remote", "", "Skipped because %v", reason),
+			warnCheck("Cloud access", "", "Skipped because %v", reason))
+	} else {
+		checks = append(checks, checkRemote(ctx, cm, cloudperfs)...)
+	}
+
+	checks = append(checks, checkManifestCache(), checkSteam(getSteamRoots()))
+	return checks
+}
+
+// CheckCurrentCloud checks that the remote of the current cloud exists and
+// can be listed with its stored sign in.
+func CheckCurrentCloud(ctx context.Context, cm *CloudManager) []*DoctorCheck {
+	perfs, cloudperfs := checkCloudPerfs()
+	if cloudperfs == nil {
+		return []*DoctorCheck{perfs}
+	}
+
+	return append([]*DoctorCheck{perfs}, checkRemote(ctx, cm, cloudperfs)...)
+}
+
+func parseRcloneVersion(output string) (int, int, error) {
+	match := rcloneVersionMatcher.FindStringSubmatch(output)
+	if match == nil {
+		return 0, 0, fmt.Errorf("unrecognized rclone version output")
+	}
+
+	major, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	minor, err := strconv.Atoi(match[2])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return major, minor, nil
+}
+
+func checkRcloneVersion(output string) *DoctorCheck {
+	const name = "rclone"
+	major, minor, err := parseRcloneVersion(output)
+	if err != nil {
+		return warnCheck(name, "Reinstall rclone from https://rclone.org/downloads/", "Could not read the rclone version: %v", err)
+	}
+
+	if major < minBisyncMajor || (major == minBisyncMajor && minor < minBisyncMinor) {
+		return warnCheck(name, fmt.Sprintf("Update rclone to v%v.%v or later to use bidirectional sync", minBisyncMajor, minBisyncMinor),
+			"rclone v%v.%v is too old for bisync", major, minor)
+	}
+
+	return passCheck(name, "rclone v%v.%v supports bisync", major, minor)
+}
+
+func checkRclone(ctx context.Context) *DoctorCheck {
+	cmd := makeCommand(ctx, getCloudApp(), "version")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return failCheck("rclone", "Install rclone from https://rclone.org/downloads/ and make sure it is on your PATH",
+			"Could not run %v: %v", getCloudApp(), strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+
+	return checkRcloneVersion(string(stdout))
+}
+
+func checkConfigDir() *DoctorCheck {
+	const name = "Config directory"
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return failCheck(name, "Set HOME, or XDG_CONFIG_HOME on Linux", "Could not find the config directory: %v", err)
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return failCheck(name, "Check the permissions of "+dir, "Could not create %v: %v", dir, err)
+	}
+
+	file, err := os.CreateTemp(dir, "doctor-*")
+	if err != nil {
+		return failCheck(name, "Check the permissions and free space of "+dir, "%v is not writable: %v", dir, err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	return passCheck(name, "%v is writable", dir)
+}
+
+func checkCloudPerfs() (*DoctorCheck, *CloudPerfs) {
+	const name = "Settings"
+	path, err := getCloudPath()
+	if err != nil {
+		return failCheck(name, "", "Could not find the settings file: %v", err), nil
+	}
+
+	cloudperfs, err := readCloudPerfs()
+	if os.IsNotExist(err) {
+		return warnCheck(name, "Pick a cloud in the app or with cloud set", "No cloud has been selected yet"), nil
+	}
+	if err != nil {
+		return failCheck(name, "Delete "+path+" and pick a cloud again", "%v could not be read: %v", path, err), nil
+	}
+
+	_, err = GetStorageProvider(cloudperfs.Cloud)
+	if err != nil {
+		return failCheck(name, "Pick a cloud again in the app or with cloud set", "%v", err), nil
+	}
+
+	return passCheck(name, "%v parsed, the current cloud is %v", path, cloudperfs.Cloud), cloudperfs
+}
+
+func checkRemote(ctx context.Context, cm *CloudManager, cloudperfs *CloudPerfs) []*DoctorCheck {
+	storage, err := GetCloudStorage(cloudperfs.Cloud)
+	if err != nil {
+		return []*DoctorCheck{
+			failCheck("Remote", "Pick a cloud again in the app or with cloud set", "%v", err),
+			warnCheck("Cloud access", "", "Skipped because the cloud could not be loaded"),
+		}
+	}
+
+	if !cm.ContainsStorageDrive(ctx, storage) {
+		hint := "Pick the cloud again in the app or with cloud set " + cloudperfs.Cloud
+		if isExternalStorage(storage) {
+			hint = "Check that the remote exists with rclone listremotes"
+		}
+		return []*DoctorCheck{
+			failCheck("Remote", hint, "The rclone remote %v does not exist in %v", storage.GetName(), getRcloneConfigPath(storage)),
+			warnCheck("Cloud access", "", "Skipped because the remote does not exist"),
+		}
+	}
+
+	checks := []*DoctorCheck{passCheck("Remote", "The rclone remote %v exists", storage.GetName())}
+	err = cm.verifyStorageDrive(ctx, storage)
+	if isNeedsReauthError(err) {
+		return append(checks, failCheck("Cloud access", "Sign in again from the app or with cloud reconnect", "%v", err))
+	}
+	if err != nil {
+		return append(checks, failCheck("Cloud access", "Check your network connection and the cloud settings shown by cloud show",
+			"%v could not be listed: %v", storage.GetName(), strings.TrimSpace(err.Error())))
+	}
+
+	return append(checks, passCheck("Cloud access", "%v is reachable and signed in", storage.GetName()))
+}
+
+func getManifestEtagPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, APP_NAME, "etag.ocs"), nil
+}
+
+func checkManifestCache() *DoctorCheck {
+	const name = "Game manifest"
+	location := NewGameRecordManager().GetManifestLocation()
+	etagPath, err := getManifestEtagPath()
+	if err != nil {
+		return failCheck(name, "", "Could not find the manifest cache: %v", err)
+	}
+	hint := fmt.Sprintf("Delete %v and %v, the manifest is downloaded again on the next start", location, etagPath)
+
+	// With an etag the manifest is not downloaded again, so the cache has to be valid
+	_, etagErr := os.Stat(etagPath)
+	data, err := os.ReadFile(location)
+	if os.IsNotExist(err) {
+		if etagErr == nil {
+			return failCheck(name, hint, "%v is missing but %v exists, the app will fail to start", location, etagPath)
+		}
+		return warnCheck(name, "Start the app while online to download it", "The ludusavi manifest has not been downloaded yet")
+	}
+	if err != nil {
+		return failCheck(name, hint, "%v could not be read: %v", location, err)
+	}
+
+	var records map[string]*GameRecord
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&records)
+	if err != nil {
+		return failCheck(name, hint, "%v is corrupt: %v", location, err)
+	}
+
+	if len(records) == 0 {
+		return failCheck(name, hint, "%v has no games", location)
+	}
+
+	return passCheck(name, "%v has %v games", location, len(records))
+}
+
+func getSteamRoots() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return []string{}
+	}
+
+	switch runtime.GOOS {
+	case WindowsPlatform:
+		return []string{"C:\\Program Files (x86)\\Steam", "C:\\Program Files\\Steam"}
+	case DarwinPlatform:
+		return []string{filepath.Join(home, "Library", "Application Support", "Steam"), "/Applications/Steam"}
+	case LinuxPlatform:
+		return []string{
+			filepath.Join(home, ".local", "share", "Steam"),
+			filepath.Join(home, ".steam", "steam"),
+			filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+			filepath.Join(home, "snap", "steam", "common", ".local", "share", "Steam"),
+		}
+	default:
+		return []string{}
+	}
+}
+
+func readSteamLibraries(steamRoot string) ([]string, error) {
+	file, err := os.Open(filepath.Join(steamRoot, "steamapps", "libraryfolders.vdf"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	libraryFoldersMap, err := vdf.NewParser(file).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(libraryFoldersMap)
+	if err != nil {
+		return nil, err
+	}
+
+	libraryFolders := LibraryFolders{}
+	err = json.Unmarshal(data, &libraryFolders)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, libraryFolder := range libraryFolders.LibraryFolders {
+		if libraryFolder.Path != "" {
+			result = append(result, libraryFolder.Path)
+		}
+	}
+
+	return result, nil
+}
+
+func checkSteam(steamRoots []string) *DoctorCheck {
+	const name = "Steam"
+	installs := []string{}
+	seen := make(map[string]bool)
+	for _, root := range steamRoots {
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		installs = append(installs, root)
+	}
+
+	if len(installs) == 0 {
+		return warnCheck(name, "Games installed outside of Steam can still be synced", "No Steam install was found")
+	}
+
+	libraries := 0
+	missing := []string{}
+	for _, root := range installs {
+		paths, err := readSteamLibraries(root)
+		if err != nil {
+			return warnCheck(name, "Start Steam once so it writes its library list",
+				"The libraries of the Steam install in %v could not be read: %v", root, err)
+		}
+
+		for _, path := range paths {
+			_, err := os.Stat(path)
+			if err != nil {
+				missing = append(missing, path)
+				continue
+			}
+			libraries++
+		}
+	}
+
+	if len(missing) > 0 {
+		return warnCheck(name, "Mount the drives holding these libraries, or remove them in Steam's storage settings",
+			"Found %v Steam installs and %v libraries, these libraries are missing: %v", len(installs), libraries, strings.Join(missing, ", "))
+	}
+
+	return passCheck(name, "Found %v Steam installs and %v libraries", len(installs), libraries)
+}
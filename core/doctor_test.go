@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRcloneVersion(t *testing.T) {
+	assert.Equal(t, DoctorPass, checkRcloneVersion("rclone v1.62.2\n- os/version: arch").Status)
+	assert.Equal(t, DoctorPass, checkRcloneVersion("rclone v1.58.0").Status)
+	assert.Equal(t, DoctorPass, checkRcloneVersion("rclone v2.0.0").Status)
+	assert.Equal(t, DoctorWarn, checkRcloneVersion("rclone v1.57.1").Status, "rclone before v1.58 has no bisync")
+	assert.Equal(t, DoctorWarn, checkRcloneVersion("something else").Status)
+}
+
+func TestCheckCloudPerfs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+
+	check, cloudperfs := checkCloudPerfs()
+	assert.Equal(t, DoctorWarn, check.Status, "No cloud selected yet")
+	assert.Nil(t, cloudperfs)
+
+	path, err := getCloudPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	assert.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+	check, cloudperfs = checkCloudPerfs()
+	assert.Equal(t, DoctorFail, check.Status)
+	assert.NotEmpty(t, check.Hint)
+	assert.Nil(t, cloudperfs)
+
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+	check, cloudperfs = checkCloudPerfs()
+	assert.Equal(t, DoctorPass, check.Status)
+	assert.Equal(t, DROPBOX, cloudperfs.Cloud)
+
+	assert.Equal(t, DoctorPass, checkConfigDir().Status)
+}
+
+func TestCheckManifestCache(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	location := NewGameRecordManager().GetManifestLocation()
+	etagPath, err := getManifestEtagPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(location), os.ModePerm))
+
+	assert.Equal(t, DoctorWarn, checkManifestCache().Status, "The manifest is downloaded on the next start")
+
+	assert.NoError(t, os.WriteFile(etagPath, []byte("etag"), 0644))
+	assert.Equal(t, DoctorFail, checkManifestCache().Status, "A missing manifest with an etag is never downloaded again")
+
+	assert.NoError(t, os.WriteFile(location, []byte("garbage"), 0644))
+	assert.Equal(t, DoctorFail, checkManifestCache().Status)
+
+	var b bytes.Buffer
+	records := map[string]*GameRecord{"Celeste": {Steam: SteamProperties{Id: 504230}}}
+	assert.NoError(t, gob.NewEncoder(&b).Encode(records))
+	assert.NoError(t, os.WriteFile(location, b.Bytes(), 0644))
+	assert.Equal(t, DoctorPass, checkManifestCache().Status)
+}
+
+func TestCheckSteam(t *testing.T) {
+	assert.Equal(t, DoctorWarn, checkSteam([]string{filepath.Join(t.TempDir(), "missing")}).Status)
+
+	root := t.TempDir()
+	library := t.TempDir()
+	missing := filepath.Join(t.TempDir(), "unmounted")
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "steamapps"), os.ModePerm))
+	vdf := fmt.Sprintf(`"libraryfolders"
+{
+	"0"
+	{
+		"path"		"%v"
+		"apps"
+		{
+			"504230"		"1234"
+		}
+	}
+	"1"
+	{
+		"path"		"%v"
+	}
+}
+`, library, missing)
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "steamapps", "libraryfolders.vdf"), []byte(vdf), 0644))
+
+	check := checkSteam([]string{root, root})
+	assert.Equal(t, DoctorWarn, check.Status, "A library on an unmounted drive should be reported")
+	assert.Contains(t, check.Message, missing)
+
+	assert.NoError(t, os.MkdirAll(missing, os.ModePerm))
+	check = checkSteam([]string{root, root})
+	assert.Equal(t, DoctorPass, check.Status)
+	assert.Equal(t, "Found 1 Steam installs and 2 libraries", check.Message, "The same install should only be counted once")
+}
+
+func TestRunDoctorWithoutCloud(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+
+	checks := RunDoctor(context.Background(), MakeCloudManager())
+	names := []string{}
+	for _, check := range checks {
+		names = append(names, check.Name)
+		assert.Contains(t, []string{DoctorPass, DoctorWarn, DoctorFail}, check.Status)
+	}
+	assert.Equal(t, []string{"rclone", "Config directory", "Settings", "Remote", "Cloud access", "Game manifest", "Steam"}, names)
+	assert.Equal(t, DoctorWarn, checks[3].Status, "Remote checks should be skipped without a cloud")
+}
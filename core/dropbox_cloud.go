@@ -6,14 +6,28 @@ import (
 )
 
 type DropBoxStorage struct {
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (gs *DropBoxStorage) GetName() string {
+	if gs.Remote != "" {
+		return gs.Remote
+	}
+
 	return "opencloudsave-dropbox"
 }
 
 func (gs *DropBoxStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
-	return makeCommand(ctx, getCloudApp(), "config", "create", gs.GetName(), "dropbox")
+	return makeOAuthCreationCommand(ctx, gs)
+}
+
+func (gs *DropBoxStorage) GetRcloneType() string {
+	return "dropbox"
+}
+
+func (gs *DropBoxStorage) GetConfigParameters() map[string]string {
+	return map[string]string{}
 }
 
 var dropboxStorage *DropBoxStorage
@@ -25,3 +39,15 @@ func GetDropBoxStorage() Storage {
 
 	return dropboxStorage
 }
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          DROPBOX,
+		DisplayName: "Dropbox",
+		OAuth:       true,
+		Storage:     GetDropBoxStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &DropBoxStorage{Remote: remote}
+		},
+	})
+}
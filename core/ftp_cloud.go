@@ -10,14 +10,33 @@ type FtpStorage struct {
 	UserName string `json:"userName"`
 	Port     string `json:"port"`
 	Password string `json:"password"`
+
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (ftpfs *FtpStorage) GetName() string {
+	if ftpfs.Remote != "" {
+		return ftpfs.Remote
+	}
+
 	return "opencloudsave-ftp"
 }
 
 func (ftpfs *FtpStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
 	args := []string{"config", "create", ftpfs.GetName(), "ftp"}
+	args = append(args, ftpfs.getParameters()...)
+	return makeCommand(ctx, getCloudApp(), args...)
+}
+
+func (ftpfs *FtpStorage) GetUpdateCommand(ctx context.Context) *exec.Cmd {
+	args := []string{"config", "update", ftpfs.GetName()}
+	args = append(args, ftpfs.getParameters()...)
+	return makeCommand(ctx, getCloudApp(), args...)
+}
+
+func (ftpfs *FtpStorage) getParameters() []string {
+	args := []string{}
 	if ftpfs.Host != "" {
 		args = append(args, "host="+ftpfs.Host)
 	}
@@ -31,7 +50,7 @@ func (ftpfs *FtpStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
 		args = append(args, "pass="+ftpfs.Password)
 	}
 
-	return makeCommand(ctx, getCloudApp(), args...)
+	return args
 }
 
 var ftpDrive *FtpStorage
@@ -48,8 +67,47 @@ func SetFtpDriveStorage(ftp *FtpStorage) {
 
 func GetFtpDriveStorage() Storage {
 	if ftpDrive == nil {
-		ftpDrive = &FtpStorage{}
+		settings := GetProviderSettings(FTP)
+		ftpDrive = &FtpStorage{
+			Host:     settings["host"],
+			UserName: settings["user"],
+			Port:     settings["port"],
+		}
 	}
 
 	return ftpDrive
 }
+
+func configureFtpStorage(settings map[string]string) (Storage, error) {
+	ftp := &FtpStorage{
+		Host:     settings["host"],
+		UserName: settings["user"],
+		Port:     settings["port"],
+	}
+
+	SetFtpDriveStorage(ftp)
+	return ftp, nil
+}
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          FTP,
+		DisplayName: "Custom FTP Server",
+		Fields: []ProviderField{
+			{Key: "host", Label: "Host", Required: true},
+			{Key: "port", Label: "Port", Default: "21"},
+			{Key: "user", Label: "User Name"},
+			{Key: "pass", Label: "Password", Secret: true, Obscure: true},
+		},
+		Configure: configureFtpStorage,
+		Storage:   GetFtpDriveStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &FtpStorage{
+				Remote:   remote,
+				Host:     settings["host"],
+				UserName: settings["user"],
+				Port:     settings["port"],
+			}
+		},
+	})
+}
@@ -31,6 +31,11 @@ type GameDef struct {
 	Hidden                bool        `json:"hidden"`
 	CustomFlags           string      `json:"flags"`
 	SelectInMultisyncMenu bool        `json:"selectMultiSync"`
+	RemotePath            string      `json:"remote_path,omitempty"`
+	Storage               string      `json:"storage,omitempty"`
+	// The folder the game is installed in, used to notice it running. Games
+	// from Steam are found by their SteamId instead.
+	InstallPath string `json:"install_path,omitempty"`
 }
 
 type SyncFile struct {
@@ -199,8 +204,13 @@ func (d *FsGameDefManager) RemoveGameDef(key string) {
 
 func (d *FsGameDefManager) ApplyUserOverrides() error {
 	fileName := d.GetUserOverrideLocation()
+	var content []byte
 	fsmtx.Lock()
-	content, err := os.ReadFile(fileName)
+	err := withSettingsLock(func() error {
+		var err error
+		content, err = os.ReadFile(fileName)
+		return err
+	})
 	fsmtx.Unlock()
 	if err != nil {
 		InfoLogger.Println(err)
@@ -241,7 +251,13 @@ func (d *FsGameDefManager) CommitUserOverrides() error {
 
 	fileName := d.GetUserOverrideLocation()
 	fsmtx.Lock()
-	err = os.WriteFile(fileName, newResult, os.ModePerm)
+	err = withSettingsLock(func() error {
+		err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(fileName, newResult, os.ModePerm)
+	})
 	fsmtx.Unlock()
 
 	if err != nil {
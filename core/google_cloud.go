@@ -6,14 +6,28 @@ import (
 )
 
 type GoogleStorage struct {
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (gs *GoogleStorage) GetName() string {
+	if gs.Remote != "" {
+		return gs.Remote
+	}
+
 	return "opencloudsave-googledrive"
 }
 
 func (gs *GoogleStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
-	return makeCommand(ctx, getCloudApp(), "config", "create", gs.GetName(), "drive", "scope=drive.file")
+	return makeOAuthCreationCommand(ctx, gs)
+}
+
+func (gs *GoogleStorage) GetRcloneType() string {
+	return "drive"
+}
+
+func (gs *GoogleStorage) GetConfigParameters() map[string]string {
+	return map[string]string{"scope": "drive.file"}
 }
 
 var gdrive *GoogleStorage
@@ -25,3 +39,15 @@ func GetGoogleDriveStorage() Storage {
 
 	return gdrive
 }
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          GOOGLE,
+		DisplayName: "Google Drive",
+		OAuth:       true,
+		Storage:     GetGoogleDriveStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &GoogleStorage{Remote: remote}
+		},
+	})
+}
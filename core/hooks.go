@@ -0,0 +1,329 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"opencloudsave/platform"
+)
+
+const HooksFilename = "hooks.json"
+
+// The events hooks are run for
+const (
+	HookPreSync    = "pre-sync"
+	HookPostSync   = "post-sync"
+	HookOnConflict = "on-conflict"
+	HookOnFailure  = "on-failure"
+)
+
+// Which way a sync moves saves, told from the sync status before it
+const (
+	SyncDirectionUpload   = "upload"
+	SyncDirectionDownload = "download"
+	SyncDirectionBoth     = "both"
+	SyncDirectionNone     = "none"
+	SyncDirectionUnknown  = "unknown"
+)
+
+// The results of a sync told to hooks
+const (
+	HookResultDone    = "done"
+	HookResultFailed  = "failed"
+	HookResultAborted = "aborted"
+)
+
+// How long a hook may run before it is killed
+var hookTimeout = 5 * time.Minute
+
+var hooksMtx sync.Mutex
+
+// SyncHooks are commands run around the syncs of games, e.g. to stop a
+// server before its world is downloaded. They are run by the shell of the
+// platform.
+type SyncHooks struct {
+	// Run before syncing, the sync is aborted when it fails
+	PreSync  string `json:"pre_sync,omitempty"`
+	PostSync string `json:"post_sync,omitempty"`
+	// Run before syncing when the saves changed locally and in the cloud
+	OnConflict string `json:"on_conflict,omitempty"`
+	OnFailure  string `json:"on_failure,omitempty"`
+}
+
+func (h *SyncHooks) get(event string) string {
+	if h == nil {
+		return ""
+	}
+
+	switch event {
+	case HookPreSync:
+		return h.PreSync
+	case HookPostSync:
+		return h.PostSync
+	case HookOnConflict:
+		return h.OnConflict
+	case HookOnFailure:
+		return h.OnFailure
+	}
+
+	return ""
+}
+
+// IsEmpty reports whether no hook is set.
+func (h *SyncHooks) IsEmpty() bool {
+	return h == nil || (h.PreSync == "" && h.PostSync == "" && h.OnConflict == "" && h.OnFailure == "")
+}
+
+// HookFailedError is returned when a hook exits with an error.
+type HookFailedError struct {
+	Event string
+	Game  string
+	// The last line the hook printed
+	Output string
+	Err    error
+}
+
+func (e *HookFailedError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("the %v hook of %v failed: %v", e.Event, e.Game, e.Err)
+	}
+
+	return fmt.Sprintf("the %v hook of %v failed: %v: %v", e.Event, e.Game, e.Err, e.Output)
+}
+
+func (e *HookFailedError) Unwrap() error {
+	return e.Err
+}
+
+// HookEvent describes the sync of a game to its hooks.
+type HookEvent struct {
+	Game   string
+	SyncId string
+	// The local save folders of the game
+	LocalPaths []string
+	// The rclone path of the game's cloud folder, e.g. dropbox:opencloudsaves/Celeste/
+	RemotePath string
+	Direction  string
+	// Set once the sync finished
+	Result string
+	Files  int
+	Bytes  int64
+	Error  string
+}
+
+func (e *HookEvent) environment(event string) []string {
+	return append(os.Environ(),
+		"OPENCLOUDSAVE_EVENT="+event,
+		"OPENCLOUDSAVE_GAME="+e.Game,
+		"OPENCLOUDSAVE_SYNC_ID="+e.SyncId,
+		"OPENCLOUDSAVE_LOCAL_PATHS="+strings.Join(e.LocalPaths, string(os.PathListSeparator)),
+		"OPENCLOUDSAVE_REMOTE_PATH="+e.RemotePath,
+		"OPENCLOUDSAVE_DIRECTION="+e.Direction,
+		"OPENCLOUDSAVE_RESULT="+e.Result,
+		fmt.Sprintf("OPENCLOUDSAVE_FILES=%v", e.Files),
+		fmt.Sprintf("OPENCLOUDSAVE_BYTES=%v", e.Bytes),
+		"OPENCLOUDSAVE_ERROR="+e.Error,
+	)
+}
+
+// deviceHooks are the hooks set on this device, kept in HooksFilename.
+type deviceHooks struct {
+	Global *SyncHooks            `json:"global,omitempty"`
+	Games  map[string]*SyncHooks `json:"games,omitempty"`
+}
+
+func getHooksPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + HooksFilename, nil
+}
+
+func readDeviceHooks() (*deviceHooks, error) {
+	result := &deviceHooks{}
+	path, err := getHooksPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// updateDeviceHooks changes the hooks of this device with update.
+func updateDeviceHooks(update func(*deviceHooks)) error {
+	hooksMtx.Lock()
+	defer hooksMtx.Unlock()
+
+	hooks, err := readDeviceHooks()
+	if err != nil {
+		return err
+	}
+	update(hooks)
+
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getHooksPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func getDeviceHooks() *deviceHooks {
+	hooksMtx.Lock()
+	defer hooksMtx.Unlock()
+
+	hooks, err := readDeviceHooks()
+	if err != nil {
+		WarnLogger.Printf("Ignoring the hooks: %v", err)
+		return &deviceHooks{}
+	}
+
+	return hooks
+}
+
+// GetSyncHooks returns the hooks run around the syncs of every game.
+func GetSyncHooks() *SyncHooks {
+	hooks := getDeviceHooks().Global
+	if hooks == nil {
+		return &SyncHooks{}
+	}
+
+	return hooks
+}
+
+// SetSyncHooks sets the hooks run around the syncs of every game.
+func SetSyncHooks(hooks *SyncHooks) error {
+	return updateDeviceHooks(func(device *deviceHooks) {
+		device.Global = hooks
+		if hooks.IsEmpty() {
+			device.Global = nil
+		}
+	})
+}
+
+// GetGameSyncHooks returns the hooks run around the syncs of game, after
+// the ones of every game.
+func GetGameSyncHooks(game string) *SyncHooks {
+	hooks := getDeviceHooks().Games[game]
+	if hooks == nil {
+		return &SyncHooks{}
+	}
+
+	return hooks
+}
+
+// SetGameSyncHooks sets the hooks run around the syncs of game.
+func SetGameSyncHooks(game string, hooks *SyncHooks) error {
+	return updateDeviceHooks(func(device *deviceHooks) {
+		if hooks.IsEmpty() {
+			delete(device.Games, game)
+			return
+		}
+
+		if device.Games == nil {
+			device.Games = make(map[string]*SyncHooks)
+		}
+		device.Games[game] = hooks
+	})
+}
+
+// getSyncDirection tells which way a sync will move saves from the status
+// of the game before it.
+func getSyncDirection(status *GameSyncStatus) string {
+	switch status.Status {
+	case SyncStatusLocalNewer, SyncStatusLocalOnly:
+		return SyncDirectionUpload
+	case SyncStatusRemoteNewer, SyncStatusRemoteOnly, SyncStatusNotInstalled:
+		return SyncDirectionDownload
+	case SyncStatusBothChanged:
+		return SyncDirectionBoth
+	case SyncStatusUpToDate:
+		return SyncDirectionNone
+	}
+
+	return SyncDirectionUnknown
+}
+
+// getSyncHooks returns the hooks run for the syncs of game, the global ones
+// first.
+func getSyncHooks(game string) []*SyncHooks {
+	hooks := []*SyncHooks{}
+	device := getDeviceHooks()
+	if !device.Global.IsEmpty() {
+		hooks = append(hooks, device.Global)
+	}
+	if !device.Games[game].IsEmpty() {
+		hooks = append(hooks, device.Games[game])
+	}
+
+	return hooks
+}
+
+// runHooks runs the hooks of event one after the other. Once one fails,
+// the others are not run and its HookFailedError is returned.
+func runHooks(ctx context.Context, hooks []*SyncHooks, event string, hookEvent *HookEvent, logs chan Message) error {
+	for _, hook := range hooks {
+		command := hook.get(event)
+		if command == "" {
+			continue
+		}
+
+		LogMessage(logs, "Running the %v hook of %v", event, hookEvent.Game)
+		InfoLoggerFor(ctx).Printf("Running the %v hook: %v", event, command)
+		hookCtx, cancel := context.WithTimeout(ctx, hookTimeout)
+		cmd := platform.ShellCommand(hookCtx, command)
+		cmd.Env = hookEvent.environment(event)
+		output, err := cmd.CombinedOutput()
+		cancel()
+
+		trimmed := strings.TrimSpace(string(output))
+		if trimmed != "" {
+			InfoLoggerFor(ctx).Println(trimmed)
+		}
+		if err != nil {
+			if hookCtx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %v", hookTimeout)
+			}
+
+			lines := strings.Split(trimmed, "\n")
+			return &HookFailedError{Event: event, Game: hookEvent.Game, Output: lines[len(lines)-1], Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConflictRclone lists a save that changed both locally and in the cloud.
+const fakeConflictRclone = `#!/bin/sh
+case "$*" in
+*lsjson*opencloudsaves*)
+	echo '[{"Path":"save.dat","Name":"save.dat","Size":10,"ModTime":"2024-01-01T00:00:00Z"}]'
+	;;
+*lsjson*)
+	echo '[{"Path":"save.dat","Name":"save.dat","Size":20,"ModTime":"2024-01-01T00:00:00Z"}]'
+	;;
+esac
+`
+
+// recordHook returns a hook that appends its event and environment to path.
+func recordHook(path string) string {
+	return fmt.Sprintf("env | grep ^OPENCLOUDSAVE_ | sort >> %v; echo >> %v", path, path)
+}
+
+// readHookRuns returns the environment of every hook recorded to path.
+func readHookRuns(t *testing.T, path string) []map[string]string {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	assert.NoError(t, err)
+
+	runs := []map[string]string{}
+	for _, block := range strings.Split(strings.TrimSpace(string(data)), "\n\n") {
+		env := make(map[string]string)
+		for _, line := range strings.Split(block, "\n") {
+			key, value, _ := strings.Cut(line, "=")
+			env[strings.TrimPrefix(key, "OPENCLOUDSAVE_")] = value
+		}
+		runs = append(runs, env)
+	}
+	return runs
+}
+
+func TestSyncHooks(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+	runs := filepath.Join(t.TempDir(), "runs")
+	assert.NoError(t, SetSyncHooks(&SyncHooks{PostSync: recordHook(runs)}))
+	assert.NoError(t, SetGameSyncHooks("Hollow", &SyncHooks{PreSync: recordHook(runs), OnFailure: recordHook(runs)}))
+	gamedef := dm.GetGameDefMap()["Hollow"]
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+
+	recorded := readHookRuns(t, runs)
+	assert.Len(t, recorded, 2)
+	assert.Equal(t, HookPreSync, recorded[0]["EVENT"])
+	assert.Equal(t, "Hollow", recorded[0]["GAME"])
+	assert.Equal(t, gamedef.LinuxPath[0].Path, filepath.Clean(recorded[0]["LOCAL_PATHS"]))
+	assert.Equal(t, "opencloudsave-dropbox:opencloudsaves/Hollow/", recorded[0]["REMOTE_PATH"])
+	assert.Equal(t, SyncDirectionNone, recorded[0]["DIRECTION"])
+	assert.Equal(t, "", recorded[0]["RESULT"])
+	assert.Equal(t, events[0].SyncId, recorded[0]["SYNC_ID"])
+
+	assert.Equal(t, HookPostSync, recorded[1]["EVENT"])
+	assert.Equal(t, HookResultDone, recorded[1]["RESULT"])
+	assert.Equal(t, "4", recorded[1]["FILES"])
+	assert.Equal(t, "3072", recorded[1]["BYTES"])
+
+	// Dry runs change nothing, so no hooks are run
+	assert.NoError(t, os.Remove(runs))
+	runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}, DryRun: []bool{true}})
+	assert.Empty(t, readHookRuns(t, runs))
+}
+
+func TestFailingPreSyncHook(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+	runs := filepath.Join(t.TempDir(), "runs")
+	assert.NoError(t, SetSyncHooks(&SyncHooks{PostSync: recordHook(runs), OnFailure: recordHook(runs)}))
+	assert.NoError(t, SetGameSyncHooks("Hollow", &SyncHooks{PreSync: "echo starting; echo the server is still running; exit 2"}))
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	last := events[len(events)-1]
+	assert.Equal(t, PhaseError, last.Phase)
+	assert.Equal(t, ErrorKindHook, last.ErrorKind)
+	assert.Equal(t, "the pre-sync hook of Hollow failed: exit status 2: the server is still running", last.Error)
+	for _, event := range events {
+		assert.NotEqual(t, PhaseSync, event.Phase)
+	}
+
+	recorded := readHookRuns(t, runs)
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, HookOnFailure, recorded[0]["EVENT"])
+	assert.Equal(t, HookResultAborted, recorded[0]["RESULT"])
+	assert.Equal(t, last.Error, recorded[0]["ERROR"])
+}
+
+func TestConflictHook(t *testing.T) {
+	dm := setupRunGame(t, fakeConflictRclone)
+	runs := filepath.Join(t.TempDir(), "runs")
+	assert.NoError(t, SetGameSyncHooks("Hollow", &SyncHooks{OnConflict: recordHook(runs), PostSync: "exit 1"}))
+
+	// Failing hooks after the sync started do not fail it
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+
+	recorded := readHookRuns(t, runs)
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, HookOnConflict, recorded[0]["EVENT"])
+	assert.Equal(t, SyncDirectionBoth, recorded[0]["DIRECTION"])
+}
+
+func TestSetSyncHooks(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+	assert.True(t, GetSyncHooks().IsEmpty())
+
+	assert.NoError(t, SetSyncHooks(&SyncHooks{OnFailure: "notify-send failed"}))
+	assert.Equal(t, &SyncHooks{OnFailure: "notify-send failed"}, GetSyncHooks())
+
+	assert.NoError(t, SetGameSyncHooks("Hollow", &SyncHooks{PreSync: "systemctl --user stop hollow"}))
+	assert.Equal(t, &SyncHooks{PreSync: "systemctl --user stop hollow"}, GetGameSyncHooks("Hollow"))
+	assert.True(t, GetGameSyncHooks("Celeste").IsEmpty())
+
+	// The hooks are kept on this device, out of the synced settings
+	dir, err := getCloudPerfDir()
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, HooksFilename))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"global":{"on_failure":"notify-send failed"},"games":{"Hollow":{"pre_sync":"systemctl --user stop hollow"}}}`, string(data))
+	assert.Contains(t, localSettingsFiles, HooksFilename)
+
+	assert.NoError(t, SetSyncHooks(&SyncHooks{}))
+	assert.NoError(t, SetGameSyncHooks("Hollow", nil))
+	data, err = os.ReadFile(filepath.Join(dir, HooksFilename))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(data))
+}
@@ -0,0 +1,487 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The lease is kept in the cloud folder of a game while a device syncs it,
+// so other devices do not write to the folder at the same time
+const LeaseFilename = ".opencloudsave-lease.json"
+
+const DeviceIdFilename = "device_id"
+
+// How long a lease is valid for without being renewed
+const DefaultLeaseTTL = 10 * time.Minute
+
+// How long the daemon, the scheduler and the session watcher wait for
+// other devices to finish syncing a game
+const DefaultLeaseWait = time.Minute
+
+// How long releasing a lease may take, even when the sync was canceled
+const leaseReleaseTimeout = 30 * time.Second
+
+// rclone exits with this when a file does not exist
+const rcloneFileNotFoundExitCode = 4
+
+// How often a lease held by another device is read again
+var leaseRetryInterval = 5 * time.Second
+
+// How often a held lease is renewed, well before it expires
+var leaseRenewInterval = DefaultLeaseTTL / 3
+
+// Lease tells other devices that a device is syncing a game.
+type Lease struct {
+	// The host name of the device, shown to others
+	Device   string `json:"device"`
+	DeviceId string `json:"deviceId"`
+	// What syncs the game on the device, e.g. Desktop GUI
+	Holder string `json:"holder,omitempty"`
+	// When the lease was taken or last renewed
+	Timestamp time.Time `json:"timestamp"`
+	// Seconds after Timestamp the lease expires
+	TTL int64 `json:"ttl"`
+	// Tells apart the leases a device took
+	Token string `json:"token"`
+}
+
+// Expires returns when the lease expires unless it is renewed.
+func (l *Lease) Expires() time.Time {
+	return l.Timestamp.Add(time.Duration(l.TTL) * time.Second)
+}
+
+// IsStale reports whether the lease expired, most likely because its device
+// crashed or lost its connection while syncing.
+func (l *Lease) IsStale(now time.Time) bool {
+	return !now.Before(l.Expires())
+}
+
+func (l *Lease) describe() string {
+	if l.Holder == "" {
+		return l.Device
+	}
+
+	return fmt.Sprintf("%v on %v", l.Holder, l.Device)
+}
+
+// LeaseHeldError is returned when another device holds the lease of a game.
+type LeaseHeldError struct {
+	Game  string
+	Lease *Lease
+	Stale bool
+}
+
+func (e *LeaseHeldError) Error() string {
+	if e.Stale {
+		return fmt.Sprintf("the lease of %v taken by %v expired at %v, break it with `lease break %v` once %v is no longer syncing",
+			e.Game, e.Lease.describe(), e.Lease.Expires().Format(time.RFC3339), e.Game, e.Lease.Device)
+	}
+
+	return fmt.Sprintf("%v is syncing %v, its lease expires at %v", e.Lease.describe(), e.Game, e.Lease.Expires().Format(time.RFC3339))
+}
+
+// HeldLease is a lease this device took, renewed until it is released or
+// another device takes it over.
+type HeldLease struct {
+	cm       *CloudManager
+	storage  Storage
+	game     string
+	location string
+	lease    *Lease
+	// Canceled once the lease is lost
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Why the lease was lost, set before broken is closed
+	lost   error
+	broken chan bool
+	stop   chan bool
+	done   chan bool
+}
+
+func getDeviceIdPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + DeviceIdFilename, nil
+}
+
+// getDeviceId returns the id of this device, which is created on first use.
+// It is kept out of the settings, since those are synced between devices.
+func getDeviceId() (string, error) {
+	path, err := getDeviceIdPath()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id, err := newLeaseToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	return id, os.WriteFile(path, []byte(id+"\n"), 0644)
+}
+
+// GetDeviceName returns the name other devices see in the leases of this
+// device.
+func GetDeviceName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "an unknown device"
+	}
+
+	return name
+}
+
+func newLeaseToken() (string, error) {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func getLeaseLocation(storage Storage, remotePath string) string {
+	return getRemoteLocation(storage, path.Join(remotePath, LeaseFilename))
+}
+
+func (cm *CloudManager) readLease(ctx context.Context, storage Storage, location string) (*Lease, error) {
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "cat", location)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok && (exiterr.ExitCode() == rcloneDirNotFoundExitCode || exiterr.ExitCode() == rcloneFileNotFoundExitCode) {
+			return nil, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, checkAuthError(storage, stderr.String())
+	}
+
+	// Some backends create the file before its content is written
+	if strings.TrimSpace(stdout.String()) == "" {
+		return nil, nil
+	}
+
+	lease := &Lease{}
+	err = json.Unmarshal([]byte(stdout.String()), lease)
+	if err != nil {
+		return nil, fmt.Errorf("the lease %v could not be read: %v", location, err)
+	}
+
+	return lease, nil
+}
+
+func (cm *CloudManager) writeLease(ctx context.Context, storage Storage, location string, lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "rcat", location)
+	cmd.Stdin = strings.NewReader(string(data))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return checkAuthError(storage, stderr.String())
+	}
+
+	return nil
+}
+
+func (cm *CloudManager) deleteLease(ctx context.Context, storage Storage, location string) error {
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "deletefile", location)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok && (exiterr.ExitCode() == rcloneDirNotFoundExitCode || exiterr.ExitCode() == rcloneFileNotFoundExitCode) {
+			return nil
+		}
+
+		return checkAuthError(storage, stderr.String())
+	}
+
+	return nil
+}
+
+// AcquireLease takes the lease of game, kept in remotePath on storage. When
+// another device holds it, it is read again until wait passed, then a
+// LeaseHeldError is returned. Expired leases of other devices are never
+// taken over, they have to be broken with BreakGameLease.
+func (cm *CloudManager) AcquireLease(ctx context.Context, storage Storage, game string, remotePath string, wait time.Duration) (*HeldLease, error) {
+	deviceId, err := getDeviceId()
+	if err != nil {
+		return nil, err
+	}
+
+	location := getLeaseLocation(storage, remotePath)
+	deadline := time.Now().Add(wait)
+	for {
+		current, err := cm.readLease(ctx, storage, location)
+		if err != nil {
+			return nil, err
+		}
+
+		// Leases of this device are left over by syncs that crashed, as
+		// the lock of the game is held
+		if current != nil && current.DeviceId != deviceId {
+			now := time.Now()
+			if current.IsStale(now) {
+				return nil, &LeaseHeldError{Game: game, Lease: current, Stale: true}
+			}
+			if !now.Before(deadline) {
+				return nil, &LeaseHeldError{Game: game, Lease: current}
+			}
+
+			InfoLoggerFor(ctx).Printf("Waiting for %v to finish syncing %v", current.describe(), game)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(leaseRetryInterval):
+			}
+			continue
+		}
+
+		token, err := newLeaseToken()
+		if err != nil {
+			return nil, err
+		}
+
+		lease := &Lease{
+			Device:    GetDeviceName(),
+			DeviceId:  deviceId,
+			Holder:    getLockHolder(),
+			Timestamp: time.Now(),
+			TTL:       int64(DefaultLeaseTTL / time.Second),
+			Token:     token,
+		}
+		err = cm.writeLease(ctx, storage, location, lease)
+		if err != nil {
+			return nil, err
+		}
+
+		// Another device may have written its lease at the same time, the
+		// last one written wins. Backends that list new files late may not
+		// return it yet.
+		written, err := cm.readLease(ctx, storage, location)
+		if err != nil {
+			return nil, err
+		}
+		if written != nil && written.Token != token {
+			continue
+		}
+
+		leaseCtx, cancel := context.WithCancel(ctx)
+		held := &HeldLease{
+			cm:       cm,
+			storage:  storage,
+			game:     game,
+			location: location,
+			lease:    lease,
+			ctx:      leaseCtx,
+			cancel:   cancel,
+			broken:   make(chan bool),
+			stop:     make(chan bool),
+			done:     make(chan bool),
+		}
+		go held.renew()
+		return held, nil
+	}
+}
+
+// renew writes the lease again before it expires, until it is released.
+// The lease is read first, so one that another device broke or took over
+// is never written back. The sync is stopped instead.
+func (h *HeldLease) renew() {
+	defer close(h.done)
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(leaseRenewInterval):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+		current, err := h.cm.readLease(ctx, h.storage, h.location)
+		if err == nil && current == nil {
+			cancel()
+			h.lose(fmt.Errorf("the lease of %v was broken while syncing", h.game))
+			return
+		}
+		if err == nil && current.Token != h.lease.Token {
+			cancel()
+			h.lose(&LeaseHeldError{Game: h.game, Lease: current})
+			return
+		}
+		if err == nil {
+			h.lease.Timestamp = time.Now()
+			err = h.cm.writeLease(ctx, h.storage, h.location, h.lease)
+		}
+		cancel()
+		if err != nil {
+			WarnLogger.Printf("Could not renew the lease %v: %v", h.location, err)
+		}
+	}
+}
+
+func (h *HeldLease) lose(err error) {
+	h.lost = err
+	close(h.broken)
+	h.cancel()
+}
+
+// Context returns a context that is canceled once the lease is lost to
+// another device, for the sync the lease guards.
+func (h *HeldLease) Context() context.Context {
+	return h.ctx
+}
+
+// Lost returns why the lease was lost, or nil while it is held. Leases of
+// nil are never lost.
+func (h *HeldLease) Lost() error {
+	if h == nil {
+		return nil
+	}
+
+	select {
+	case <-h.broken:
+		return h.lost
+	default:
+		return nil
+	}
+}
+
+// Release stops renewing the lease and removes it, unless another device
+// broke it in the meantime. Releasing nil does nothing.
+func (h *HeldLease) Release() error {
+	if h == nil {
+		return nil
+	}
+
+	close(h.stop)
+	<-h.done
+	h.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+
+	current, err := h.cm.readLease(ctx, h.storage, h.location)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+	if current.Token != h.lease.Token {
+		WarnLogger.Printf("The lease %v was broken while syncing", h.location)
+		return nil
+	}
+
+	return h.cm.deleteLease(ctx, h.storage, h.location)
+}
+
+func getGameLeaseLocation(dm GameDefManager, game string) (Storage, string, error) {
+	gamedef := dm.GetGameDefMap()[game]
+	if gamedef == nil {
+		return nil, "", WithErrorKind(ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", game))
+	}
+
+	storage, err := GetGameStorage(gamedef)
+	if err != nil {
+		return nil, "", WithErrorKind(ErrorKindStorage, err)
+	}
+
+	remotePath, err := GetGameRemotePath(game, gamedef)
+	if err != nil {
+		return nil, "", WithErrorKind(ErrorKindPaths, err)
+	}
+
+	return storage, getLeaseLocation(storage, remotePath), nil
+}
+
+// GetGameLease returns the lease of game, or nil when no device is syncing
+// it.
+func GetGameLease(ctx context.Context, cm *CloudManager, dm GameDefManager, game string) (*Lease, error) {
+	storage, location, err := getGameLeaseLocation(dm, game)
+	if err != nil {
+		return nil, err
+	}
+
+	return cm.readLease(ctx, storage, location)
+}
+
+// BreakGameLease removes the lease of game so other devices can sync it
+// again, and returns the lease that was broken. Leases that did not expire
+// yet are only broken with force.
+func BreakGameLease(ctx context.Context, cm *CloudManager, dm GameDefManager, game string, force bool) (*Lease, error) {
+	storage, location, err := getGameLeaseLocation(dm, game)
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := cm.readLease(ctx, storage, location)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, fmt.Errorf("no device holds the lease of %v", game)
+	}
+	if !lease.IsStale(time.Now()) && !force {
+		return nil, WithErrorKind(ErrorKindLeased, fmt.Errorf("%v is syncing %v until %v, use --force to break the lease anyway",
+			lease.describe(), game, lease.Expires().Format(time.RFC3339)))
+	}
+
+	err = cm.deleteLease(ctx, storage, location)
+	if err != nil {
+		return nil, err
+	}
+
+	InfoLogger.Printf("Broke the lease of %v taken by %v", game, lease.describe())
+	return lease, nil
+}
+
+// isLeaseHeldError reports whether err tells that another device holds a
+// lease.
+func isLeaseHeldError(err error) bool {
+	var leaseErr *LeaseHeldError
+	return errors.As(err, &leaseErr)
+}
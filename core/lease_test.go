@@ -0,0 +1,219 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLocalRclone serves the remote of the local storage from the folder
+// %[1]v, like the alias remote rclone makes for it. The command lines are
+// logged to %[2]v.
+const fakeLocalRclone = `#!/bin/sh
+echo "$*" >> %[2]v
+for arg; do location=$arg; done
+file="%[1]v/${location#*:}"
+case " $* " in
+*" cat "*)
+	cat "$file" 2>/dev/null || exit 3
+	;;
+*" rcat "*)
+	mkdir -p "$(dirname "$file")"
+	cat > "$file"
+	;;
+*" deletefile "*)
+	[ -f "$file" ] || exit 4
+	rm "$file"
+	;;
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`
+
+// setupLeaseGame tracks the game Hollow, synced to a local storage. It
+// returns the path of the game's lease and the log of the rclone commands.
+func setupLeaseGame(t *testing.T) (GameDefManager, string, string) {
+	root := t.TempDir()
+	commands := filepath.Join(t.TempDir(), "commands")
+	dm := setupRunGame(t, fmt.Sprintf(fakeLocalRclone, root, commands))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: LOCAL}))
+	SetLocalStorage(&LocalStorage{Path: root})
+	t.Cleanup(func() {
+		SetLocalStorage(nil)
+	})
+
+	return dm, filepath.Join(root, ToplevelCloudFolder, "Hollow", LeaseFilename), commands
+}
+
+func writeLease(t *testing.T, path string, lease *Lease) {
+	data, err := json.Marshal(lease)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// runSync runs a sync with ops and returns its progress events.
+func runSync(ctx context.Context, dm GameDefManager, ops *Options) []*ProgressEvent {
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RequestMainOperation(ctx, MakeCloudManager(), ops, dm, channels)
+		close(channels.Logs)
+	}()
+
+	events := []*ProgressEvent{}
+	for msg := range channels.Logs {
+		if msg.Event != nil {
+			events = append(events, msg.Event)
+		}
+	}
+	return events
+}
+
+func TestLease(t *testing.T) {
+	dm, leasePath, commands := setupLeaseGame(t)
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+	// The lease is taken before syncing, left out of the sync and removed
+	// afterwards
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "rcat opencloudsave-local:opencloudsaves/Hollow/"+LeaseFilename)
+	assert.Contains(t, string(log), "--filter=- /"+LeaseFilename+" sync")
+	assert.Contains(t, string(log), "deletefile opencloudsave-local:opencloudsaves/Hollow/"+LeaseFilename)
+	assert.NoFileExists(t, leasePath)
+
+	// Dry runs do not write anything
+	assert.NoError(t, os.Remove(commands))
+	runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}, DryRun: []bool{true}})
+	log, err = os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(log), "rcat")
+
+	// Leases a crashed sync of this device left are taken over
+	deviceId, err := getDeviceId()
+	assert.NoError(t, err)
+	writeLease(t, leasePath, &Lease{Device: GetDeviceName(), DeviceId: deviceId, Timestamp: time.Now(), TTL: 600, Token: "crashed"})
+	events = runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+	assert.NoFileExists(t, leasePath)
+}
+
+func TestLeaseHeldByAnotherDevice(t *testing.T) {
+	dm, leasePath, _ := setupLeaseGame(t)
+	leaseRetryInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		leaseRetryInterval = 5 * time.Second
+	})
+
+	lease := &Lease{Device: "steamdeck", DeviceId: "deck", Holder: "The daemon", Timestamp: time.Now(), TTL: 600, Token: "deck"}
+	writeLease(t, leasePath, lease)
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	last := events[len(events)-1]
+	assert.Equal(t, PhaseError, last.Phase)
+	assert.Equal(t, ErrorKindLeased, last.ErrorKind)
+	assert.Equal(t, fmt.Sprintf("The daemon on steamdeck is syncing Hollow, its lease expires at %v", lease.Expires().Format(time.RFC3339)), last.Error)
+	assert.FileExists(t, leasePath)
+
+	current, err := GetGameLease(context.Background(), MakeCloudManager(), dm, "Hollow")
+	assert.NoError(t, err)
+	assert.Equal(t, "steamdeck", current.Device)
+	assert.False(t, current.IsStale(time.Now()))
+
+	// Syncs wait for the other device to finish
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Remove(leasePath)
+	}()
+	events = runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}, LeaseWait: 5 * time.Second})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+	assert.NoFileExists(t, leasePath)
+
+	// Leases that did not expire are only broken with force
+	writeLease(t, leasePath, lease)
+	_, err = BreakGameLease(context.Background(), MakeCloudManager(), dm, "Hollow", false)
+	assert.Error(t, err)
+	assert.Equal(t, ErrorKindLeased, GetErrorKind(err))
+	assert.FileExists(t, leasePath)
+
+	broken, err := BreakGameLease(context.Background(), MakeCloudManager(), dm, "Hollow", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "steamdeck", broken.Device)
+	assert.NoFileExists(t, leasePath)
+
+	_, err = BreakGameLease(context.Background(), MakeCloudManager(), dm, "Hollow", false)
+	assert.EqualError(t, err, "no device holds the lease of Hollow")
+}
+
+func TestLeaseTakenOverWhileSyncing(t *testing.T) {
+	dm, leasePath, commands := setupLeaseGame(t)
+	leaseRenewInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		leaseRenewInterval = DefaultLeaseTTL / 3
+	})
+
+	// Another device takes the lease over once the sync started
+	lease := &Lease{Device: "steamdeck", DeviceId: "deck", Timestamp: time.Now(), TTL: 600, Token: "deck"}
+	data, err := json.Marshal(lease)
+	assert.NoError(t, err)
+	root := filepath.Dir(filepath.Dir(filepath.Dir(leasePath)))
+	useFakeRclone(t, strings.Replace(fmt.Sprintf(fakeLocalRclone, root, commands), "*lsjson*)", fmt.Sprintf(`*" sync "*)
+	echo '%v' > %v
+	exec sleep 10
+	;;
+*lsjson*)`, string(data), leasePath), 1))
+
+	started := time.Now()
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Less(t, time.Since(started), 5*time.Second, "The sync should be stopped")
+	last := events[len(events)-1]
+	assert.Equal(t, PhaseError, last.Phase)
+	assert.Equal(t, ErrorKindLeased, last.ErrorKind)
+	assert.Equal(t, fmt.Sprintf("steamdeck is syncing Hollow, its lease expires at %v", lease.Expires().Format(time.RFC3339)), last.Error)
+
+	// The lease of the other device is neither renewed nor removed
+	current, err := GetGameLease(context.Background(), MakeCloudManager(), dm, "Hollow")
+	assert.NoError(t, err)
+	assert.Equal(t, "deck", current.Token)
+	assert.True(t, lease.Timestamp.Equal(current.Timestamp))
+}
+
+func TestStaleLease(t *testing.T) {
+	dm, leasePath, _ := setupLeaseGame(t)
+
+	lease := &Lease{Device: "steamdeck", DeviceId: "deck", Timestamp: time.Now().Add(-time.Hour), TTL: 600, Token: "deck"}
+	writeLease(t, leasePath, lease)
+	assert.True(t, lease.IsStale(time.Now()))
+
+	// Waiting does not help, the lease has to be broken
+	start := time.Now()
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}, LeaseWait: 5 * time.Second})
+	assert.Less(t, time.Since(start), 5*time.Second)
+	last := events[len(events)-1]
+	assert.Equal(t, ErrorKindLeased, last.ErrorKind)
+	assert.True(t, strings.Contains(last.Error, "break it with `lease break Hollow`"), last.Error)
+
+	broken, err := BreakGameLease(context.Background(), MakeCloudManager(), dm, "Hollow", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "deck", broken.Token)
+
+	events = runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+}
+
+func TestLeaseFilters(t *testing.T) {
+	ops := &CloudOperationOptions{Include: "*.sav", Excludes: []string{"/" + LeaseFilename}}
+	assert.Equal(t, []string{"--filter=- /" + LeaseFilename, "--filter=+ *.sav", "--filter=- **"}, constructArgs(ops))
+
+	ops.Include = ""
+	assert.Equal(t, []string{"--filter=- /" + LeaseFilename}, constructArgs(ops))
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage uses a plain directory as the "cloud". This can be a network
+// mount (SMB/NFS), a removable drive or a folder managed by another sync tool.
+// It is backed by an rclone alias remote pointing at the directory.
+type LocalStorage struct {
+	Path string `json:"path"`
+
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
+}
+
+func (ls *LocalStorage) GetName() string {
+	if ls.Remote != "" {
+		return ls.Remote
+	}
+
+	return "opencloudsave-local"
+}
+
+func (ls *LocalStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
+	return makeCommand(ctx, getCloudApp(), "config", "create", ls.GetName(), "alias", "remote="+ls.Path)
+}
+
+func (ls *LocalStorage) GetUpdateCommand(ctx context.Context) *exec.Cmd {
+	return makeCommand(ctx, getCloudApp(), "config", "update", ls.GetName(), "remote="+ls.Path)
+}
+
+var localStorage *LocalStorage
+
+func DeleteLocalStorage(ctx context.Context) error {
+	storage := &LocalStorage{}
+	cm := MakeCloudManager()
+	return cm.DeleteCloudEntry(ctx, storage)
+}
+
+func SetLocalStorage(ls *LocalStorage) {
+	localStorage = ls
+}
+
+// GetLocalStorage returns the local storage. Its folder is a provider
+// setting, as a mount on one device may not exist on another.
+func GetLocalStorage() Storage {
+	if localStorage == nil {
+		// Older perfs held the folder, which is moved when they are read
+		GetCurrentCloudPerfs()
+		localStorage = &LocalStorage{
+			Path: GetProviderSettings(LOCAL)["path"],
+		}
+	}
+
+	return localStorage
+}
+
+// migrateLocalStoragePath moves the folder of the local storage out of the
+// synced perfs. A folder set on this device already is kept.
+func migrateLocalStoragePath(path string) error {
+	if GetProviderSettings(LOCAL)["path"] != "" {
+		return nil
+	}
+
+	provider, err := getRegisteredStorageProvider(LOCAL)
+	if err != nil {
+		return err
+	}
+
+	InfoLogger.Println("Moving the local storage folder to the provider settings")
+	return commitProviderSettings(provider, map[string]string{"path": path})
+}
+
+func isSubPath(parent string, child string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)))
+}
+
+// ValidateLocalStoragePath checks that path can be used as a storage target:
+// it must be an absolute, writable directory that neither contains nor lives
+// inside any of the save paths known to dm.
+func ValidateLocalStoragePath(path string, dm GameDefManager) error {
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("no storage folder provided")
+	}
+
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("storage folder %v must be an absolute path", path)
+	}
+
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("storage folder %v is not a directory", path)
+	}
+
+	probe, err := os.CreateTemp(path, ".opencloudsave-probe-*")
+	if err != nil {
+		return fmt.Errorf("storage folder %v is not writable: %v", path, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if dm == nil {
+		return nil
+	}
+
+	for key, def := range dm.GetGameDefMap() {
+		syncpaths, err := def.GetSyncpaths()
+		if err != nil {
+			continue
+		}
+
+		for _, syncpath := range syncpaths {
+			savePath := filepath.Clean(syncpath.Path)
+			if savePath == "" || savePath == string(os.PathSeparator) {
+				continue
+			}
+
+			if isSubPath(savePath, path) || isSubPath(path, savePath) {
+				return fmt.Errorf("storage folder %v overlaps the save path %v of %v", path, savePath, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetLocalStoragePath validates path and persists it as the local storage
+// target in the provider settings of this device.
+func SetLocalStoragePath(path string, dm GameDefManager) error {
+	err := ValidateLocalStoragePath(path, dm)
+	if err != nil {
+		return err
+	}
+
+	provider, err := getRegisteredStorageProvider(LOCAL)
+	if err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	err = commitProviderSettings(provider, map[string]string{"path": path})
+	if err != nil {
+		return err
+	}
+
+	SetLocalStorage(&LocalStorage{Path: path})
+	return nil
+}
+
+// The provider settings are committed by ApplySettings
+func configureLocalStorage(settings map[string]string) (Storage, error) {
+	ls := &LocalStorage{
+		Path: filepath.Clean(settings["path"]),
+	}
+	SetLocalStorage(ls)
+	return ls, nil
+}
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          LOCAL,
+		DisplayName: "Local or Mounted Folder",
+		Fields: []ProviderField{
+			{Key: "path", Label: "Folder", Required: true, Directory: true},
+		},
+		Validate: func(settings map[string]string) error {
+			return ValidateLocalStoragePath(settings["path"], MakeDefaultGameDefManager())
+		},
+		Configure: configureLocalStorage,
+		Storage:   GetLocalStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &LocalStorage{Remote: remote, Path: settings["path"]}
+		},
+	})
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGameDef returns a game saving to savePath on every platform.
+func testGameDef(name string, savePath string) *GameDef {
+	datapath := []*Datapath{{Path: savePath}}
+	return &GameDef{DisplayName: name, WinPath: datapath, LinuxPath: datapath, DarwinPath: datapath}
+}
+
+// makeTestGameDefManager writes games to the user overrides and returns a
+// manager reading them.
+func makeTestGameDefManager(t *testing.T, games ...*GameDef) GameDefManager {
+	dir := t.TempDir()
+	err := InitLoggingWithPath(filepath.Join(dir, "test.log"))
+	assert.NoError(t, err)
+
+	overrides := map[string]*GameDef{}
+	for _, gamedef := range games {
+		overrides[gamedef.DisplayName] = gamedef
+	}
+	content, err := json.Marshal(overrides)
+	assert.NoError(t, err)
+
+	overridePath := filepath.Join(dir, UserOverrideFilename)
+	err = os.WriteFile(overridePath, content, 0644)
+	assert.NoError(t, err)
+
+	return MakeGameDefManager(overridePath)
+}
+
+func makeLocalStorageTestManager(t *testing.T, savePath string) GameDefManager {
+	return makeTestGameDefManager(t, testGameDef("LocalStorageTestGame", savePath))
+}
+
+func TestValidateLocalStoragePath(t *testing.T) {
+	saveDir := t.TempDir()
+	dm := makeLocalStorageTestManager(t, saveDir)
+
+	err := ValidateLocalStoragePath("", dm)
+	assert.Error(t, err, "An empty path should be rejected")
+
+	err = ValidateLocalStoragePath("relative/path", dm)
+	assert.Error(t, err, "A relative path should be rejected")
+
+	err = ValidateLocalStoragePath(filepath.Join(t.TempDir(), "missing"), dm)
+	assert.Error(t, err, "A missing directory should be rejected")
+
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	err = ValidateLocalStoragePath(file, dm)
+	assert.Error(t, err, "A regular file should be rejected")
+
+	nested := filepath.Join(saveDir, "backup")
+	assert.NoError(t, os.Mkdir(nested, 0755))
+	err = ValidateLocalStoragePath(nested, dm)
+	assert.Error(t, err, "A folder inside a save path should be rejected")
+
+	err = ValidateLocalStoragePath(filepath.Dir(saveDir), dm)
+	assert.Error(t, err, "A folder containing a save path should be rejected")
+
+	err = ValidateLocalStoragePath(t.TempDir(), dm)
+	assert.NoError(t, err, "An unrelated writable folder should be accepted")
+}
+
+func TestLocalStorageSync(t *testing.T) {
+	if _, err := exec.LookPath(getCloudApp()); err != nil {
+		t.Skip("rclone is not available")
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dm := makeLocalStorageTestManager(t, t.TempDir())
+
+	saveDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(saveDir, "save.dat"), []byte("save data"), 0644)
+	assert.NoError(t, err)
+
+	target := t.TempDir()
+	assert.NoError(t, ValidateLocalStoragePath(target, dm))
+
+	ctx := context.Background()
+	cm := MakeCloudManager()
+	storage := &LocalStorage{Path: target}
+	err = cm.CreateDriveIfNotExists(ctx, storage)
+	assert.NoError(t, err, "Creating the local remote should not return an error")
+
+	_, err = cm.PerformSyncOperation(ctx, storage, GetDefaultCloudOptions(), saveDir, ToplevelCloudFolder+"LocalStorageTestGame/")
+	assert.NoError(t, err, "Syncing to the local remote should not return an error")
+
+	data, err := os.ReadFile(filepath.Join(target, ToplevelCloudFolder, "LocalStorageTestGame", "save.dat"))
+	assert.NoError(t, err, "The save file should exist in the storage folder")
+	assert.Equal(t, "save data", string(data))
+}
+
+func TestLocalStoragePathStaysOnDevice(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	makeLocalStorageTestManager(t, t.TempDir())
+	SetLocalStorage(nil)
+	t.Cleanup(func() {
+		SetLocalStorage(nil)
+	})
+
+	// Older versions kept the folder in the synced perfs
+	path, err := getCloudPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	assert.NoError(t, os.WriteFile(path, []byte(`{"cloud":"local","localStoragePath":"/mnt/nas"}`), 0644))
+
+	assert.Equal(t, "/mnt/nas", GetLocalStorage().(*LocalStorage).Path)
+	assert.Equal(t, "/mnt/nas", GetProviderSettings(LOCAL)["path"])
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "/mnt/nas")
+
+	target := t.TempDir()
+	assert.NoError(t, SetLocalStoragePath(target, MakeGameDefManager(filepath.Join(t.TempDir(), UserOverrideFilename))))
+	assert.Equal(t, target, GetProviderSettings(LOCAL)["path"])
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), target)
+}
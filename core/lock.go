@@ -0,0 +1,337 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"opencloudsave/platform"
+)
+
+const LocksDirname = "locks"
+
+// The lock kept while the settings files are read or written, next to the
+// folder of the game locks
+const settingsLockFilename = "settings.lock"
+
+// The lock kept while the settings are synced with the cloud
+const settingsSyncLockFilename = "settings_sync.lock"
+
+// How often a held lock is tried again
+const lockRetryInterval = 100 * time.Millisecond
+
+// How long reading or writing the settings waits for other processes
+const settingsLockTimeout = 30 * time.Second
+
+var unsafeLockNameMatcher = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Tells others holding which lock this process is, see SetLockHolder
+var lockHolder = APP_NAME
+var lockHolderMtx sync.Mutex
+
+// The lock of the settings is taken once by the goroutines of this process
+// that read or write them
+var settingsLock *FileLock
+var settingsLockUsers int
+var settingsLockMtx sync.Mutex
+
+// SetLockHolder sets what this process is called when others find the
+// locks it holds, e.g. Desktop GUI.
+func SetLockHolder(holder string) {
+	lockHolderMtx.Lock()
+	defer lockHolderMtx.Unlock()
+	lockHolder = holder
+}
+
+func getLockHolder() string {
+	lockHolderMtx.Lock()
+	defer lockHolderMtx.Unlock()
+	return lockHolder
+}
+
+// GameLockedError is returned when another process holds the lock of a game.
+type GameLockedError struct {
+	Game   string
+	Holder string
+	Pid    int
+}
+
+func (e *GameLockedError) Error() string {
+	if e.Holder == "" {
+		return fmt.Sprintf("%v is being synced by another process", e.Game)
+	}
+
+	return fmt.Sprintf("%v is syncing %v", e.Holder, e.Game)
+}
+
+// SettingsLockedError is returned when another process kept the settings
+// locked for too long.
+type SettingsLockedError struct {
+	Holder string
+	Pid    int
+}
+
+func (e *SettingsLockedError) Error() string {
+	if e.Holder == "" {
+		return "the settings are being changed by another process"
+	}
+
+	return fmt.Sprintf("%v is changing the settings", e.Holder)
+}
+
+// lockInfo is written into the lock file, to tell who holds it.
+type lockInfo struct {
+	Pid    int       `json:"pid"`
+	Holder string    `json:"holder"`
+	Since  time.Time `json:"since"`
+}
+
+// FileLock keeps other processes from syncing a game, or from changing the
+// settings, while it is held. The lock is released when the process exits,
+// even if Unlock is never called.
+type FileLock struct {
+	name string
+	file *os.File
+}
+
+type heldGameLocksKey struct{}
+
+func getGameLockPath(game string) (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	// Game names may hold characters file names can not
+	hash := fnv.New32a()
+	hash.Write([]byte(game))
+	filename := fmt.Sprintf("%v-%08x.lock", unsafeLockNameMatcher.ReplaceAllString(game, "_"), hash.Sum32())
+
+	return filepath.Join(dir, LocksDirname, "games", filename), nil
+}
+
+func getSettingsLockPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, LocksDirname, settingsLockFilename), nil
+}
+
+func getSettingsSyncLockPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, LocksDirname, settingsSyncLockFilename), nil
+}
+
+// readLockInfo returns who holds the lock file at path. Holders that are no
+// longer running are left out.
+func readLockInfo(path string) *lockInfo {
+	info := &lockInfo{}
+	data, _ := os.ReadFile(path)
+	if json.Unmarshal(data, info) != nil || !platform.ProcessExists(info.Pid) {
+		return &lockInfo{}
+	}
+
+	return info
+}
+
+// tryLock takes the lock file at path, called name, without waiting. It
+// returns platform.ErrLocked along with the holder when another process
+// holds it.
+func tryLock(name string, path string) (*FileLock, *lockInfo, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = platform.TryLockFile(file)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, platform.ErrLocked) {
+			return nil, readLockInfo(path), err
+		}
+		return nil, nil, err
+	}
+
+	// Holders clear the file when they unlock, so a holder left in it
+	// crashed or was killed
+	previous := &lockInfo{}
+	data, _ := os.ReadFile(path)
+	if json.Unmarshal(data, previous) == nil && previous.Pid != 0 {
+		WarnLogger.Printf("Took over the stale lock of %v held by %v (pid %v) since %v", name, previous.Holder, previous.Pid, previous.Since)
+	}
+
+	data, err = json.Marshal(&lockInfo{
+		Pid:    os.Getpid(),
+		Holder: getLockHolder(),
+		Since:  time.Now(),
+	})
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt(data, 0)
+	}
+	if err != nil {
+		// The lock still works, only its holder is unknown to others
+		WarnLogger.Println(err)
+	}
+
+	return &FileLock{name: name, file: file}, nil, nil
+}
+
+// waitLock takes the lock file at path, waiting for it until ctx is done.
+func waitLock(ctx context.Context, name string, path string) (*FileLock, *lockInfo, error) {
+	for {
+		lock, holder, err := tryLock(name, path)
+		if !errors.Is(err, platform.ErrLocked) {
+			return lock, holder, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, holder, err
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// TryLockGame takes the lock of game without waiting. A GameLockedError is
+// returned when another process, or another sync of this process, holds it.
+func TryLockGame(game string) (*FileLock, error) {
+	path, err := getGameLockPath(game)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, holder, err := tryLock(game, path)
+	if errors.Is(err, platform.ErrLocked) {
+		return nil, &GameLockedError{Game: game, Holder: holder.Holder, Pid: holder.Pid}
+	}
+
+	return lock, err
+}
+
+// LockGame takes the lock of game, waiting for it until ctx is done.
+func LockGame(ctx context.Context, game string) (*FileLock, error) {
+	path, err := getGameLockPath(game)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, holder, err := waitLock(ctx, game, path)
+	if errors.Is(err, platform.ErrLocked) {
+		return nil, &GameLockedError{Game: game, Holder: holder.Holder, Pid: holder.Pid}
+	}
+
+	return lock, err
+}
+
+// WithGameLock returns ctx telling the syncs run with it that lock is held,
+// so they do not try to take it again.
+func WithGameLock(ctx context.Context, lock *FileLock) context.Context {
+	held := map[string]bool{lock.name: true}
+	previous, _ := ctx.Value(heldGameLocksKey{}).(map[string]bool)
+	for game := range previous {
+		held[game] = true
+	}
+
+	return context.WithValue(ctx, heldGameLocksKey{}, held)
+}
+
+// lockGameForSync takes the lock of game for a sync run with ctx. Nothing is
+// locked when ctx tells the lock is already held, see WithGameLock.
+func lockGameForSync(ctx context.Context, game string) (*FileLock, error) {
+	held, _ := ctx.Value(heldGameLocksKey{}).(map[string]bool)
+	if held[game] {
+		return nil, nil
+	}
+
+	return TryLockGame(game)
+}
+
+// withSettingsLock runs fn while holding the lock of the settings files, so
+// other processes do not read them half written. The lock is shared by the
+// whole process. It is never held while rclone runs, see syncSettingsFiles.
+func withSettingsLock(fn func() error) error {
+	settingsLockMtx.Lock()
+	if settingsLockUsers > 0 {
+		settingsLockUsers++
+		settingsLockMtx.Unlock()
+		defer releaseSettingsLock()
+		return fn()
+	}
+	settingsLockMtx.Unlock()
+
+	path, err := getSettingsLockPath()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settingsLockTimeout)
+	defer cancel()
+
+	held, holder, err := waitLock(ctx, "the settings", path)
+	if errors.Is(err, platform.ErrLocked) {
+		return &SettingsLockedError{Holder: holder.Holder, Pid: holder.Pid}
+	}
+	if err != nil {
+		return err
+	}
+
+	settingsLockMtx.Lock()
+	settingsLockUsers++
+	if settingsLock == nil {
+		settingsLock = held
+	} else {
+		// Another goroutine took the lock first
+		held.Unlock()
+	}
+	settingsLockMtx.Unlock()
+	defer releaseSettingsLock()
+
+	return fn()
+}
+
+func releaseSettingsLock() {
+	settingsLockMtx.Lock()
+	defer settingsLockMtx.Unlock()
+
+	settingsLockUsers--
+	if settingsLockUsers == 0 {
+		settingsLock.Unlock()
+		settingsLock = nil
+	}
+}
+
+// Unlock releases the lock. Unlocking nil does nothing.
+func (l *FileLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+
+	l.file.Truncate(0)
+	err := platform.UnlockFile(l.file)
+	closeErr := l.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
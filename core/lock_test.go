@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"opencloudsave/platform"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useLockHolder(t *testing.T, holder string) {
+	SetLockHolder(holder)
+	t.Cleanup(func() {
+		SetLockHolder(APP_NAME)
+	})
+}
+
+// deadPid returns the id of a process that exited.
+func deadPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestGameLock(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	useLockHolder(t, "Desktop GUI")
+
+	lock, err := TryLockGame("Hollow Knight: Silksong")
+	assert.NoError(t, err)
+
+	_, err = TryLockGame("Hollow Knight: Silksong")
+	var lockedErr *GameLockedError
+	assert.True(t, errors.As(err, &lockedErr))
+	assert.Equal(t, &GameLockedError{Game: "Hollow Knight: Silksong", Holder: "Desktop GUI", Pid: os.Getpid()}, lockedErr)
+	assert.EqualError(t, err, "Desktop GUI is syncing Hollow Knight: Silksong")
+
+	// Other games are not locked
+	other, err := TryLockGame("Celeste")
+	assert.NoError(t, err)
+	assert.NoError(t, other.Unlock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = LockGame(ctx, "Hollow Knight: Silksong")
+	assert.True(t, errors.As(err, &lockedErr))
+
+	assert.NoError(t, lock.Unlock())
+	lock, err = TryLockGame("Hollow Knight: Silksong")
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestStaleGameLock(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(t.TempDir()+"/test.log"))
+
+	pid := deadPid(t)
+	assert.False(t, platform.ProcessExists(pid))
+	assert.True(t, platform.ProcessExists(os.Getpid()))
+
+	// A holder that was killed leaves its lock file behind
+	path, err := getGameLockPath("Celeste")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	stale, err := json.Marshal(&lockInfo{Pid: pid, Holder: "The daemon", Since: time.Now()})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, stale, 0600))
+
+	lock, err := TryLockGame("Celeste")
+	assert.NoError(t, err)
+
+	// Holders that are no longer running are not reported
+	assert.NoError(t, os.WriteFile(path, stale, 0600))
+	_, err = TryLockGame("Celeste")
+	assert.EqualError(t, err, "Celeste is being synced by another process")
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestSettingsLock(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	useLockHolder(t, "The scheduler")
+
+	path, err := getSettingsLockPath()
+	assert.NoError(t, err)
+
+	err = withSettingsLock(func() error {
+		// The lock is held once for the whole process
+		return withSettingsLock(func() error {
+			_, holder, err := tryLock("the settings", path)
+			assert.ErrorIs(t, err, platform.ErrLocked)
+			assert.Equal(t, "The scheduler", holder.Holder)
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+
+	lock, _, err := tryLock("the settings", path)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestSyncSkipsLockedGames(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+	useLockHolder(t, "Desktop GUI")
+
+	lock, err := TryLockGame("Hollow")
+	assert.NoError(t, err)
+	defer lock.Unlock()
+
+	sync := func(ctx context.Context) []*ProgressEvent {
+		return runSync(ctx, dm, &Options{Gamenames: []string{"Hollow"}, Output: OutputJSON})
+	}
+
+	events := sync(context.Background())
+	last := events[len(events)-1]
+	assert.Equal(t, PhaseError, last.Phase)
+	assert.Equal(t, ErrorKindLocked, last.ErrorKind)
+	assert.Equal(t, "Desktop GUI is syncing Hollow", last.Error)
+
+	// Syncs run by the holder of the lock go ahead
+	events = sync(WithGameLock(context.Background(), lock))
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+
+	// The lock is released after each sync
+	assert.NoError(t, lock.Unlock())
+	sync(context.Background())
+	lock, err = TryLockGame("Hollow")
+	assert.NoError(t, err)
+}
@@ -1,19 +1,109 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var InfoLogger *log.Logger
+var WarnLogger *log.Logger
 var ErrorLogger *log.Logger
 
+var logFilePath string
+
 const DefaultLogPath = "opencloudsave.log"
 
+const (
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// LogEntry is a line of the logfile. Every line is a JSON object, so logs
+// can be filtered by level or by the sync that wrote them.
+type LogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"msg"`
+	Caller  string `json:"caller,omitempty"`
+	SyncId  string `json:"sync,omitempty"`
+}
+
+type syncIdKey struct{}
+
+var logOutputMtx sync.Mutex
+var logOutput io.Writer
+
+// logWriter turns the lines of a log.Logger into redacted LogEntries.
+type logWriter struct {
+	level  string
+	syncId string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	caller := ""
+	if w.level == LogLevelError {
+		// The error logger prefixes the file and line of the caller
+		i := strings.Index(message, ": ")
+		if i > 0 && strings.Contains(message[:i], ".go:") {
+			caller = message[:i]
+			message = message[i+2:]
+		}
+	}
+
+	entry := LogEntry{
+		Time:    time.Now().Format(time.RFC3339),
+		Level:   w.level,
+		Message: logRedactor.Redact(message),
+		Caller:  caller,
+		SyncId:  w.syncId,
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	logOutputMtx.Lock()
+	defer logOutputMtx.Unlock()
+	if logOutput == nil {
+		return len(p), nil
+	}
+
+	_, err = logOutput.Write(data.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func newLogger(level string, syncId string) *log.Logger {
+	flags := 0
+	if level == LogLevelError {
+		flags = log.Lshortfile
+	}
+
+	return log.New(&logWriter{level: level, syncId: syncId}, "", flags)
+}
+
 func InitLoggingWithDefaultPath() error {
 	path, err := os.UserCacheDir()
 	if err != nil {
@@ -24,7 +114,8 @@ func InitLoggingWithDefaultPath() error {
 }
 
 func InitLoggingWithPath(path string) error {
-	fmt.Println("Creating logfile at " + path)
+	fmt.Fprintln(os.Stderr, "Creating logfile at "+path)
+	logFilePath = path
 	logger := &lumberjack.Logger{
 		Filename:   path,
 		MaxSize:    250, // Megabytes
@@ -32,8 +123,78 @@ func InitLoggingWithPath(path string) error {
 		MaxBackups: 1,
 	}
 
-	InfoLogger = log.New(logger, "INFO\t", log.Ldate|log.Ltime)
-	ErrorLogger = log.New(logger, "ERROR\t", log.Lshortfile|log.Ldate|log.Ltime)
-	log.SetOutput(logger)
+	logOutputMtx.Lock()
+	logOutput = logger
+	logOutputMtx.Unlock()
+
+	InfoLogger = newLogger(LogLevelInfo, "")
+	WarnLogger = newLogger(LogLevelWarn, "")
+	ErrorLogger = newLogger(LogLevelError, "")
+	log.SetFlags(0)
+	log.SetOutput(&logWriter{level: LogLevelInfo})
 	return nil
 }
+
+// GetLogPath returns the path of the current logfile. Rotated logfiles are
+// kept next to it.
+func GetLogPath() string {
+	return logFilePath
+}
+
+// NewSyncId returns a random id that ties together the log lines of a sync.
+func NewSyncId() string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// WithSyncId returns a context whose log lines carry syncId.
+func WithSyncId(ctx context.Context, syncId string) context.Context {
+	return context.WithValue(ctx, syncIdKey{}, syncId)
+}
+
+func GetSyncId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	syncId, _ := ctx.Value(syncIdKey{}).(string)
+	return syncId
+}
+
+// InfoLoggerFor returns the info logger for ctx. Its lines carry the id of
+// the sync running in ctx, if any.
+func InfoLoggerFor(ctx context.Context) *log.Logger {
+	syncId := GetSyncId(ctx)
+	if syncId == "" {
+		return InfoLogger
+	}
+
+	return newLogger(LogLevelInfo, syncId)
+}
+
+// WarnLoggerFor returns the warning logger for ctx. Its lines carry the id
+// of the sync running in ctx, if any.
+func WarnLoggerFor(ctx context.Context) *log.Logger {
+	syncId := GetSyncId(ctx)
+	if syncId == "" {
+		return WarnLogger
+	}
+
+	return newLogger(LogLevelWarn, syncId)
+}
+
+// ErrorLoggerFor returns the error logger for ctx. Its lines carry the id of
+// the sync running in ctx, if any.
+func ErrorLoggerFor(ctx context.Context) *log.Logger {
+	syncId := GetSyncId(ctx)
+	if syncId == "" {
+		return ErrorLogger
+	}
+
+	return newLogger(LogLevelError, syncId)
+}
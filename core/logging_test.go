@@ -0,0 +1,98 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readLogEntries(t *testing.T, path string) []LogEntry {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	entries := []LogEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := LogEntry{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "Every line should be a JSON object")
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestLogsAreStructured(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, InitLoggingWithPath(path))
+
+	ctx := WithSyncId(context.Background(), "abcd1234")
+	InfoLogger.Println("Launching")
+	WarnLoggerFor(ctx).Println("Secondary cloud mirror failed")
+	ErrorLoggerFor(ctx).Println("sync failed")
+
+	entries := readLogEntries(t, path)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, LogLevelInfo, entries[0].Level)
+	assert.Equal(t, "Launching", entries[0].Message)
+	assert.Empty(t, entries[0].SyncId)
+	assert.Equal(t, LogLevelWarn, entries[1].Level)
+	assert.Equal(t, "abcd1234", entries[1].SyncId)
+	assert.Equal(t, LogLevelError, entries[2].Level)
+	assert.Equal(t, "sync failed", entries[2].Message)
+	assert.Contains(t, entries[2].Caller, "logging_test.go:")
+	assert.NotEmpty(t, entries[2].Time)
+
+	assert.NotEqual(t, NewSyncId(), NewSyncId())
+}
+
+func TestSecretsNeverReachTheLog(t *testing.T) {
+	useFakeRclone(t, "#!/bin/sh\nexit 1\n")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	useMemorySecretStore(t)
+	path := filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, InitLoggingWithPath(path))
+	ctx := context.Background()
+
+	// Command lines
+	makeCommand(ctx, getCloudApp(), "config", "create", "opencloudsave-ftp", "ftp", "host=example.com", "pass=obscuredFtpPass")
+	makeCommand(ctx, getCloudApp(), "config", "update", "opencloudsave-nextcloud", "bearer_token=nextcloudBearer")
+	MakeCloudManager().ObscurePassword(ctx, "plainHunter2")
+
+	// rclone output and errors
+	InfoLogger.Println(`{"opencloudsave-google":{"type":"drive","token":"{\"access_token\":\"ya29.dumpAccess\",\"refresh_token\":\"1//dumpRefresh\"}"}}`)
+	ErrorLogger.Println("RCLONE_CONFIG_OPENCLOUDSAVE_FTP_PASS=envFtpPass rclone failed")
+	ErrorLogger.Println("401 Unauthorized, Authorization: Bearer headerBearer")
+
+	// Known secrets, even without a key next to them
+	assert.NoError(t, SetStorageSecret(GetNextCloudStorage(), "bearer_token", "storedBearerSecret"))
+	InfoLogger.Println("request with storedBearerSecret failed")
+	_, err := ParseAuthorizationToken(`{"access_token":"ya29.pastedAccess","refresh_token":"1//pastedRefresh","expiry":"2023-01-01"}`)
+	assert.NoError(t, err)
+	InfoLogger.Println("access token ya29.pastedAccess was rejected")
+
+	entries := readLogEntries(t, path)
+	assert.NotEmpty(t, entries)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{
+		"obscuredFtpPass",
+		"nextcloudBearer",
+		"plainHunter2",
+		"ya29.dumpAccess",
+		"1//dumpRefresh",
+		"envFtpPass",
+		"headerBearer",
+		"storedBearerSecret",
+		"ya29.pastedAccess",
+	} {
+		assert.NotContains(t, string(data), secret)
+	}
+	assert.Contains(t, string(data), "host=example.com", "Settings that are not secret should be logged")
+	assert.Contains(t, string(data), redactedValue)
+}
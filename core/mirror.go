@@ -0,0 +1,357 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// GetSecondaryCloudStorage returns the storage that saves are mirrored to
+// after a successful primary sync, or nil if no secondary is configured.
+func GetSecondaryCloudStorage() (Storage, error) {
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return nil, err
+	}
+
+	if cloudperfs.SecondaryCloud == "" {
+		return nil, nil
+	}
+
+	err = validateSecondaryCloud(cloudperfs.Cloud, cloudperfs.SecondaryCloud)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetCloudStorage(cloudperfs.SecondaryCloud)
+}
+
+func GetSecondaryStorageProvider() Storage {
+	storage, err := GetSecondaryCloudStorage()
+	if err != nil {
+		ErrorLogger.Println(err)
+		return nil
+	}
+	return storage
+}
+
+// validateSecondaryCloud checks that primary and secondary can be used
+// together. Remotes from the user's own rclone config live in a different
+// config file than the app's remotes, and rclone copies between remotes of a
+// single config only, see runRemoteToRemote.
+func validateSecondaryCloud(primary string, secondary string) error {
+	if secondary == primary {
+		return fmt.Errorf("secondary cloud must differ from the primary cloud")
+	}
+
+	if primary == EXTERNAL || secondary == EXTERNAL {
+		return fmt.Errorf("an existing rclone remote can not be combined with a secondary cloud")
+	}
+
+	return nil
+}
+
+// UpdateSecondaryCloudProvider sets the secondary cloud. An empty value
+// disables mirroring.
+func UpdateSecondaryCloudProvider(cloud string) error {
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return err
+	}
+
+	if cloud == "" {
+		cloudperfs.SecondaryCloud = ""
+	} else {
+		err = validateSecondaryCloud(cloudperfs.Cloud, cloud)
+		if err != nil {
+			return err
+		}
+
+		_, err = GetCloudStorage(cloud)
+		if err != nil {
+			return err
+		}
+		cloudperfs.SecondaryCloud = cloud
+	}
+
+	return CommitCloudPerfs(cloudperfs)
+}
+
+func (cm *CloudManager) runRemoteToRemote(ctx context.Context, action string, from Storage, to Storage, remotePath string, extraArgs ...string) (string, error) {
+	src := getRemoteLocation(from, remotePath)
+	dst := getRemoteLocation(to, remotePath)
+	// The leases are only meaningful on the cloud devices sync with
+	args := append(extraArgs, "--exclude="+LeaseFilename, action, src, dst)
+
+	// rclone reads a single config, which has to hold both remotes
+	if getRcloneConfigPath(from) != getRcloneConfigPath(to) {
+		return "", fmt.Errorf("%v and %v are kept in different rclone configs and can not be copied between", from.GetName(), to.GetName())
+	}
+
+	cmd := makeStorageCommand(ctx, from, getCloudApp(), args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if err != nil {
+		return stderr.String(), err
+	}
+
+	result := stderr.String()
+	if result == "" {
+		result = stdout.String()
+	}
+
+	return result, nil
+}
+
+// MirrorToSecondary copies remotePath one way from the primary storage to
+// the secondary storage. Nothing is deleted on the secondary.
+func (cm *CloudManager) MirrorToSecondary(ctx context.Context, primary Storage, secondary Storage, remotePath string) (string, error) {
+	InfoLoggerFor(ctx).Println("Mirroring " + remotePath + " to " + secondary.GetName())
+	result, err := cm.runRemoteToRemote(ctx, "copy", primary, secondary, remotePath)
+	if err != nil {
+		return "", errors.New(result)
+	}
+
+	return result, nil
+}
+
+// CompareWithSecondary reports whether every file under remotePath on the
+// primary storage is present and identical on the secondary storage.
+func (cm *CloudManager) CompareWithSecondary(ctx context.Context, primary Storage, secondary Storage, remotePath string) (bool, string, error) {
+	result, err := cm.runRemoteToRemote(ctx, "check", primary, secondary, remotePath, "--one-way")
+	if err != nil {
+		// rclone check exits with 1 when differences were found
+		exiterr, ok := err.(*exec.ExitError)
+		if ok && exiterr.ExitCode() == 1 {
+			return false, result, nil
+		}
+
+		return false, "", errors.New(result)
+	}
+
+	return true, result, nil
+}
+
+// mirroredGame is the cloud folder of a game on the account it syncs to,
+// which is mirrored to the same folder on the secondary storage.
+type mirroredGame struct {
+	Name       string
+	Storage    Storage
+	RemotePath string
+}
+
+// getMirroredGames returns the cloud folders of the games of dm, sorted by
+// game. Games syncing to the secondary storage itself are left out.
+func getMirroredGames(dm GameDefManager, secondary Storage) ([]*mirroredGame, error) {
+	names := []string{}
+	for name := range dm.GetGameDefMap() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	games := []*mirroredGame{}
+	for _, name := range names {
+		gamedef := dm.GetGameDefMap()[name]
+		storage, err := GetGameStorage(gamedef)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		if storage.GetName() == secondary.GetName() {
+			continue
+		}
+
+		remotePath, err := GetGameRemotePath(name, gamedef)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		games = append(games, &mirroredGame{Name: name, Storage: storage, RemotePath: remotePath})
+	}
+
+	return games, nil
+}
+
+// createMirroredStorages creates the remotes of the accounts games sync to.
+func (cm *CloudManager) createMirroredStorages(ctx context.Context, games []*mirroredGame) error {
+	created := make(map[string]bool)
+	for _, game := range games {
+		if created[game.Storage.GetName()] {
+			continue
+		}
+
+		err := cm.CreateDriveIfNotExists(ctx, game.Storage)
+		if err != nil {
+			return err
+		}
+		created[game.Storage.GetName()] = true
+	}
+
+	return nil
+}
+
+// HealSecondary compares the cloud folder of every game of dm with the
+// secondary storage and copies anything that is missing or out of date.
+// Games that could not be healed do not stop the others.
+func (cm *CloudManager) HealSecondary(ctx context.Context, dm GameDefManager, secondary Storage) (string, error) {
+	games, err := getMirroredGames(dm, secondary)
+	if err != nil {
+		return "", err
+	}
+
+	err = cm.createMirroredStorages(ctx, games)
+	if err != nil {
+		return "", err
+	}
+
+	reports := []string{}
+	failed := []string{}
+	for _, game := range games {
+		exists, err := cm.DoesRemoteDirExist(ctx, game.Storage, strings.TrimSuffix(game.RemotePath, "/"))
+		if err == nil && !exists {
+			continue
+		}
+
+		inSync := true
+		report := ""
+		if err == nil {
+			inSync, report, err = cm.CompareWithSecondary(ctx, game.Storage, secondary, game.RemotePath)
+		}
+		if err == nil && !inSync {
+			InfoLogger.Println(report)
+			var result string
+			result, err = cm.MirrorToSecondary(ctx, game.Storage, secondary, game.RemotePath)
+			reports = append(reports, report, result)
+		}
+		if err != nil {
+			ErrorLogger.Println(err)
+			failed = append(failed, game.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return strings.Join(reports, "\n"), fmt.Errorf("the secondary cloud could not be healed for %v", strings.Join(failed, ", "))
+	}
+	if len(reports) == 0 {
+		return "Secondary cloud is in sync", nil
+	}
+
+	return strings.Join(reports, "\n"), nil
+}
+
+// RestoreFromSecondary copies the saves of every game of dm from the
+// secondary storage into the account the game syncs to. This is used to
+// seed a new account after the previous one was lost. Games that could not
+// be restored do not stop the others.
+func (cm *CloudManager) RestoreFromSecondary(ctx context.Context, dm GameDefManager, secondary Storage) (string, error) {
+	games, err := getMirroredGames(dm, secondary)
+	if err != nil {
+		return "", err
+	}
+
+	err = cm.createMirroredStorages(ctx, games)
+	if err != nil {
+		return "", err
+	}
+
+	results := []string{}
+	failed := []string{}
+	for _, game := range games {
+		exists, err := cm.DoesRemoteDirExist(ctx, secondary, strings.TrimSuffix(game.RemotePath, "/"))
+		if err == nil && !exists {
+			continue
+		}
+
+		if err == nil {
+			var result string
+			result, err = cm.RestoreGameFromSecondary(ctx, game.Storage, secondary, game.RemotePath)
+			results = append(results, result)
+		}
+		if err != nil {
+			ErrorLogger.Println(err)
+			failed = append(failed, game.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return strings.Join(results, "\n"), fmt.Errorf("the saves of %v could not be restored from the secondary cloud", strings.Join(failed, ", "))
+	}
+
+	return strings.Join(results, "\n"), nil
+}
+
+// RestoreGameFromSecondary copies the saves of a single game, stored under
+// remotePath, from the secondary storage into the primary storage.
+func (cm *CloudManager) RestoreGameFromSecondary(ctx context.Context, primary Storage, secondary Storage, remotePath string) (string, error) {
+	InfoLoggerFor(ctx).Println("Restoring " + remotePath + " from " + secondary.GetName())
+	result, err := cm.runRemoteToRemote(ctx, "copy", secondary, primary, remotePath)
+	if err != nil {
+		return "", errors.New(result)
+	}
+
+	return result, nil
+}
+
+// ListSecondarySaves returns the cloud folders of the games of dm kept on the
+// secondary storage, which are the saves RestoreFromSecondary can bring
+// back. The folders are named after their games.
+func (cm *CloudManager) ListSecondarySaves(ctx context.Context, dm GameDefManager, secondary Storage) ([]CloudFile, error) {
+	games, err := getMirroredGames(dm, secondary)
+	if err != nil {
+		return nil, err
+	}
+
+	// Games are listed by the folders holding them, mostly the remote root
+	parents := make(map[string][]*mirroredGame)
+	for _, game := range games {
+		parent := path.Dir(strings.TrimSuffix(game.RemotePath, "/")) + "/"
+		parents[parent] = append(parents[parent], game)
+	}
+
+	result := []CloudFile{}
+	for parent, children := range parents {
+		cmd := makeStorageCommand(ctx, secondary, getCloudApp(), "lsjson", "--dirs-only", getRemoteLocation(secondary, parent))
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+
+		stdout, err := cmd.Output()
+		if err != nil {
+			exiterr, ok := err.(*exec.ExitError)
+			if ok && exiterr.ExitCode() == rcloneDirNotFoundExitCode {
+				continue
+			}
+
+			return nil, checkAuthError(secondary, stderr.String())
+		}
+
+		folders := []CloudFile{}
+		err = json.Unmarshal(stdout, &folders)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, folder := range folders {
+			for _, game := range children {
+				if folder.Name == path.Base(strings.TrimSuffix(game.RemotePath, "/")) {
+					folder.Name = game.Name
+					folder.Path = game.RemotePath
+					result = append(result, folder)
+				}
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
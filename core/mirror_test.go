@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondaryCloudRejectsExternalRemote(t *testing.T) {
+	assert.NoError(t, validateSecondaryCloud(GOOGLE, LOCAL))
+	assert.Error(t, validateSecondaryCloud(GOOGLE, GOOGLE))
+	assert.Error(t, validateSecondaryCloud(EXTERNAL, LOCAL))
+	assert.Error(t, validateSecondaryCloud(LOCAL, EXTERNAL))
+}
+
+// setupMirrorGames tracks Hollow, synced to a named account, and Celeste,
+// synced to a folder outside of the remote root. The returned file lists
+// the rclone commands run.
+func setupMirrorGames(t *testing.T) (GameDefManager, Storage, string) {
+	commands := filepath.Join(t.TempDir(), "commands")
+	dm := setupRunGame(t, fmt.Sprintf(`#!/bin/sh
+echo "$*" >> %v
+case "$*" in
+*"check "*)
+	exit 1
+	;;
+*--dirs-only*)
+	echo '[{"Name":"Hollow","ModTime":"2024-01-01T00:00:00Z"},{"Name":"Celeste","ModTime":"2024-01-02T00:00:00Z"}]'
+	;;
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`, commands))
+	useMemorySecretStore(t)
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX, SecondaryCloud: LOCAL}))
+
+	family, err := AddStorageInstance(GOOGLE, "Family")
+	assert.NoError(t, err)
+	dm.GetGameDefMap()["Hollow"].Storage = family.Id
+	datapath := []*Datapath{{Path: t.TempDir()}}
+	dm.GetGameDefMap()["Celeste"] = &GameDef{DisplayName: "Celeste", RemotePath: "/backups/Celeste", WinPath: datapath, LinuxPath: datapath, DarwinPath: datapath}
+
+	secondary, err := GetSecondaryCloudStorage()
+	assert.NoError(t, err)
+	return dm, secondary, commands
+}
+
+func TestSecondaryCloudCoversEveryGame(t *testing.T) {
+	dm, secondary, commands := setupMirrorGames(t)
+	cm := MakeCloudManager()
+	ctx := context.Background()
+
+	_, err := cm.HealSecondary(ctx, dm, secondary)
+	assert.NoError(t, err)
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "copy opencloudsave-google-family:opencloudsaves/Hollow/ opencloudsave-local:opencloudsaves/Hollow/")
+	assert.Contains(t, string(log), "copy opencloudsave-dropbox:backups/Celeste/ opencloudsave-local:backups/Celeste/")
+
+	assert.NoError(t, os.Remove(commands))
+	_, err = cm.RestoreFromSecondary(ctx, dm, secondary)
+	assert.NoError(t, err)
+	log, err = os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "copy opencloudsave-local:opencloudsaves/Hollow/ opencloudsave-google-family:opencloudsaves/Hollow/")
+	assert.Contains(t, string(log), "copy opencloudsave-local:backups/Celeste/ opencloudsave-dropbox:backups/Celeste/")
+
+	saves, err := cm.ListSecondarySaves(ctx, dm, secondary)
+	assert.NoError(t, err)
+	assert.Len(t, saves, 2)
+	assert.Equal(t, "Celeste", saves[0].Name)
+	assert.Equal(t, "backups/Celeste/", saves[0].Path)
+	assert.Equal(t, "Hollow", saves[1].Name)
+	assert.Equal(t, "opencloudsaves/Hollow/", saves[1].Path)
+}
+
+func TestMirrorOncePerGame(t *testing.T) {
+	dm, _, commands := setupMirrorGames(t)
+	gamedef := dm.GetGameDefMap()["Hollow"]
+	gamedef.LinuxPath = append(gamedef.LinuxPath, &Datapath{Path: t.TempDir()})
+	gamedef.WinPath = gamedef.LinuxPath
+	gamedef.DarwinPath = gamedef.LinuxPath
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+	mirrors := 0
+	for _, event := range events {
+		if event.Phase == PhaseMirror {
+			mirrors++
+		}
+	}
+	assert.Equal(t, 1, mirrors)
+
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(log), "copy opencloudsave-google-family:opencloudsaves/Hollow/ opencloudsave-local:opencloudsaves/Hollow/"))
+}
+
+func TestSecondaryCloudReportsListingErrors(t *testing.T) {
+	dm, secondary, _ := setupMirrorGames(t)
+	useFakeRclone(t, `#!/bin/sh
+case "$*" in
+*lsjson*)
+	echo "Failed to lsjson: 100% of requests timed out" >&2
+	exit 1
+	;;
+esac
+`)
+	cm := MakeCloudManager()
+	ctx := context.Background()
+
+	_, err := cm.HealSecondary(ctx, dm, secondary)
+	assert.ErrorContains(t, err, "could not be healed for")
+	assert.ErrorContains(t, err, "Hollow")
+	_, err = cm.RestoreFromSecondary(ctx, dm, secondary)
+	assert.ErrorContains(t, err, "could not be restored")
+	assert.ErrorContains(t, err, "Celeste")
+
+	_, err = cm.DoesRemoteDirExist(ctx, secondary, "opencloudsaves/Hollow")
+	assert.EqualError(t, err, "Failed to lsjson: 100% of requests timed out\n")
+}
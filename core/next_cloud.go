@@ -2,7 +2,9 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
 type NextCloudStorage struct {
@@ -10,14 +12,33 @@ type NextCloudStorage struct {
 	User         string `json:"user"`
 	Pass         string `json:"pass"`
 	Bearer_token string `json:"bearer_token"`
+
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (gs *NextCloudStorage) GetName() string {
+	if gs.Remote != "" {
+		return gs.Remote
+	}
+
 	return "opencloudsave-nextcloud"
 }
 
 func (gs *NextCloudStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
 	args := []string{"config", "create", gs.GetName(), "webdav", "vendor=nextcloud"}
+	args = append(args, gs.getParameters()...)
+	return makeCommand(ctx, getCloudApp(), args...)
+}
+
+func (gs *NextCloudStorage) GetUpdateCommand(ctx context.Context) *exec.Cmd {
+	args := []string{"config", "update", gs.GetName()}
+	args = append(args, gs.getParameters()...)
+	return makeCommand(ctx, getCloudApp(), args...)
+}
+
+func (gs *NextCloudStorage) getParameters() []string {
+	args := []string{}
 	if gs.Url != "" {
 		args = append(args, "url="+gs.Url)
 	}
@@ -31,7 +52,7 @@ func (gs *NextCloudStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
 		args = append(args, "bearer_token="+gs.Bearer_token)
 	}
 
-	return makeCommand(ctx, getCloudApp(), args...)
+	return args
 }
 
 var nextCloudStorage *NextCloudStorage
@@ -48,8 +69,54 @@ func SetNextCloudStorage(ns *NextCloudStorage) {
 
 func GetNextCloudStorage() Storage {
 	if nextCloudStorage == nil {
-		nextCloudStorage = &NextCloudStorage{}
+		settings := GetProviderSettings(NEXT)
+		nextCloudStorage = &NextCloudStorage{
+			Url:  settings["url"],
+			User: settings["user"],
+		}
 	}
 
 	return nextCloudStorage
 }
+
+func configureNextCloudStorage(settings map[string]string) (Storage, error) {
+	nextCloud := &NextCloudStorage{
+		Url:  settings["url"],
+		User: settings["user"],
+	}
+
+	SetNextCloudStorage(nextCloud)
+	return nextCloud, nil
+}
+
+func validateNextCloudSettings(settings map[string]string) error {
+	url := settings["url"]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("nextcloud url %v must start with http:// or https://", url)
+	}
+
+	return nil
+}
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          NEXT,
+		DisplayName: "Next Cloud",
+		Fields: []ProviderField{
+			{Key: "url", Label: "Url", Required: true},
+			{Key: "user", Label: "Username"},
+			{Key: "pass", Label: "Password", Secret: true, Obscure: true},
+			{Key: "bearer_token", Label: "Bearer Token", Secret: true},
+		},
+		Validate:  validateNextCloudSettings,
+		Configure: configureNextCloudStorage,
+		Storage:   GetNextCloudStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &NextCloudStorage{
+				Remote: remote,
+				Url:    settings["url"],
+				User:   settings["user"],
+			}
+		},
+	})
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// OAuthStorage is implemented by storages that sign in through a browser.
+// Their remotes can also be authorized on another machine with
+// `rclone authorize`, for SSH sessions, Steam Deck Game Mode or headless boxes.
+type OAuthStorage interface {
+	Storage
+	GetRcloneType() string
+	GetConfigParameters() map[string]string
+}
+
+// HeadlessAuthorization describes how to authorize a remote from another
+// machine that has a browser.
+type HeadlessAuthorization struct {
+	Instructions string `json:"instructions"`
+	Command      string `json:"command"`
+}
+
+func getConfigParameterArgs(storage OAuthStorage) []string {
+	params := storage.GetConfigParameters()
+	keys := []string{}
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := []string{}
+	for _, key := range keys {
+		args = append(args, key+"="+params[key])
+	}
+
+	return args
+}
+
+func makeOAuthCreationCommand(ctx context.Context, storage OAuthStorage) *exec.Cmd {
+	args := []string{"config", "create", storage.GetName(), storage.GetRcloneType()}
+	args = append(args, getConfigParameterArgs(storage)...)
+	return makeCommand(ctx, getCloudApp(), args...)
+}
+
+// GetHeadlessAuthorization returns the `rclone authorize` command to run on
+// a machine with a browser. The parameters that affect the sign in, like the
+// Google Drive scope, are passed along in rclone's encoded form.
+func GetHeadlessAuthorization(storage Storage) (*HeadlessAuthorization, error) {
+	oauth, ok := storage.(OAuthStorage)
+	if !ok {
+		return nil, fmt.Errorf("cloud storage %v does not sign in through a browser", storage.GetName())
+	}
+
+	command := fmt.Sprintf("rclone authorize %q", oauth.GetRcloneType())
+	params := oauth.GetConfigParameters()
+	if len(params) > 0 {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		command += fmt.Sprintf(" %q", base64.RawStdEncoding.EncodeToString(data))
+	}
+
+	return &HeadlessAuthorization{
+		Instructions: "On a computer with a web browser, install rclone and run the command below. " +
+			"Sign in when the browser opens, then paste everything rclone prints between " +
+			"\"Paste the following into your remote machine --->\" and \"<---End paste\" here.",
+		Command: command,
+	}, nil
+}
+
+// ParseAuthorizationToken extracts the token from the output of
+// `rclone authorize`. The whole output may be pasted, including rclone's
+// markers around the token.
+func ParseAuthorizationToken(input string) (string, error) {
+	start := strings.Index(input, "{")
+	end := strings.LastIndex(input, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("no token found, please paste the output of rclone authorize")
+	}
+
+	var token map[string]interface{}
+	err := json.Unmarshal([]byte(input[start:end+1]), &token)
+	if err != nil {
+		return "", fmt.Errorf("the pasted token is not valid: %v", err)
+	}
+
+	accessToken, _ := token["access_token"].(string)
+	if accessToken == "" {
+		return "", fmt.Errorf("the pasted token has no access token")
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	registerLogSecret(string(data))
+	logRedactor.addNestedSecrets(string(data))
+
+	return string(data), nil
+}
+
+// AuthorizeStorageDrive creates the remote of storage from a token obtained
+// with `rclone authorize`, or updates the token of an existing remote, and
+// checks that the remote can be listed with it.
+func (cm *CloudManager) AuthorizeStorageDrive(ctx context.Context, storage Storage, input string) error {
+	oauth, ok := storage.(OAuthStorage)
+	if !ok {
+		return fmt.Errorf("cloud storage %v does not sign in through a browser", storage.GetName())
+	}
+
+	token, err := ParseAuthorizationToken(input)
+	if err != nil {
+		return err
+	}
+
+	// An existing remote is updated in place so its settings are kept
+	exists := cm.ContainsStorageDrive(ctx, storage)
+	args := []string{"config", "create", storage.GetName(), oauth.GetRcloneType()}
+	args = append(args, getConfigParameterArgs(oauth)...)
+	if exists {
+		args = []string{"config", "update", storage.GetName()}
+	}
+	args = append(args, "token=<redacted>", "config_refresh_token=false", "--non-interactive")
+
+	// The token is swapped in after makeCommand so it is never logged
+	cmd := makeCommand(ctx, getCloudApp(), args...)
+	for i, arg := range cmd.Args {
+		if arg == "token=<redacted>" {
+			cmd.Args[i] = "token=" + token
+		}
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf(stderr.String())
+	}
+
+	err = cm.verifyStorageDrive(ctx, storage)
+	if err != nil {
+		if !exists {
+			cm.DeleteStorageDrive(ctx, storage)
+		}
+		return fmt.Errorf("the token was rejected by %v: %v", storage.GetName(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAuthorizationToken(t *testing.T) {
+	output := `Paste the following into your remote machine --->
+{"access_token":"ya29.abc","token_type":"Bearer","refresh_token":"1//xyz","expiry":"2026-10-19T12:00:00Z"}
+<---End paste`
+
+	token, err := ParseAuthorizationToken(output)
+	assert.NoError(t, err)
+	assert.Contains(t, token, `"access_token":"ya29.abc"`)
+	assert.NotContains(t, token, "Paste", "rclone's markers should be stripped")
+
+	_, err = ParseAuthorizationToken("not a token")
+	assert.Error(t, err)
+
+	_, err = ParseAuthorizationToken(`{"token_type":"Bearer"}`)
+	assert.Error(t, err, "A token without an access token should be rejected")
+}
+
+func TestGetHeadlessAuthorization(t *testing.T) {
+	authorization, err := GetHeadlessAuthorization(&GoogleStorage{})
+	assert.NoError(t, err)
+	// base64 of {"scope":"drive.file"}
+	assert.Equal(t, `rclone authorize "drive" "eyJzY29wZSI6ImRyaXZlLmZpbGUifQ"`, authorization.Command)
+
+	authorization, err = GetHeadlessAuthorization(&DropBoxStorage{})
+	assert.NoError(t, err)
+	assert.Equal(t, `rclone authorize "dropbox"`, authorization.Command)
+
+	_, err = GetHeadlessAuthorization(&FtpStorage{})
+	assert.Error(t, err, "Storages without OAuth can not be authorized remotely")
+}
@@ -6,14 +6,31 @@ import (
 )
 
 type OneDriveStorage struct {
+	// Remote is set for named accounts, which each have their own remote
+	Remote string `json:"-"`
 }
 
 func (gs *OneDriveStorage) GetName() string {
+	if gs.Remote != "" {
+		return gs.Remote
+	}
+
 	return "opencloudsave-onedrive"
 }
 
 func (gs *OneDriveStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
-	return makeCommand(ctx, getCloudApp(), "config", "create", gs.GetName(), "onedrive", "drive_type=personal", "access_scopes=Files.ReadWrite,offline_access")
+	return makeOAuthCreationCommand(ctx, gs)
+}
+
+func (gs *OneDriveStorage) GetRcloneType() string {
+	return "onedrive"
+}
+
+func (gs *OneDriveStorage) GetConfigParameters() map[string]string {
+	return map[string]string{
+		"drive_type":    "personal",
+		"access_scopes": "Files.ReadWrite,offline_access",
+	}
 }
 
 var onedrive *OneDriveStorage
@@ -25,3 +42,15 @@ func GetOneDriveStorage() Storage {
 
 	return onedrive
 }
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          ONEDRIVE,
+		DisplayName: "One Drive",
+		OAuth:       true,
+		Storage:     GetOneDriveStorage,
+		NewStorage: func(remote string, settings map[string]string) Storage {
+			return &OneDriveStorage{Remote: remote}
+		},
+	})
+}
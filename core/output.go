@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OutputSchemaVersion is the version of the JSON written by --output json.
+// It is increased whenever a field is removed or changes its meaning, new
+// fields may be added without increasing it. See docs/cli-json.md.
+const OutputSchemaVersion = 1
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// The phases of a game's sync, in the order they happen.
+const (
+	PhaseStart  = "start"
+	PhasePaths  = "paths"
+	PhaseSync   = "sync"
+	PhaseMirror = "mirror"
+	PhaseDone   = "done"
+	PhaseError  = "error"
+)
+
+// The kinds of errors tools can react to without parsing messages.
+const (
+	ErrorKindNoCloud     = "no_cloud"
+	ErrorKindUnknownGame = "unknown_game"
+	ErrorKindStorage     = "storage"
+	ErrorKindPaths       = "paths"
+	ErrorKindNeedsReauth = "needs_reauth"
+	ErrorKindVaultLocked = "vault_locked"
+	ErrorKindSync        = "sync"
+	ErrorKindMirror      = "mirror"
+	ErrorKindLocked      = "locked"
+	ErrorKindLeased      = "leased"
+	ErrorKindHook        = "hook"
+	ErrorKindUsage       = "usage"
+	ErrorKindUnknown     = "unknown"
+)
+
+var ErrNoCloud = errors.New("no cloud provider set")
+
+// ProgressEvent is a line of the progress written while syncing.
+type ProgressEvent struct {
+	Schema    int    `json:"schema"`
+	Type      string `json:"type"`
+	SyncId    string `json:"sync,omitempty"`
+	Game      string `json:"game,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Phase     string `json:"phase"`
+	Bytes     int64  `json:"bytes"`
+	Files     int    `json:"files"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"errorKind,omitempty"`
+}
+
+// CommandResult is the document written when a command finishes.
+type CommandResult struct {
+	Schema    int         `json:"schema"`
+	Type      string      `json:"type"`
+	Command   string      `json:"command"`
+	Ok        bool        `json:"ok"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	ErrorKind string      `json:"errorKind,omitempty"`
+}
+
+func NewProgressEvent(game string, phase string) *ProgressEvent {
+	return &ProgressEvent{
+		Schema: OutputSchemaVersion,
+		Type:   "progress",
+		Game:   game,
+		Phase:  phase,
+	}
+}
+
+func NewCommandResult(command string, data interface{}, err error) *CommandResult {
+	result := &CommandResult{
+		Schema:  OutputSchemaVersion,
+		Type:    "result",
+		Command: command,
+		Ok:      err == nil,
+		Data:    data,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorKind = GetErrorKind(err)
+	}
+
+	return result
+}
+
+// kindError tags an error with its kind, keeping the message unchanged.
+type kindError struct {
+	kind string
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// WithErrorKind tags err with kind, unless it already carries one.
+func WithErrorKind(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var tagged *kindError
+	if errors.As(err, &tagged) {
+		return err
+	}
+
+	return &kindError{kind: kind, err: err}
+}
+
+// GetErrorKind returns the kind err was tagged with, or a kind guessed from
+// the error itself.
+func GetErrorKind(err error) string {
+	// An expired sign in is what tools most need to react to, whatever
+	// step it happened in
+	if isNeedsReauthError(err) {
+		return ErrorKindNeedsReauth
+	}
+
+	var tagged *kindError
+	if errors.As(err, &tagged) {
+		return tagged.kind
+	}
+
+	if errors.Is(err, ErrNoCloud) {
+		return ErrorKindNoCloud
+	}
+
+	return ErrorKindUnknown
+}
+
+// rclone ends a sync with its stats, e.g.
+//
+//	Transferred:   	    1.234 KiB / 1.234 KiB, 100%, 0 B/s, ETA -
+//	Transferred:            2 / 2, 100%
+//
+// The first line counts bytes, the second files.
+var rcloneFilesMatcher = regexp.MustCompile(`(?m)Transferred:\s+(\d+)\s*/\s*\d+,\s*[\d.-]+%\s*$`)
+var rcloneBytesMatcher = regexp.MustCompile(`(?m)Transferred:\s+([\d.]+)\s*([A-Za-z]*)\s*/\s*[\d.]+\s*[A-Za-z]+,`)
+
+var byteUnits = map[string]float64{
+	"b":  1,
+	"k":  1 << 10,
+	"ki": 1 << 10,
+	"m":  1 << 20,
+	"mi": 1 << 20,
+	"g":  1 << 30,
+	"gi": 1 << 30,
+	"t":  1 << 40,
+	"ti": 1 << 40,
+}
+
+// parseRcloneStats sums up the bytes and files transferred in the stats
+// rclone printed. Older rclone versions print units like 1.2k or kBytes.
+func parseRcloneStats(output string) (int64, int) {
+	var bytes int64
+	for _, match := range rcloneBytesMatcher.FindAllStringSubmatch(output, -1) {
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+
+		unit := strings.ToLower(match[2])
+		unit = strings.TrimSuffix(strings.TrimSuffix(unit, "bytes"), "b")
+		if unit == "" {
+			unit = "b"
+		}
+
+		multiplier, ok := byteUnits[unit]
+		if ok {
+			bytes += int64(value * multiplier)
+		}
+	}
+
+	files := 0
+	for _, match := range rcloneFilesMatcher.FindAllStringSubmatch(output, -1) {
+		count, err := strconv.Atoi(match[1])
+		if err == nil {
+			files += count
+		}
+	}
+
+	return bytes, files
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Prints stats only when asked to, like rclone does without -v
+const fakeSyncRclone = `#!/bin/sh
+case "$*" in
+*broken*)
+	echo "Failed to sync: directory not found" >&2
+	exit 1
+	;;
+*lsjson*)
+	echo "[]"
+	;;
+*--stats-log-level=NOTICE*)
+	cat >&2 <<'END'
+2023/05/01 10:00:00 NOTICE:
+Transferred:   	    1.500 KiB / 1.500 KiB, 100%, 0 B/s, ETA -
+Transferred:            2 / 2, 100%
+Elapsed time:         0.1s
+END
+	;;
+esac
+`
+
+func TestParseRcloneStats(t *testing.T) {
+	bytes, files := parseRcloneStats(`Transferred:   	    1.500 KiB / 1.500 KiB, 100%, 0 B/s, ETA -
+Transferred:            2 / 3, 66%
+Checks:                 1 / 1, 100%
+Elapsed time:         0.1s`)
+	assert.Equal(t, int64(1536), bytes)
+	assert.Equal(t, 2, files)
+
+	bytes, files = parseRcloneStats("Transferred:   	        0 B / 0 B, -, 0 B/s, ETA -\nTransferred:            0 / 0, -")
+	assert.Equal(t, int64(0), bytes)
+	assert.Equal(t, 0, files)
+
+	bytes, files = parseRcloneStats("Transferred:   	    2.000M / 2.000 MBytes, 100%, 1.000 MBytes/s, ETA 0s\nTransferred:            1 / 1, 100%")
+	assert.Equal(t, int64(2<<20), bytes, "Older rclone versions use different units")
+	assert.Equal(t, 1, files)
+
+	bytes, files = parseRcloneStats("nothing to transfer")
+	assert.Equal(t, int64(0), bytes)
+	assert.Equal(t, 0, files)
+}
+
+func TestGetErrorKind(t *testing.T) {
+	assert.Equal(t, ErrorKindUnknown, GetErrorKind(fmt.Errorf("failed")))
+	assert.Equal(t, ErrorKindNoCloud, GetErrorKind(ErrNoCloud))
+	assert.Equal(t, ErrorKindPaths, GetErrorKind(WithErrorKind(ErrorKindPaths, fmt.Errorf("failed"))))
+	assert.Equal(t, ErrorKindPaths, GetErrorKind(WithErrorKind(ErrorKindSync, WithErrorKind(ErrorKindPaths, fmt.Errorf("failed")))), "The first kind should be kept")
+	assert.Equal(t, ErrorKindNeedsReauth, GetErrorKind(WithErrorKind(ErrorKindSync, &NeedsReauthError{Storage: DROPBOX})))
+	assert.Nil(t, WithErrorKind(ErrorKindSync, nil))
+
+	result := NewCommandResult("sync", nil, WithErrorKind(ErrorKindUnknownGame, fmt.Errorf("unknown game")))
+	data, err := json.Marshal(result)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"schema":1,"type":"result","command":"sync","ok":false,"error":"unknown game","errorKind":"unknown_game"}`, string(data))
+}
+
+func TestRequestMainOperationEvents(t *testing.T) {
+	good := t.TempDir()
+	dm := setupRunGame(t, fakeSyncRclone, testGameDef("Good", good), testGameDef("Broken", filepath.Join(t.TempDir(), "broken")))
+
+	ops := &Options{Gamenames: []string{"Good", "Broken", "Missing"}, Output: OutputJSON}
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RequestMainOperation(context.Background(), MakeCloudManager(), ops, dm, channels)
+		close(channels.Logs)
+	}()
+
+	events := make(map[string][]*ProgressEvent)
+	for msg := range channels.Logs {
+		if msg.Event != nil {
+			assert.Equal(t, OutputSchemaVersion, msg.Event.Schema)
+			assert.NotEmpty(t, msg.Event.SyncId)
+			events[msg.Event.Game] = append(events[msg.Event.Game], msg.Event)
+		}
+	}
+
+	phases := func(game string) []string {
+		result := []string{}
+		for _, event := range events[game] {
+			result = append(result, event.Phase)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{PhaseStart, PhasePaths, PhaseSync, PhaseDone}, phases("Good"))
+	done := events["Good"][3]
+	assert.Equal(t, int64(3072), done.Bytes, "Stats of the copy and the sync should be added up")
+	assert.Equal(t, 4, done.Files)
+	assert.Equal(t, good, filepath.Clean(done.Path))
+
+	assert.Equal(t, []string{PhaseStart, PhasePaths, PhaseSync, PhaseError}, phases("Broken"))
+	assert.Equal(t, ErrorKindSync, events["Broken"][3].ErrorKind)
+	assert.Contains(t, events["Broken"][3].Error, "directory not found")
+
+	assert.Equal(t, []string{PhaseStart, PhaseError}, phases("Missing"))
+	assert.Equal(t, ErrorKindUnknownGame, events["Missing"][1].ErrorKind)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// PlannedChange is a change rclone would make to a file, as reported by a
+// dry run.
+type PlannedChange struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+}
+
+// rclone logs every change it skips in a dry run, e.g.
+//
+//	2023/05/01 10:00:00 NOTICE: saves/slot1.sav: Skipped copy as --dry-run is set (size 1.2Ki)
+var dryRunMatcher = regexp.MustCompile(`(?m)NOTICE:\s*(.+?):\s*Skipped (.+?) as --dry-run is set`)
+
+// ParsePlannedChanges returns the changes listed in the output of a dry run.
+func ParsePlannedChanges(output string) []PlannedChange {
+	changes := []PlannedChange{}
+	seen := make(map[PlannedChange]bool)
+	for _, match := range dryRunMatcher.FindAllStringSubmatch(output, -1) {
+		change := PlannedChange{
+			Action: strings.TrimSpace(match[2]),
+			Path:   strings.TrimSpace(match[1]),
+		}
+
+		// Syncs run a copy and a sync, which may both plan the same change
+		if seen[change] {
+			continue
+		}
+		seen[change] = true
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// PlanSync dry runs the sync of gamename and returns the changes it would
+// make.
+func PlanSync(ctx context.Context, cm *CloudManager, dm GameDefManager, gamename string) ([]PlannedChange, error) {
+	ops := &Options{
+		Gamenames: []string{gamename},
+		DryRun:    []bool{true},
+	}
+
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RequestMainOperation(ctx, cm, ops, dm, channels)
+		close(channels.Logs)
+	}()
+
+	var output strings.Builder
+	var err error
+	for msg := range channels.Logs {
+		if msg.Err != nil && err == nil {
+			err = msg.Err
+		}
+
+		if msg.Event != nil && msg.Event.Phase == PhaseDone {
+			output.WriteString(msg.Message)
+			output.WriteString("\n")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePlannedChanges(output.String()), nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeDryRunRclone = `#!/bin/sh
+case "$*" in
+*lsjson*)
+	echo "[]"
+	;;
+*--dry-run*copy*)
+	echo "2023/05/01 10:00:00 NOTICE: slot1.sav: Skipped copy as --dry-run is set (size 1.2Ki)" >&2
+	;;
+*--dry-run*sync*)
+	cat >&2 <<'END'
+2023/05/01 10:00:00 NOTICE: slot1.sav: Skipped copy as --dry-run is set (size 1.2Ki)
+2023/05/01 10:00:00 NOTICE: old/slot0.sav: Skipped delete as --dry-run is set (size 900)
+END
+	;;
+*)
+	echo "synced without --dry-run" >&2
+	exit 1
+	;;
+esac
+`
+
+func TestParsePlannedChanges(t *testing.T) {
+	changes := ParsePlannedChanges(`2023/05/01 10:00:00 NOTICE: saves/slot 1.sav: Skipped copy as --dry-run is set (size 1.2Ki)
+2023/05/01 10:00:00 NOTICE: saves/old.sav: Skipped delete as --dry-run is set (size 12)
+2023/05/01 10:00:00 NOTICE: saves/slot 1.sav: Skipped copy as --dry-run is set (size 1.2Ki)
+2023/05/01 10:00:00 NOTICE: saves/config.ini: Skipped update modification time as --dry-run is set
+Transferred:   	    1.200 KiB / 1.200 KiB, 100%, 0 B/s, ETA -`)
+
+	assert.Equal(t, []PlannedChange{
+		{Action: "copy", Path: "saves/slot 1.sav"},
+		{Action: "delete", Path: "saves/old.sav"},
+		{Action: "update modification time", Path: "saves/config.ini"},
+	}, changes)
+
+	assert.Empty(t, ParsePlannedChanges("There was nothing to transfer"))
+}
+
+func TestPlanSync(t *testing.T) {
+	dm := setupRunGame(t, fakeDryRunRclone, testGameDef("Celeste", t.TempDir()))
+
+	changes, err := PlanSync(context.Background(), MakeCloudManager(), dm, "Celeste")
+	assert.NoError(t, err, "Only dry runs should be run")
+	assert.Equal(t, []PlannedChange{
+		{Action: "copy", Path: "slot1.sav"},
+		{Action: "delete", Path: "old/slot0.sav"},
+	}, changes)
+
+	_, err = PlanSync(context.Background(), MakeCloudManager(), dm, "Missing")
+	assert.Error(t, err)
+}
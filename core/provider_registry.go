@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProviderField describes one setting a storage provider needs from the user.
+type ProviderField struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Secret    bool   `json:"secret"`
+	Required  bool   `json:"required"`
+	Directory bool   `json:"directory"`
+	Default   string `json:"default"`
+	// Obscure marks secrets that rclone expects in its obscured form
+	Obscure bool `json:"-"`
+}
+
+// StorageProvider is a registry entry for a cloud backend. The CLI, the GUI
+// cloud picker and CloudPerfs all refer to providers by Id.
+type StorageProvider struct {
+	Id          string          `json:"id"`
+	DisplayName string          `json:"displayName"`
+	Fields      []ProviderField `json:"fields"`
+	// OAuth providers sign in through a browser, see HeadlessAuthorization
+	OAuth bool `json:"oauth"`
+
+	// Validate checks settings after required fields and defaults have been
+	// applied. It may be nil.
+	Validate func(settings map[string]string) error `json:"-"`
+	// Configure builds the provider's storage from validated settings and
+	// makes it the active storage for this provider. It may be nil for
+	// providers without fields.
+	Configure func(settings map[string]string) (Storage, error) `json:"-"`
+	// Storage returns the active storage for this provider. The storage's
+	// creation command creates the rclone remote.
+	Storage func() Storage `json:"-"`
+	// NewStorage builds the storage of an additional named account that uses
+	// the given rclone remote. Providers without it only support one account.
+	NewStorage func(remote string, settings map[string]string) Storage `json:"-"`
+}
+
+var providerRegistryMtx sync.Mutex
+var providerRegistry []*StorageProvider
+
+// Numeric cloud ids written by versions before the provider registry existed.
+var legacyCloudIds = []string{GOOGLE, ONEDRIVE, DROPBOX, BOX, NEXT, FTP, LOCAL}
+
+func RegisterStorageProvider(provider *StorageProvider) {
+	providerRegistryMtx.Lock()
+	defer providerRegistryMtx.Unlock()
+
+	for _, existing := range providerRegistry {
+		if existing.Id == provider.Id {
+			panic("storage provider registered twice: " + provider.Id)
+		}
+	}
+
+	for _, field := range provider.Fields {
+		if field.Secret {
+			registerSecretKey(field.Key)
+		}
+	}
+
+	providerRegistry = append(providerRegistry, provider)
+}
+
+func GetStorageProviders() []*StorageProvider {
+	providerRegistryMtx.Lock()
+	defer providerRegistryMtx.Unlock()
+
+	result := make([]*StorageProvider, len(providerRegistry))
+	copy(result, providerRegistry)
+	return result
+}
+
+// GetStorageProvider returns the provider for a provider id, or for the id
+// of a named account.
+func GetStorageProvider(id string) (*StorageProvider, error) {
+	instance := findStorageInstance(getPersistedStorageInstances(), id)
+	if instance != nil {
+		return instance.getProvider()
+	}
+
+	return getRegisteredStorageProvider(id)
+}
+
+// ResolveStorageProviderId accepts a provider id, or one of the numeric ids
+// used by older versions, and returns the provider id.
+func ResolveStorageProviderId(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	index, err := strconv.Atoi(value)
+	if err == nil {
+		if index < 0 || index >= len(legacyCloudIds) {
+			return "", fmt.Errorf("unknown cloud provider %v", value)
+		}
+		return legacyCloudIds[index], nil
+	}
+
+	provider, err := GetStorageProvider(value)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.Id, nil
+}
+
+// ValidateSettings applies defaults, checks required fields and runs the
+// provider's own validation. The returned map only contains known fields.
+func (p *StorageProvider) ValidateSettings(settings map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, field := range p.Fields {
+		value := strings.TrimSpace(settings[field.Key])
+		if value == "" {
+			value = field.Default
+		}
+
+		if value == "" && field.Required {
+			return nil, fmt.Errorf("%v requires a value for %v", p.DisplayName, field.Label)
+		}
+
+		if value != "" {
+			result[field.Key] = value
+		}
+	}
+
+	for key := range settings {
+		if p.GetField(key) == nil {
+			return nil, fmt.Errorf("%v has no setting named %v", p.DisplayName, key)
+		}
+	}
+
+	if p.Validate != nil {
+		err := p.Validate(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (p *StorageProvider) GetField(key string) *ProviderField {
+	for i := range p.Fields {
+		if p.Fields[i].Key == key {
+			return &p.Fields[i]
+		}
+	}
+
+	return nil
+}
+
+// ApplySettings validates settings and configures the provider with them.
+func (p *StorageProvider) ApplySettings(settings map[string]string) (Storage, error) {
+	validated, err := p.ValidateSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Configure == nil {
+		return p.Storage(), nil
+	}
+
+	err = p.storeSecrets(validated)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := p.Configure(p.withoutSecrets(validated))
+	if err != nil {
+		return nil, err
+	}
+
+	err = commitProviderSettings(p, validated)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// storeSecrets moves the secret fields of settings into the secret store.
+// They are handed to rclone through its environment when it runs.
+func (p *StorageProvider) storeSecrets(settings map[string]string) error {
+	storage := p.Storage()
+	for _, field := range p.Fields {
+		value, ok := settings[field.Key]
+		if !field.Secret || !ok {
+			continue
+		}
+
+		if field.Obscure {
+			cm := MakeCloudManager()
+			obscured, err := cm.ObscurePassword(context.Background(), value)
+			if err != nil {
+				return err
+			}
+			value = obscured
+		}
+
+		err := SetStorageSecret(storage, field.Key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *StorageProvider) withoutSecrets(settings map[string]string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range settings {
+		field := p.GetField(key)
+		if field != nil && !field.Secret {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// parseCloudId reads a cloud id from perfs JSON, accepting both the current
+// string ids and legacy numeric ids.
+func parseCloudId(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var id string
+	err := json.Unmarshal(raw, &id)
+	if err == nil {
+		return id, nil
+	}
+
+	var index int
+	err = json.Unmarshal(raw, &index)
+	if err != nil {
+		return "", err
+	}
+
+	return ResolveStorageProviderId(strconv.Itoa(index))
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveStorageProviderId(t *testing.T) {
+	id, err := ResolveStorageProviderId("google")
+	assert.NoError(t, err)
+	assert.Equal(t, GOOGLE, id)
+
+	id, err = ResolveStorageProviderId(" FTP ")
+	assert.NoError(t, err)
+	assert.Equal(t, FTP, id)
+
+	for index, expected := range []string{GOOGLE, ONEDRIVE, DROPBOX, BOX, NEXT, FTP, LOCAL} {
+		id, err = ResolveStorageProviderId(string(rune('0' + index)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, id, "Legacy numeric ids should map to provider ids")
+	}
+
+	_, err = ResolveStorageProviderId("7")
+	assert.Error(t, err)
+
+	_, err = ResolveStorageProviderId("not-a-cloud")
+	assert.Error(t, err)
+}
+
+func TestStorageProviderValidateSettings(t *testing.T) {
+	provider, err := GetStorageProvider(FTP)
+	assert.NoError(t, err)
+
+	_, err = provider.ValidateSettings(map[string]string{"user": "player"})
+	assert.Error(t, err, "Missing required fields should be rejected")
+
+	_, err = provider.ValidateSettings(map[string]string{"host": "127.0.0.1", "hostname": "x"})
+	assert.Error(t, err, "Unknown fields should be rejected")
+
+	settings, err := provider.ValidateSettings(map[string]string{"host": " 127.0.0.1 ", "user": "player"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "127.0.0.1", "port": "21", "user": "player"}, settings)
+
+	provider, err = GetStorageProvider(NEXT)
+	assert.NoError(t, err)
+
+	_, err = provider.ValidateSettings(map[string]string{"url": "ftp://example.com"})
+	assert.Error(t, err, "Provider validation should run")
+}
+
+func TestCloudPerfsLegacyMigration(t *testing.T) {
+	cloudperfs := &CloudPerfs{}
+	err := json.Unmarshal([]byte(`{"cloud":4,"performDryRun":true,"secondaryCloud":6}`), cloudperfs)
+	assert.NoError(t, err)
+	assert.Equal(t, NEXT, cloudperfs.Cloud)
+	assert.Equal(t, LOCAL, cloudperfs.SecondaryCloud)
+	assert.True(t, cloudperfs.PerformDryRun)
+	assert.True(t, cloudperfs.migrated)
+
+	cloudperfs = &CloudPerfs{}
+	err = json.Unmarshal([]byte(`{"cloud":"dropbox","useBiSync":true}`), cloudperfs)
+	assert.NoError(t, err)
+	assert.Equal(t, DROPBOX, cloudperfs.Cloud)
+	assert.Equal(t, "", cloudperfs.SecondaryCloud)
+	assert.True(t, cloudperfs.UseBiSync)
+	assert.False(t, cloudperfs.migrated)
+
+	data, err := json.Marshal(cloudperfs)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"cloud":"dropbox"`)
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const ProviderSettingsFilename = "provider_settings.json"
+
+var providerSettingsMtx sync.Mutex
+
+func getProviderSettingsPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + ProviderSettingsFilename, nil
+}
+
+func readAllProviderSettings() (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	path, err := getProviderSettingsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetProviderSettings returns the persisted, non-secret settings for a
+// provider. Secrets are kept in the SecretStore instead.
+func GetProviderSettings(id string) map[string]string {
+	providerSettingsMtx.Lock()
+	defer providerSettingsMtx.Unlock()
+
+	all, err := readAllProviderSettings()
+	if err != nil {
+		if ErrorLogger != nil {
+			ErrorLogger.Println(err)
+		}
+		return map[string]string{}
+	}
+
+	settings, ok := all[id]
+	if !ok {
+		return map[string]string{}
+	}
+
+	return settings
+}
+
+func commitProviderSettings(provider *StorageProvider, settings map[string]string) error {
+	providerSettingsMtx.Lock()
+	defer providerSettingsMtx.Unlock()
+
+	all, err := readAllProviderSettings()
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[string]string)
+	for key, value := range settings {
+		field := provider.GetField(key)
+		if field == nil || field.Secret {
+			continue
+		}
+		stored[key] = value
+	}
+	all[provider.Id] = stored
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getProviderSettingsPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func deleteProviderSettings(id string) error {
+	providerSettingsMtx.Lock()
+	defer providerSettingsMtx.Unlock()
+
+	all, err := readAllProviderSettings()
+	if err != nil {
+		return err
+	}
+
+	_, ok := all[id]
+	if !ok {
+		return nil
+	}
+	delete(all, id)
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	path, err := getProviderSettingsPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// UpdateSettings edits the provider's existing rclone remote in place. Fields
+// left empty keep their current value, so secrets do not need to be entered
+// again to change a host or user name.
+func (p *StorageProvider) UpdateSettings(ctx context.Context, cm *CloudManager, settings map[string]string) (Storage, error) {
+	merged := GetProviderSettings(p.Id)
+	for key, value := range settings {
+		if value != "" {
+			merged[key] = value
+		}
+	}
+
+	validated, err := p.ValidateSettings(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Configure == nil {
+		return p.Storage(), nil
+	}
+
+	err = p.storeSecrets(validated)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := p.Configure(p.withoutSecrets(validated))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.UpdateStorageDrive(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = commitProviderSettings(p, validated)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// ConfigureStorageProvider applies settings to a provider. If the provider's
+// remote already exists it is updated in place, otherwise the settings are
+// used when the remote is next created.
+func ConfigureStorageProvider(ctx context.Context, cm *CloudManager, id string, settings map[string]string) (Storage, error) {
+	provider, err := GetStorageProvider(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if cm.ContainsStorageDrive(ctx, provider.Storage()) {
+		return provider.UpdateSettings(ctx, cm, settings)
+	}
+
+	return provider.ApplySettings(settings)
+}
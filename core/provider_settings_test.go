@@ -0,0 +1,35 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitProviderSettingsOmitsSecrets(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	provider, err := GetStorageProvider(NEXT)
+	assert.NoError(t, err)
+
+	err = commitProviderSettings(provider, map[string]string{
+		"url":          "https://cloud.example.com",
+		"user":         "player",
+		"pass":         "hunter2",
+		"bearer_token": "token",
+	})
+	assert.NoError(t, err, "Committing provider settings should not return an error")
+
+	settings := GetProviderSettings(NEXT)
+	assert.Equal(t, map[string]string{"url": "https://cloud.example.com", "user": "player"}, settings)
+
+	path, err := getProviderSettingsPath()
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2", "Secrets should never be written to the settings file")
+	assert.NotContains(t, string(data), "token")
+
+	assert.Equal(t, map[string]string{}, GetProviderSettings(FTP), "Providers without settings should be empty")
+}
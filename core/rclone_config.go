@@ -0,0 +1,253 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const RcloneConfigFilename = "rclone.conf"
+
+// Written once the remotes were moved out of the user's rclone config, or
+// moving them failed, see MigrateRcloneConfig
+const rcloneMigrationFilename = "rclone_migrated"
+const remoteNamePrefix = "opencloudsave-"
+
+// GetAppRcloneConfigPath returns the rclone config owned by OpenCloudSave. It
+// is kept apart from the user's own rclone config.
+func GetAppRcloneConfigPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + RcloneConfigFilename, nil
+}
+
+var userRcloneConfigPath string
+
+// getUserRcloneConfigPath asks rclone where the user's own config lives.
+func getUserRcloneConfigPath(ctx context.Context) (string, error) {
+	if userRcloneConfigPath != "" {
+		return userRcloneConfigPath, nil
+	}
+
+	cmd := exec.CommandContext(ctx, getCloudApp(), "config", "file")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf(stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	userRcloneConfigPath = strings.TrimSpace(lines[len(lines)-1])
+	return userRcloneConfigPath, nil
+}
+
+// getRcloneConfigPath returns the config holding the remote of storage. This
+// is the app's own config, unless storage reuses a remote from the user's
+// personal rclone config. A nil storage gets the app's config.
+func getRcloneConfigPath(storage Storage) string {
+	if storage != nil && isExternalStorage(storage) {
+		path, err := getUserRcloneConfigPath(context.Background())
+		if err == nil {
+			return path
+		}
+		if ErrorLogger != nil {
+			ErrorLogger.Println(err)
+		}
+	}
+
+	path, err := GetAppRcloneConfigPath()
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func writeRcloneConfigSection(b *strings.Builder, name string, section map[string]interface{}) {
+	keys := []string{}
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(b, "[%v]\n", name)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%v = %v\n", key, section[key])
+	}
+	b.WriteString("\n")
+}
+
+// MigrateRcloneConfig moves opencloudsave-* remotes out of the user's rclone
+// config into the app's own config. It only runs once, before the app config
+// exists. A failed attempt is only logged, and not retried on every launch.
+func MigrateRcloneConfig(ctx context.Context) error {
+	appConfig, err := GetAppRcloneConfigPath()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(appConfig)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	marker := filepath.Join(filepath.Dir(appConfig), rcloneMigrationFilename)
+	_, err = os.Stat(marker)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(appConfig), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(marker, nil, 0644)
+	if err != nil {
+		return err
+	}
+
+	userConfig, err := getUserRcloneConfigPath(ctx)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, getCloudApp(), "--config", userConfig, "config", "dump")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf(stderr.String())
+	}
+
+	var sections map[string]map[string]interface{}
+	err = json.Unmarshal(stdout, &sections)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range sections {
+		if strings.HasPrefix(name, remoteNamePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		writeRcloneConfigSection(&b, name, sections[name])
+	}
+
+	err = os.WriteFile(appConfig, []byte(b.String()), 0600)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		InfoLogger.Println("Migrated rclone remote " + name + " out of " + userConfig)
+		cmd := exec.CommandContext(ctx, getCloudApp(), "--config", userConfig, "config", "delete", name)
+		err = cmd.Run()
+		if err != nil {
+			ErrorLogger.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// ExternalRemoteStorage reuses a remote from the user's own rclone config.
+// OpenCloudSave never creates, edits or deletes this remote.
+type ExternalRemoteStorage struct {
+	Remote     string `json:"remote"`
+	PathPrefix string `json:"prefix"`
+}
+
+func (es *ExternalRemoteStorage) GetName() string {
+	return es.Remote
+}
+
+func (es *ExternalRemoteStorage) GetPathPrefix() string {
+	return es.PathPrefix
+}
+
+func (es *ExternalRemoteStorage) IsExternal() bool {
+	return true
+}
+
+// The remote must already exist, so "creating" it just reports whether it
+// can be found.
+func (es *ExternalRemoteStorage) GetCreationCommand(ctx context.Context) *exec.Cmd {
+	return makeStorageCommand(ctx, es, getCloudApp(), "config", "show", es.Remote)
+}
+
+var externalRemoteStorage *ExternalRemoteStorage
+
+func GetExternalRemoteStorage() Storage {
+	if externalRemoteStorage == nil {
+		settings := GetProviderSettings(EXTERNAL)
+		externalRemoteStorage = &ExternalRemoteStorage{
+			Remote:     settings["remote"],
+			PathPrefix: settings["prefix"],
+		}
+	}
+
+	return externalRemoteStorage
+}
+
+func validateExternalRemoteSettings(settings map[string]string) error {
+	remote := strings.TrimSuffix(settings["remote"], ":")
+	if strings.HasPrefix(remote, remoteNamePrefix) {
+		return fmt.Errorf("remote %v is managed by OpenCloudSave, please pick one of your own remotes", remote)
+	}
+
+	ctx := context.Background()
+	userConfig, err := getUserRcloneConfigPath(ctx)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, getCloudApp(), "--config", userConfig, "listremotes")
+	stdout, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(stdout), "\n") {
+		if strings.TrimSuffix(strings.TrimSpace(line), ":") == remote {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("remote %v was not found in %v", remote, userConfig)
+}
+
+func init() {
+	RegisterStorageProvider(&StorageProvider{
+		Id:          EXTERNAL,
+		DisplayName: "Existing rclone Remote",
+		Fields: []ProviderField{
+			{Key: "remote", Label: "Remote Name", Required: true},
+			{Key: "prefix", Label: "Path Prefix"},
+		},
+		Validate: validateExternalRemoteSettings,
+		Configure: func(settings map[string]string) (Storage, error) {
+			externalRemoteStorage = &ExternalRemoteStorage{
+				Remote:     strings.TrimSuffix(settings["remote"], ":"),
+				PathPrefix: settings["prefix"],
+			}
+			return externalRemoteStorage, nil
+		},
+		Storage: GetExternalRemoteStorage,
+	})
+}
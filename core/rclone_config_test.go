@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRemoteLocation(t *testing.T) {
+	storage := &ExternalRemoteStorage{Remote: "mydrive"}
+	assert.Equal(t, "mydrive:opencloudsaves/Game", getRemoteLocation(storage, "opencloudsaves/Game"))
+
+	storage.PathPrefix = "backups/games"
+	assert.Equal(t, "mydrive:backups/games/opencloudsaves/Game", getRemoteLocation(storage, "opencloudsaves/Game"))
+	assert.Equal(t, "mydrive:backups/games/opencloudsaves/Game/", getRemoteLocation(storage, "opencloudsaves/Game/"), "A trailing slash should be kept")
+
+	assert.Equal(t, "opencloudsave-local:opencloudsaves/", getRemoteLocation(&LocalStorage{}, "opencloudsaves/"))
+}
+
+func TestWriteRcloneConfigSection(t *testing.T) {
+	var b strings.Builder
+	writeRcloneConfigSection(&b, "opencloudsave-ftp", map[string]interface{}{
+		"type": "ftp",
+		"host": "example.com",
+	})
+
+	assert.Equal(t, "[opencloudsave-ftp]\nhost = example.com\ntype = ftp\n\n", b.String())
+}
+
+func TestRcloneConfigPerStorage(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	userRcloneConfigPath = "/home/me/.config/rclone/rclone.conf"
+	t.Cleanup(func() {
+		userRcloneConfigPath = ""
+	})
+
+	appConfig, err := GetAppRcloneConfigPath()
+	assert.NoError(t, err)
+	external := &ExternalRemoteStorage{Remote: "mydrive"}
+	assert.Equal(t, appConfig, getRcloneConfigPath(nil))
+	assert.Equal(t, appConfig, getRcloneConfigPath(GetGoogleDriveStorage()))
+	assert.Equal(t, userRcloneConfigPath, getRcloneConfigPath(external))
+
+	// Accounts of the app are used next to an existing remote
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: EXTERNAL}))
+	storage, err := GetGameStorage(&GameDef{Storage: GOOGLE})
+	assert.NoError(t, err)
+	assert.Equal(t, appConfig, getRcloneConfigPath(storage))
+
+	// rclone can not copy between remotes of different configs
+	_, err = MakeCloudManager().runRemoteToRemote(context.Background(), "copy", external, storage, "opencloudsaves/")
+	assert.EqualError(t, err, "mydrive and opencloudsave-googledrive are kept in different rclone configs and can not be copied between")
+}
+
+func TestMigrateRcloneConfigRunsOnce(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	commands := filepath.Join(t.TempDir(), "commands")
+	useFakeRclone(t, fmt.Sprintf(`#!/bin/sh
+echo "$*" >> %v
+case "$*" in
+*"config file"*)
+	echo /home/me/.config/rclone/rclone.conf
+	;;
+*)
+	echo "Failed to load config file" >&2
+	exit 1
+	;;
+esac
+`, commands))
+	t.Cleanup(func() {
+		userRcloneConfigPath = ""
+	})
+
+	assert.Error(t, MigrateRcloneConfig(context.Background()))
+	assert.NoError(t, os.Remove(commands))
+
+	// A failed migration is not tried again
+	assert.NoError(t, MigrateRcloneConfig(context.Background()))
+	assert.NoFileExists(t, commands)
+}
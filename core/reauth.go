@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const AuthStateFilename = "auth_state.json"
+
+// Fragments of rclone and OAuth errors that mean the stored token can no
+// longer be used and the user has to sign in again.
+var authErrorPatterns = []string{
+	"invalid_grant",
+	"invalid_token",
+	"expired_token",
+	"token has been expired or revoked",
+	"couldn't fetch token",
+	"cannot fetch token",
+	"failed to refresh token",
+	"unauthorized_client",
+	"401 unauthorized",
+	"error 401",
+	"status code 401",
+}
+
+// NeedsReauthError is returned when a sync failed because the storage's
+// token expired or was revoked. The storage is marked and can be fixed with
+// ReconnectStorageDrive, which keeps the remote's settings.
+type NeedsReauthError struct {
+	Storage string
+	Reason  string
+}
+
+func (e *NeedsReauthError) Error() string {
+	return fmt.Sprintf("%v needs to be reconnected, its sign in expired or was revoked: %v", e.Storage, e.Reason)
+}
+
+// CredentialsError is returned when a storage that does not sign in through
+// a browser, e.g. FTP, rejected its user name or password.
+type CredentialsError struct {
+	Storage string
+	Reason  string
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("%v rejected its credentials, check the settings shown by cloud show: %v", e.Storage, e.Reason)
+}
+
+// AuthState is kept per remote. It is local to this device, since every
+// device has its own tokens.
+type AuthState struct {
+	NeedsReauth bool     `json:"needsReauth"`
+	Reason      string   `json:"reason,omitempty"`
+	QueuedGames []string `json:"queuedGames,omitempty"`
+}
+
+var authStateMtx sync.Mutex
+
+func isAuthError(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	for _, pattern := range authErrorPatterns {
+		if strings.Contains(lower, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getAuthStatePath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + AuthStateFilename, nil
+}
+
+func readAuthStates() (map[string]*AuthState, error) {
+	result := make(map[string]*AuthState)
+	path, err := getAuthStatePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func writeAuthStates(states map[string]*AuthState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getAuthStatePath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func updateAuthState(storage Storage, update func(state *AuthState)) error {
+	authStateMtx.Lock()
+	defer authStateMtx.Unlock()
+
+	states, err := readAuthStates()
+	if err != nil {
+		return err
+	}
+
+	state, ok := states[storage.GetName()]
+	if !ok {
+		state = &AuthState{}
+	}
+
+	update(state)
+	if !state.NeedsReauth && len(state.QueuedGames) == 0 {
+		delete(states, storage.GetName())
+	} else {
+		states[storage.GetName()] = state
+	}
+
+	return writeAuthStates(states)
+}
+
+func GetAuthState(storage Storage) *AuthState {
+	authStateMtx.Lock()
+	defer authStateMtx.Unlock()
+
+	states, err := readAuthStates()
+	if err != nil {
+		ErrorLogger.Println(err)
+		return &AuthState{}
+	}
+
+	state, ok := states[storage.GetName()]
+	if !ok {
+		return &AuthState{}
+	}
+
+	return state
+}
+
+// checkAuthError turns a failed rclone invocation into an error. Auth
+// failures mark OAuth storages as needing to be reconnected, the others
+// can not be reconnected and only report a CredentialsError.
+func checkAuthError(storage Storage, stderr string) error {
+	if !isAuthError(stderr) {
+		return errors.New(stderr)
+	}
+
+	if _, ok := storage.(OAuthStorage); !ok {
+		return &CredentialsError{
+			Storage: storage.GetName(),
+			Reason:  strings.TrimSpace(stderr),
+		}
+	}
+
+	err := updateAuthState(storage, func(state *AuthState) {
+		state.NeedsReauth = true
+		state.Reason = strings.TrimSpace(stderr)
+	})
+	if err != nil {
+		ErrorLogger.Println(err)
+	}
+
+	return &NeedsReauthError{
+		Storage: storage.GetName(),
+		Reason:  strings.TrimSpace(stderr),
+	}
+}
+
+// QueueSyncForReauth remembers a game whose sync failed because storage
+// needs to be reconnected. It is synced again once the reconnect succeeds.
+func QueueSyncForReauth(storage Storage, gamename string) error {
+	return updateAuthState(storage, func(state *AuthState) {
+		for _, queued := range state.QueuedGames {
+			if queued == gamename {
+				return
+			}
+		}
+		state.QueuedGames = append(state.QueuedGames, gamename)
+	})
+}
+
+// GetStoragesNeedingReauth returns the ids of the accounts whose sign in
+// expired or was revoked.
+func GetStoragesNeedingReauth() []string {
+	result := []string{}
+	for _, provider := range GetConfiguredStorageProviders() {
+		if GetAuthState(provider.Storage()).NeedsReauth {
+			result = append(result, provider.Id)
+		}
+	}
+
+	return result
+}
+
+func (cm *CloudManager) verifyStorageDrive(ctx context.Context, storage Storage) error {
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "lsjson", "--max-depth", "1", storage.GetName()+":")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return checkAuthError(storage, stderr.String())
+	}
+
+	return nil
+}
+
+// ReconnectStorageDrive signs in to an OAuth remote again with
+// `rclone config reconnect`, keeping the remote's settings.
+func (cm *CloudManager) ReconnectStorageDrive(ctx context.Context, storage Storage) error {
+	_, ok := storage.(OAuthStorage)
+	if !ok {
+		return fmt.Errorf("cloud storage %v does not sign in through a browser", storage.GetName())
+	}
+
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "config", "reconnect", storage.GetName()+":", "--auto-confirm")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf(stderr.String())
+	}
+
+	return cm.verifyStorageDrive(ctx, storage)
+}
+
+// CompleteReauth clears the needs re-auth mark of storage and syncs the
+// games that were queued while it was set.
+func CompleteReauth(ctx context.Context, cm *CloudManager, dm GameDefManager, storage Storage, channels *ChannelProvider) error {
+	queued := []string{}
+	err := updateAuthState(storage, func(state *AuthState) {
+		queued = state.QueuedGames
+		state.NeedsReauth = false
+		state.Reason = ""
+		state.QueuedGames = nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(queued) == 0 {
+		channels.Logs <- Message{
+			Message:  "No queued syncs",
+			Finished: true,
+		}
+		return nil
+	}
+
+	InfoLogger.Printf("Running %v syncs queued while %v needed to be reconnected\n", len(queued), storage.GetName())
+	ops := &Options{
+		Gamenames: queued,
+	}
+	RequestMainOperation(ctx, cm, ops, dm, channels)
+	return nil
+}
+
+func isNeedsReauthError(err error) bool {
+	var reauth *NeedsReauthError
+	return errors.As(err, &reauth)
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAuthError(t *testing.T) {
+	assert.True(t, isAuthError(`Failed to create file system: couldn't fetch token: invalid_grant: maybe token expired? - try refreshing with "rclone config reconnect"`))
+	assert.True(t, isAuthError("oauth2: cannot fetch token: 400 Bad Request"))
+	assert.True(t, isAuthError("Token has been expired or revoked."))
+	assert.False(t, isAuthError("directory not found"))
+	assert.False(t, isAuthError("no space left on device"))
+}
+
+func TestNeedsReauthQueue(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: GOOGLE}))
+
+	storage := GetGoogleDriveStorage()
+	assert.False(t, GetAuthState(storage).NeedsReauth)
+
+	err := checkAuthError(storage, "couldn't fetch token: invalid_grant")
+	assert.True(t, isNeedsReauthError(err))
+	assert.True(t, GetAuthState(storage).NeedsReauth)
+	assert.Equal(t, []string{GOOGLE}, GetStoragesNeedingReauth())
+
+	err = checkAuthError(GetDropBoxStorage(), "directory not found")
+	assert.False(t, isNeedsReauthError(err), "Other failures should not be treated as auth failures")
+	assert.False(t, GetAuthState(GetDropBoxStorage()).NeedsReauth)
+
+	// Storages signing in with a password can not be reconnected
+	err = checkAuthError(GetNextCloudStorage(), "401 Unauthorized")
+	assert.False(t, isNeedsReauthError(err))
+	var credentialsErr *CredentialsError
+	assert.ErrorAs(t, err, &credentialsErr)
+	assert.False(t, GetAuthState(GetNextCloudStorage()).NeedsReauth)
+
+	assert.NoError(t, QueueSyncForReauth(storage, "Celeste"))
+	assert.NoError(t, QueueSyncForReauth(storage, "Celeste"))
+	assert.Equal(t, []string{"Celeste"}, GetAuthState(storage).QueuedGames, "Games should only be queued once")
+
+	channels := MakeDefaultChannelProvider()
+	dm := MakeGameDefManager(filepath.Join(t.TempDir(), "overrides.json"))
+	assert.NoError(t, CompleteReauth(context.Background(), MakeCloudManager(), dm, storage, channels))
+	assert.False(t, GetAuthState(storage).NeedsReauth, "Reconnecting should clear the mark")
+	assert.Empty(t, GetAuthState(storage).QueuedGames, "Queued syncs should be run once")
+	assert.Empty(t, GetStoragesNeedingReauth())
+}
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redactedValue = "<redacted>"
+const redactedUser = "<user>"
+
+// Fragments of setting keys whose values must never leave the device.
+// The keys of secret provider fields are added as providers register.
+var secretKeyFragments = []string{
+	"pass",
+	"token",
+	"secret",
+	"bearer",
+	"apikey",
+	"api_key",
+	"cookie",
+	"credential",
+}
+
+var secretKeysMtx sync.Mutex
+var secretPairMatcher *regexp.Regexp
+
+var bearerMatcher = regexp.MustCompile(`(?i)(bearer\s+)[\w.~+/=-]+`)
+
+// registerSecretKey marks the values of a setting key as secret wherever
+// they show up as key=value or "key": "value".
+func registerSecretKey(key string) {
+	secretKeysMtx.Lock()
+	defer secretKeysMtx.Unlock()
+
+	for _, fragment := range secretKeyFragments {
+		if strings.Contains(strings.ToLower(key), fragment) {
+			return
+		}
+	}
+
+	secretKeyFragments = append(secretKeyFragments, strings.ToLower(key))
+	secretPairMatcher = nil
+}
+
+// getSecretPairMatcher matches key=value and "key": "value" pairs whose key
+// looks like a secret, e.g. RCLONE_CONFIG_NAS_PASS=... or "refresh_token":"...".
+func getSecretPairMatcher() *regexp.Regexp {
+	secretKeysMtx.Lock()
+	defer secretKeysMtx.Unlock()
+
+	if secretPairMatcher == nil {
+		fragments := []string{}
+		for _, fragment := range secretKeyFragments {
+			fragments = append(fragments, regexp.QuoteMeta(fragment))
+		}
+		secretPairMatcher = regexp.MustCompile(`(?i)(["']?[\w.-]*(?:` + strings.Join(fragments, "|") + `)[\w.-]*["']?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|'[^']*'|[^\s,;&}"'\]]+)`)
+	}
+
+	return secretPairMatcher
+}
+
+func isSecretKey(key string) bool {
+	secretKeysMtx.Lock()
+	defer secretKeysMtx.Unlock()
+
+	lower := strings.ToLower(key)
+	for _, fragment := range secretKeyFragments {
+		if strings.Contains(lower, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactor removes secrets and the user's identity from text that is shared
+// outside of the device, like logs and diagnostics bundles.
+type redactor struct {
+	mu        sync.Mutex
+	secrets   []string
+	homeDirs  []string
+	usernames []*regexp.Regexp
+}
+
+// logRedactor is applied to everything written to the logfile. It learns
+// secrets as they are stored or handed to rclone.
+var logRedactor = &redactor{}
+
+// registerLogSecret makes sure value never reaches the logfile.
+func registerLogSecret(value string) {
+	logRedactor.addSecret(value)
+}
+
+// newRedactor returns a redactor that knows every secret in the secret store
+// and the current user's home directory and name.
+func newRedactor() *redactor {
+	r := &redactor{}
+	logRedactor.mu.Lock()
+	r.secrets = append(r.secrets, logRedactor.secrets...)
+	logRedactor.mu.Unlock()
+
+	store := GetSecretStore()
+	for _, provider := range GetConfiguredStorageProviders() {
+		storage := provider.Storage()
+		for _, field := range provider.Fields {
+			if !field.Secret {
+				continue
+			}
+
+			value, err := store.Get(getStorageSecretKey(storage, field.Key))
+			if err != nil {
+				if !errors.Is(err, ErrSecretNotFound) && ErrorLogger != nil {
+					ErrorLogger.Println(err)
+				}
+				continue
+			}
+			r.addSecret(value)
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		r.addHomeDir(home)
+	}
+
+	current, err := user.Current()
+	if err == nil {
+		r.addUsername(current.Username)
+		r.addHomeDir(current.HomeDir)
+	}
+
+	return r
+}
+
+func (r *redactor) addSecret(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, secret := range r.secrets {
+		if secret == value {
+			return
+		}
+	}
+
+	r.secrets = append(r.secrets, value)
+	// Longer secrets first, so a secret containing another is removed whole
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+func (r *redactor) addHomeDir(home string) {
+	home = strings.TrimRight(home, `/\`)
+	if home == "" {
+		return
+	}
+
+	r.mu.Lock()
+	r.homeDirs = append(r.homeDirs, home)
+	r.mu.Unlock()
+	r.addUsername(filepath.Base(home))
+}
+
+func (r *redactor) addUsername(name string) {
+	// Windows usernames may be qualified with their domain
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "." || name == "/" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usernames = append(r.usernames, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(name)+`\b`))
+}
+
+// Redact removes known secrets, anything that looks like a secret, home
+// directories and usernames from text.
+func (r *redactor) Redact(text string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+
+	matcher := getSecretPairMatcher()
+	text = matcher.ReplaceAllStringFunc(text, func(match string) string {
+		parts := matcher.FindStringSubmatch(match)
+		if strings.HasPrefix(parts[2], `"`) {
+			return parts[1] + `"` + redactedValue + `"`
+		}
+		return parts[1] + redactedValue
+	})
+	text = bearerMatcher.ReplaceAllString(text, "${1}"+redactedValue)
+
+	for _, home := range r.homeDirs {
+		text = strings.ReplaceAll(text, home, "~")
+		// Paths may be escaped in JSON or use the other separator
+		text = strings.ReplaceAll(text, strings.ReplaceAll(home, `\`, `\\`), "~")
+		text = strings.ReplaceAll(text, filepath.ToSlash(home), "~")
+	}
+
+	for _, username := range r.usernames {
+		text = username.ReplaceAllString(text, redactedUser)
+	}
+
+	return text
+}
+
+// redactSettings returns a copy of settings with the values of secret keys
+// replaced. The removed values are remembered, so they are also removed
+// wherever else they show up.
+func (r *redactor) redactSettings(settings map[string]string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range settings {
+		if isSecretKey(key) {
+			r.addSecret(value)
+			r.addNestedSecrets(value)
+			result[key] = redactedValue
+		} else {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// addNestedSecrets remembers the parts of OAuth tokens, which rclone keeps
+// as JSON. They may show up on their own, e.g. an access token in a log.
+func (r *redactor) addNestedSecrets(value string) {
+	var nested map[string]interface{}
+	if json.Unmarshal([]byte(value), &nested) != nil {
+		return
+	}
+
+	for key, inner := range nested {
+		text, ok := inner.(string)
+		if ok && isSecretKey(key) {
+			r.addSecret(text)
+		}
+	}
+}
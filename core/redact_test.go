@@ -0,0 +1,31 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSecretPairs(t *testing.T) {
+	r := &redactor{}
+
+	assert.Equal(t, "RCLONE_CONFIG_NAS_PASS=<redacted> next", r.Redact("RCLONE_CONFIG_NAS_PASS=aGVsbG8 next"))
+	assert.Equal(t, `{"refresh_token":"<redacted>","expiry":"2023"}`, r.Redact(`{"refresh_token":"1//abc\"def","expiry":"2023"}`))
+	assert.Equal(t, "token = <redacted>", r.Redact("token = abc123"))
+	assert.Equal(t, "Authorization: Bearer <redacted>", r.Redact("Authorization: Bearer abc.def-ghi"))
+	assert.Equal(t, "directory not found", r.Redact("directory not found"))
+}
+
+func TestRedactKnownSecretsAndUser(t *testing.T) {
+	r := &redactor{}
+	r.addHomeDir("/home/alicesmith")
+	r.addSecret("hunter22")
+	settings := r.redactSettings(map[string]string{
+		"type":  "drive",
+		"token": `{"access_token":"ya29.access","token_type":"Bearer"}`,
+	})
+
+	assert.Equal(t, map[string]string{"type": "drive", "token": redactedValue}, settings)
+	assert.Equal(t, "<redacted> <redacted> ~/saves <user>", r.Redact("hunter22 ya29.access /home/alicesmith/saves AliceSmith"))
+	assert.Equal(t, "alicesmithy", r.Redact("alicesmithy"), "Only whole usernames should be removed")
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// cleanRemotePath normalizes a folder on a remote to the "a/b/" form used
+// for remote paths. An empty result means the top of the remote.
+func cleanRemotePath(remotePath string) (string, error) {
+	remotePath = strings.ReplaceAll(strings.TrimSpace(remotePath), "\\", "/")
+	remotePath = strings.Trim(remotePath, "/")
+	if remotePath == "" {
+		return "", nil
+	}
+
+	remotePath = path.Clean(remotePath)
+	if remotePath == "." {
+		return "", nil
+	}
+	if remotePath == ".." || strings.HasPrefix(remotePath, "../") {
+		return "", fmt.Errorf("remote path %v points outside of the remote", remotePath)
+	}
+
+	return remotePath + "/", nil
+}
+
+// GetRemoteRoot returns the folder on the remote that holds every save.
+func (cloudperfs *CloudPerfs) GetRemoteRoot() string {
+	root, err := cleanRemotePath(cloudperfs.RemoteRoot)
+	if err != nil || root == "" {
+		return ToplevelCloudFolder
+	}
+
+	return root
+}
+
+func GetRemoteRoot() string {
+	return GetCurrentCloudPerfsOrDefault().GetRemoteRoot()
+}
+
+// GetGameRemotePath returns the folder on the remote a game is synced to. By
+// default this is <root>/<gamename>/. A gamedef may override it with a path
+// relative to the root, or with an absolute path starting with "/" to adopt
+// an existing folder layout.
+func GetGameRemotePath(gamename string, gamedef *GameDef) (string, error) {
+	root := GetRemoteRoot()
+	if gamedef == nil || strings.TrimSpace(gamedef.RemotePath) == "" {
+		return fmt.Sprintf("%v%v/", root, gamename), nil
+	}
+
+	override := strings.ReplaceAll(strings.TrimSpace(gamedef.RemotePath), "\\", "/")
+	remotePath, err := cleanRemotePath(override)
+	if err != nil {
+		return "", err
+	}
+	if remotePath == "" {
+		return "", fmt.Errorf("remote path of %v can not be the top of the remote", gamename)
+	}
+
+	if strings.HasPrefix(override, "/") {
+		return remotePath, nil
+	}
+
+	return root + remotePath, nil
+}
+
+// MoveRemoteDir moves the contents of one folder of the remote to another.
+// rclone performs the move server-side when the backend supports it.
+func (cm *CloudManager) MoveRemoteDir(ctx context.Context, storage Storage, from string, to string) (string, error) {
+	exists, err := cm.DoesRemoteDirExist(ctx, storage, strings.TrimSuffix(from, "/"))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", nil
+	}
+
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), "move", "--delete-empty-src-dirs", getRemoteLocation(storage, from), getRemoteLocation(storage, to))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf(stderr.String())
+	}
+
+	return stderr.String(), nil
+}
+
+// UpdateRemoteRoot changes the folder that holds every save, moving the data
+// already stored under the old folder of the current cloud and of every
+// account games sync to. An empty root restores the default.
+func UpdateRemoteRoot(ctx context.Context, cm *CloudManager, dm GameDefManager, root string) (string, error) {
+	newRoot, err := cleanRemotePath(root)
+	if err != nil {
+		return "", err
+	}
+	if newRoot == "" {
+		newRoot = ToplevelCloudFolder
+	}
+
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return "", err
+	}
+
+	oldRoot := cloudperfs.GetRemoteRoot()
+	if oldRoot == newRoot {
+		return "", nil
+	}
+
+	if strings.HasPrefix(newRoot, oldRoot) || strings.HasPrefix(oldRoot, newRoot) {
+		return "", fmt.Errorf("remote folder %v can not be moved to %v, the folders overlap", oldRoot, newRoot)
+	}
+
+	storage, err := GetCloudStorage(cloudperfs.Cloud)
+	if err != nil {
+		return "", err
+	}
+
+	storages := []Storage{storage}
+	seen := map[string]bool{storage.GetName(): true}
+	for _, gamedef := range dm.GetGameDefMap() {
+		if gamedef.Storage == "" {
+			continue
+		}
+
+		gameStorage, err := GetGameStorage(gamedef)
+		if err != nil {
+			return "", err
+		}
+		if !seen[gameStorage.GetName()] {
+			seen[gameStorage.GetName()] = true
+			storages = append(storages, gameStorage)
+		}
+	}
+
+	InfoLogger.Println("Moving remote root from " + oldRoot + " to " + newRoot)
+	results := []string{}
+	for i, s := range storages {
+		result, err := cm.MoveRemoteDir(ctx, s, oldRoot, newRoot)
+		if err != nil {
+			// Every account keeps its saves under the same root, so the
+			// accounts already moved are moved back
+			for _, moved := range storages[:i] {
+				_, rerr := cm.MoveRemoteDir(ctx, moved, newRoot, oldRoot)
+				if rerr != nil {
+					ErrorLogger.Println(rerr)
+				}
+			}
+			return "", err
+		}
+		results = append(results, result)
+	}
+
+	secondary := GetSecondaryStorageProvider()
+	if secondary != nil {
+		_, err = cm.MoveRemoteDir(ctx, secondary, oldRoot, newRoot)
+		if err != nil {
+			// The secondary can be repaired later with --heal-secondary
+			ErrorLogger.Println(err)
+		}
+	}
+
+	cloudperfs.RemoteRoot = newRoot
+	return strings.Join(results, "\n"), CommitCloudPerfs(cloudperfs)
+}
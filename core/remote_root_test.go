@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanRemotePath(t *testing.T) {
+	cases := map[string]string{
+		"":                      "",
+		"/":                     "",
+		"Backups/GameSaves":     "Backups/GameSaves/",
+		" /Backups/GameSaves/ ": "Backups/GameSaves/",
+		"Backups\\GameSaves":    "Backups/GameSaves/",
+		"Backups//./GameSaves":  "Backups/GameSaves/",
+	}
+
+	for input, expected := range cases {
+		result, err := cleanRemotePath(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, result, input)
+	}
+
+	_, err := cleanRemotePath("../outside")
+	assert.Error(t, err, "Paths leaving the remote should be rejected")
+	_, err = cleanRemotePath("a/../../outside")
+	assert.Error(t, err, "Paths leaving the remote should be rejected")
+}
+
+func TestGetGameRemotePath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+
+	remotePath, err := GetGameRemotePath("Celeste", &GameDef{})
+	assert.NoError(t, err)
+	assert.Equal(t, "opencloudsaves/Celeste/", remotePath, "The default root should be used when no perfs exist")
+
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: LOCAL, RemoteRoot: "Backups/GameSaves"}))
+
+	remotePath, err = GetGameRemotePath("Celeste", &GameDef{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Backups/GameSaves/Celeste/", remotePath)
+
+	remotePath, err = GetGameRemotePath("Celeste (GOG)", &GameDef{RemotePath: "Celeste"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Backups/GameSaves/Celeste/", remotePath, "Two editions should be able to share a folder")
+
+	remotePath, err = GetGameRemotePath("Celeste", &GameDef{RemotePath: "/Saves/celeste"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Saves/celeste/", remotePath, "An absolute override should ignore the root")
+
+	_, err = GetGameRemotePath("Celeste", &GameDef{RemotePath: "/"})
+	assert.Error(t, err)
+}
+
+func TestUpdateRemoteRootMovesEveryAccount(t *testing.T) {
+	commands := filepath.Join(t.TempDir(), "commands")
+	// Moving the saves of the account named Broken fails
+	dm := setupRunGame(t, fmt.Sprintf(`#!/bin/sh
+echo "$*" >> %v
+case "$*" in
+*move*broken*)
+	echo "Failed to move: permission denied" >&2
+	exit 1
+	;;
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`, commands))
+	useMemorySecretStore(t)
+
+	family, err := AddStorageInstance(GOOGLE, "Family")
+	assert.NoError(t, err)
+	dm.GetGameDefMap()["Hollow"].Storage = family.Id
+
+	_, err = UpdateRemoteRoot(context.Background(), MakeCloudManager(), dm, "Backups")
+	assert.NoError(t, err)
+	assert.Equal(t, "Backups/", GetRemoteRoot())
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "move --delete-empty-src-dirs opencloudsave-dropbox:opencloudsaves/ opencloudsave-dropbox:Backups/")
+	assert.Contains(t, string(log), "move --delete-empty-src-dirs opencloudsave-google-family:opencloudsaves/ opencloudsave-google-family:Backups/")
+
+	// Accounts already moved are moved back when one fails
+	broken, err := AddStorageInstance(GOOGLE, "Broken")
+	assert.NoError(t, err)
+	dm.GetGameDefMap()["Hollow"].Storage = broken.Id
+	assert.NoError(t, os.Remove(commands))
+	_, err = UpdateRemoteRoot(context.Background(), MakeCloudManager(), dm, "Saves")
+	assert.Error(t, err)
+	assert.Equal(t, "Backups/", GetRemoteRoot())
+	log, err = os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "move --delete-empty-src-dirs opencloudsave-dropbox:Saves/ opencloudsave-dropbox:Backups/")
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const DefaultRunTimeout = 15 * time.Second
+
+type RunOptions struct {
+	Game string
+	// How long taking the lock and downloading the saves may take before the
+	// game is started anyway
+	Timeout time.Duration
+	// Passed on to RequestMainOperation for every sync
+	SyncOptions *Options
+}
+
+// runWarning sends a warning that does not stop the game from starting.
+func runWarning(logs chan Message, format string, args ...any) {
+	logs <- Message{Err: fmt.Errorf(format, args...)}
+}
+
+// RunGame runs cmd, the launch command of a game, with the newer saves
+// downloaded before and the saves uploaded after it exits. The game's lock
+// is held the whole time. Nothing keeps the game from starting: when the
+// lock or the saves can not be had within the timeout, a warning is sent to
+// logs and the game is started anyway. The session is recorded, see
+// GetSessions. Returns the exit code of cmd.
+func RunGame(cm *CloudManager, dm GameDefManager, ops *RunOptions, cmd *exec.Cmd, logs chan Message) (int, error) {
+	session := &GameSession{Game: ops.Game}
+	_, tracked := dm.GetGameDefMap()[ops.Game]
+	syncing := tracked
+	if !tracked {
+		runWarning(logs, "%v is not tracked, see list --all. Starting without syncing", ops.Game)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ops.Timeout)
+	defer cancel()
+
+	// The syncs below run while the lock is held
+	syncCtx := context.Background()
+	if syncing {
+		lock, err := LockGame(ctx, ops.Game)
+		if err != nil {
+			runWarning(logs, "%v. Starting without syncing", err)
+			syncing = false
+		} else {
+			defer lock.Unlock()
+			ctx = WithGameLock(ctx, lock)
+			syncCtx = WithGameLock(syncCtx, lock)
+		}
+	}
+
+	if syncing {
+		session.Before = SyncBeforeSession(ctx, cm, dm, ops.SyncOptions, ops.Game, logs)
+		if session.Before.Result == SessionSyncFailed {
+			reason := session.Before.Error
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				reason = fmt.Sprintf("timed out after %v", ops.Timeout)
+			}
+			runWarning(logs, "Could not download the saves of %v: %v. Starting the game anyway, its saves are uploaded when it exits", ops.Game, reason)
+		}
+	} else {
+		session.Before = skippedSessionSync("the game was started without syncing")
+	}
+
+	// The launcher may stop the game through us, the game is stopped and
+	// its saves are still uploaded
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	session.Started = time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return 0, err
+	}
+	session.Pids = []int{cmd.Process.Pid}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	var waitErr error
+	for running := true; running; {
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case waitErr = <-exited:
+			running = false
+		}
+	}
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		exitCode = exitErr.ExitCode()
+		// Like shells, games killed by a signal exit with 128 + the signal
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		}
+	} else if waitErr != nil {
+		return 0, waitErr
+	}
+
+	if !tracked {
+		return exitCode, nil
+	}
+
+	if syncing {
+		endSession(session, SyncAfterSession(syncCtx, cm, dm, ops.SyncOptions, ops.Game, logs))
+	} else {
+		endSession(session, skippedSessionSync("the game was started without syncing"))
+	}
+
+	return exitCode, nil
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A fake rclone whose listings never finish while the file %[1]v exists,
+// like a cloud that can not be reached
+const fakeOfflineRclone = `#!/bin/sh
+if [ -e %[1]v ]; then
+	exec sleep 10
+fi
+case "$*" in
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`
+
+// setupRunGame fakes rclone and a Dropbox account and tracks games, or a
+// game Hollow selected for multisync when none are given.
+func setupRunGame(t *testing.T, rclone string, games ...*GameDef) GameDefManager {
+	useFakeRclone(t, rclone)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	if len(games) == 0 {
+		hollow := testGameDef("Hollow", t.TempDir())
+		hollow.SelectInMultisyncMenu = true
+		games = append(games, hollow)
+	}
+	dm := makeTestGameDefManager(t, games...)
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+	return dm
+}
+
+// runGame runs RunGame, returning its exit code and the warnings it sent.
+func runGame(t *testing.T, dm GameDefManager, ops *RunOptions, cmd *exec.Cmd) (int, []string) {
+	logs := make(chan Message)
+	warnings := []string{}
+	done := make(chan bool)
+	go func() {
+		for msg := range logs {
+			if msg.Err != nil {
+				warnings = append(warnings, msg.Err.Error())
+			}
+		}
+		close(done)
+	}()
+
+	code, err := RunGame(MakeCloudManager(), dm, ops, cmd, logs)
+	close(logs)
+	<-done
+	assert.NoError(t, err)
+	return code, warnings
+}
+
+func TestRunGame(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+
+	ops := &RunOptions{Game: "Hollow", Timeout: 5 * time.Second, SyncOptions: &Options{}}
+	code, warnings := runGame(t, dm, ops, exec.Command("sh", "-c", "exit 3"))
+	assert.Equal(t, 3, code)
+	assert.Empty(t, warnings)
+
+	sessions, err := GetSessions()
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "Hollow", sessions[0].Game)
+	assert.Equal(t, &SessionSync{Result: SessionSyncSkipped, Reason: "the cloud has no newer saves"}, sessions[0].Before)
+	assert.Equal(t, &SessionSync{Result: SessionSyncDone, Bytes: 3072, Files: 4}, sessions[0].After)
+
+	// The lock is released once the game exits
+	lock, err := TryLockGame("Hollow")
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestRunGameOffline(t *testing.T) {
+	offline := filepath.Join(t.TempDir(), "offline")
+	assert.NoError(t, os.WriteFile(offline, nil, 0644))
+	dm := setupRunGame(t, fmt.Sprintf(fakeOfflineRclone, offline))
+
+	// The game brings the cloud back, so its saves can be uploaded
+	ops := &RunOptions{Game: "Hollow", Timeout: 200 * time.Millisecond, SyncOptions: &Options{}}
+	start := time.Now()
+	code, warnings := runGame(t, dm, ops, exec.Command("rm", offline))
+	assert.Equal(t, 0, code)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.NoFileExists(t, offline)
+	assert.Len(t, warnings, 1)
+	assert.True(t, strings.HasPrefix(warnings[0], "Could not download the saves of Hollow: timed out after 200ms"), warnings[0])
+
+	sessions, err := GetSessions()
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, SessionSyncFailed, sessions[0].Before.Result)
+	assert.Equal(t, SessionSyncDone, sessions[0].After.Result)
+}
+
+func TestRunGameLocked(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+	useLockHolder(t, "Desktop GUI")
+
+	lock, err := TryLockGame("Hollow")
+	assert.NoError(t, err)
+	defer lock.Unlock()
+
+	ran := filepath.Join(t.TempDir(), "ran")
+	ops := &RunOptions{Game: "Hollow", Timeout: 200 * time.Millisecond, SyncOptions: &Options{}}
+	code, warnings := runGame(t, dm, ops, exec.Command("touch", ran))
+	assert.Equal(t, 0, code)
+	assert.FileExists(t, ran)
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "Desktop GUI")
+
+	sessions, err := GetSessions()
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, SessionSyncSkipped, sessions[0].Before.Result)
+	assert.Equal(t, SessionSyncSkipped, sessions[0].After.Result)
+}
@@ -0,0 +1,437 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ScheduleRunsFilename = "schedule_runs.json"
+
+// Schedules can not run more often than this
+const minScheduleInterval = time.Minute
+
+// How often RunScheduler looks at the clock. Timers stop while the computer
+// sleeps, so runs missed during sleep are noticed within this long after
+// waking up.
+var scheduleCheckInterval = time.Minute
+
+// SyncSchedule syncs a set of games periodically. Either Every or At is set.
+type SyncSchedule struct {
+	Name string `json:"name"`
+	// Runs every interval, e.g. 2h
+	Every string `json:"every,omitempty"`
+	// Runs daily at the local time, e.g. 03:00
+	At string `json:"at,omitempty"`
+	// Only syncs the games selected for multisync
+	Selected bool `json:"selected,omitempty"`
+	// Only syncs these games. Every tracked game is synced when neither
+	// Games nor Selected is set
+	Games []string `json:"games,omitempty"`
+	// Delays every run by up to this long, e.g. 10m, so devices sharing the
+	// settings do not all sync at the same time
+	Jitter string `json:"jitter,omitempty"`
+	// When the schedule was added, the first run is counted from here
+	Created time.Time `json:"created,omitempty"`
+}
+
+// ScheduleRun is the record of the last run of a schedule.
+type ScheduleRun struct {
+	Schedule  string    `json:"schedule"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Games     []string  `json:"games"`
+	Failed    []string  `json:"failed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	ErrorKind string    `json:"errorKind,omitempty"`
+}
+
+type SchedulerOptions struct {
+	// Runs the schedules that are due and returns, instead of running until
+	// stopped. Used by the systemd timer
+	Once bool
+	// Passed on to RequestMainOperation for every sync
+	SyncOptions *Options
+}
+
+var scheduleRunsMtx sync.Mutex
+
+// Validate checks that the schedule can be run.
+func (s *SyncSchedule) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return fmt.Errorf("schedule has no name")
+	}
+
+	if (s.Every == "") == (s.At == "") {
+		return fmt.Errorf("schedule %v needs either an interval or a time of day", s.Name)
+	}
+
+	if s.Every != "" {
+		every, err := time.ParseDuration(s.Every)
+		if err != nil {
+			return fmt.Errorf("schedule %v has an invalid interval: %v", s.Name, err)
+		}
+		if every < minScheduleInterval {
+			return fmt.Errorf("schedule %v runs more often than every %v", s.Name, minScheduleInterval)
+		}
+	}
+
+	if s.At != "" {
+		_, err := time.Parse("15:04", s.At)
+		if err != nil {
+			return fmt.Errorf("schedule %v has an invalid time of day %v, e.g. 03:00", s.Name, s.At)
+		}
+	}
+
+	if s.Jitter != "" {
+		jitter, err := time.ParseDuration(s.Jitter)
+		if err != nil || jitter < 0 {
+			return fmt.Errorf("schedule %v has an invalid jitter %v", s.Name, s.Jitter)
+		}
+	}
+
+	return nil
+}
+
+// Describe tells when the schedule runs, e.g. "daily at 03:00".
+func (s *SyncSchedule) Describe() string {
+	if s.At != "" {
+		return "daily at " + s.At
+	}
+	return "every " + s.Every
+}
+
+// DueAt returns when the schedule is due after its last run, which is zero
+// when it never ran. A schedule that was due while the computer was off or
+// asleep is due right away, runs that were missed are not run more than
+// once. A schedule that never ran is due right away, daily ones at their
+// first time of day after they were created. Jitter is left out, see
+// PlannedAt.
+func (s *SyncSchedule) DueAt(lastRun time.Time, now time.Time) time.Time {
+	if lastRun.IsZero() && (s.Every != "" || s.Created.IsZero()) {
+		return now
+	}
+
+	if s.Every != "" {
+		every, _ := time.ParseDuration(s.Every)
+		return lastRun.Add(every)
+	}
+
+	at, _ := time.Parse("15:04", s.At)
+	after := lastRun
+	if after.IsZero() {
+		after = s.Created
+	}
+	after = after.Local()
+	due := time.Date(after.Year(), after.Month(), after.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+	if !due.After(after) {
+		due = due.AddDate(0, 0, 1)
+	}
+
+	return due
+}
+
+// PlannedAt returns when the schedule runs after its last run, which is
+// DueAt delayed by the jitter. The delay is the same every time it is
+// asked for, so processes checking the schedule agree on it.
+func (s *SyncSchedule) PlannedAt(lastRun time.Time, now time.Time) time.Time {
+	due := s.DueAt(lastRun, now)
+	jitter, _ := time.ParseDuration(s.Jitter)
+	if jitter <= 0 {
+		return due
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%v %v", s.Name, due.Unix())
+	return due.Add(time.Duration(hash.Sum64() % uint64(jitter)))
+}
+
+// ResolveGames returns the games the schedule syncs.
+func (s *SyncSchedule) ResolveGames(dm GameDefManager) []string {
+	result := []string{}
+	for gamename, gamedef := range dm.GetGameDefMap() {
+		if gamedef.Hidden {
+			continue
+		}
+		if s.Selected && !gamedef.SelectInMultisyncMenu {
+			continue
+		}
+		if len(s.Games) > 0 && !containsString(s.Games, gamename) {
+			continue
+		}
+		result = append(result, gamename)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// GetSchedules returns the schedules of the settings.
+func GetSchedules() ([]*SyncSchedule, error) {
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return nil, err
+	}
+
+	return cloudperfs.Schedules, nil
+}
+
+// AddSchedule adds schedule to the settings, or replaces the schedule of
+// the same name.
+func AddSchedule(schedule *SyncSchedule) error {
+	err := schedule.Validate()
+	if err != nil {
+		return err
+	}
+
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return err
+	}
+
+	schedules := []*SyncSchedule{}
+	for _, existing := range cloudperfs.Schedules {
+		if existing.Name != schedule.Name {
+			schedules = append(schedules, existing)
+		}
+	}
+	if schedule.Created.IsZero() {
+		schedule.Created = time.Now().Round(0)
+	}
+	cloudperfs.Schedules = append(schedules, schedule)
+
+	return CommitCloudPerfs(cloudperfs)
+}
+
+// RemoveSchedule removes the schedule called name from the settings.
+func RemoveSchedule(name string) error {
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return err
+	}
+
+	schedules := []*SyncSchedule{}
+	for _, existing := range cloudperfs.Schedules {
+		if existing.Name != name {
+			schedules = append(schedules, existing)
+		}
+	}
+	if len(schedules) == len(cloudperfs.Schedules) {
+		return fmt.Errorf("no schedule called %v, see schedule list", name)
+	}
+	cloudperfs.Schedules = schedules
+
+	return CommitCloudPerfs(cloudperfs)
+}
+
+func getScheduleRunsPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + ScheduleRunsFilename, nil
+}
+
+// GetScheduleRuns returns the last run of every schedule that ran on this
+// device, by schedule name.
+func GetScheduleRuns() (map[string]*ScheduleRun, error) {
+	scheduleRunsMtx.Lock()
+	defer scheduleRunsMtx.Unlock()
+	return readScheduleRuns()
+}
+
+func readScheduleRuns() (map[string]*ScheduleRun, error) {
+	result := make(map[string]*ScheduleRun)
+	path, err := getScheduleRunsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func recordScheduleRun(run *ScheduleRun) error {
+	scheduleRunsMtx.Lock()
+	defer scheduleRunsMtx.Unlock()
+
+	runs, err := readScheduleRuns()
+	if err != nil {
+		return err
+	}
+	runs[run.Schedule] = run
+
+	data, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getScheduleRunsPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// getLastRun returns when the schedule called name last started, or zero.
+func getLastRun(runs map[string]*ScheduleRun, name string) time.Time {
+	run, ok := runs[name]
+	if !ok {
+		return time.Time{}
+	}
+	return run.Started
+}
+
+// RunScheduler runs the schedules of the settings when they are due, until
+// ctx is done. The settings are read again before every check, so changed
+// schedules are picked up. With ops.Once, the schedules that are due are run
+// and RunScheduler returns. Messages of the syncs are sent to channels.
+func RunScheduler(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *SchedulerOptions, channels *ChannelProvider) error {
+	if GetCurrentStorageProvider() == nil {
+		return WithErrorKind(ErrorKindNoCloud, ErrNoCloud)
+	}
+
+	err := CheckSecretsUnlocked()
+	if err != nil {
+		return err
+	}
+
+	logs := channels.Logs
+	invalid := make(map[string]bool)
+	announced := make(map[string]time.Time)
+	for {
+		schedules, err := GetSchedules()
+		if err != nil {
+			return err
+		}
+		runs, err := GetScheduleRuns()
+		if err != nil {
+			return err
+		}
+
+		// Compares wall clock times, which keep going while the computer
+		// sleeps
+		now := time.Now().Round(0)
+		next := now.Add(scheduleCheckInterval)
+		for _, schedule := range schedules {
+			err := schedule.Validate()
+			if err != nil {
+				if !invalid[schedule.Name] {
+					invalid[schedule.Name] = true
+					runWarning(logs, "Skipping %v", err)
+				}
+				continue
+			}
+
+			planned := schedule.PlannedAt(getLastRun(runs, schedule.Name), now)
+			if planned.After(now) {
+				if !ops.Once && !announced[schedule.Name].Equal(planned) {
+					announced[schedule.Name] = planned
+					LogMessage(logs, "Schedule %v (%v) runs next at %v", schedule.Name, schedule.Describe(), planned.Local().Format("2006-01-02 15:04"))
+				}
+				if planned.Before(next) {
+					next = planned
+				}
+				continue
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+			runSchedule(cm, dm, ops.SyncOptions, schedule, logs)
+			// Looks at the clock again, as the sync took a while
+			next = time.Now()
+		}
+
+		if ops.Once {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			LogMessage(logs, "Scheduler stopped")
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// runSchedule syncs the games of schedule and records the run.
+func runSchedule(cm *CloudManager, dm GameDefManager, ops *Options, schedule *SyncSchedule, logs chan Message) {
+	// Picks up games that were added or changed by other instances
+	err := dm.ApplyUserOverrides()
+	if err != nil {
+		WarnLogger.Println(err)
+	}
+
+	run := &ScheduleRun{
+		Schedule: schedule.Name,
+		Started:  time.Now().Round(0),
+		Games:    schedule.ResolveGames(dm),
+	}
+
+	if len(run.Games) == 0 {
+		LogMessage(logs, "Schedule %v has no games to sync", schedule.Name)
+	} else {
+		LogMessage(logs, "Running schedule %v, syncing %v", schedule.Name, strings.Join(run.Games, ", "))
+		syncops := *ops
+		syncops.Gamenames = run.Games
+		syncops.LeaseWait = DefaultLeaseWait
+
+		channels := MakeDefaultChannelProvider()
+		go func() {
+			// A run that started is finished even when the scheduler is
+			// stopped
+			RequestMainOperation(context.Background(), cm, &syncops, dm, channels)
+			close(channels.Logs)
+		}()
+
+		for msg := range channels.Logs {
+			logs <- msg
+
+			if msg.Err != nil && run.Error == "" {
+				run.Error = msg.Err.Error()
+				run.ErrorKind = GetErrorKind(msg.Err)
+			}
+			if msg.Event != nil && msg.Event.Phase == PhaseError && !containsString(run.Failed, msg.Event.Game) {
+				run.Failed = append(run.Failed, msg.Event.Game)
+			}
+		}
+	}
+
+	run.Finished = time.Now().Round(0)
+	err = recordScheduleRun(run)
+	if err != nil {
+		ErrorLogger.Println(err)
+	}
+}
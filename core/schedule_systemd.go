@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The name of the systemd user units running the schedules
+const SystemdUnitName = "opencloudsave-schedule"
+
+// How often the systemd timer runs the schedules that are due. The timer
+// only checks the schedules, so it does not change along with them.
+const systemdCheckCalendar = "*:0/5"
+
+// SystemdUnits are the systemd user units that run the schedules without
+// the app being open.
+type SystemdUnits struct {
+	Service string
+	Timer   string
+}
+
+// quoteSystemdArg quotes arg for the command line of a systemd unit.
+func quoteSystemdArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+// MakeSystemdUnits returns the units running executable, the path of this
+// binary, every few minutes to run the schedules that are due. Runs missed
+// while the computer was off are caught up after booting. The service
+// unlocks the secret vault with the passphrase in passphraseFile, if set,
+// as nobody can enter it when the timer runs.
+func MakeSystemdUnits(executable string, passphraseFile string) *SystemdUnits {
+	command := quoteSystemdArg(executable)
+	vault := fmt.Sprintf(`# Without an OS keyring the secret vault is unlocked by adding
+# --vault-passphrase-file <FILE> before schedule, or by setting
+# Environment=%v=<PASSPHRASE>
+`, VaultPassphraseEnv)
+	if passphraseFile != "" {
+		command += " --vault-passphrase-file " + quoteSystemdArg(passphraseFile)
+		vault = ""
+	}
+
+	service := fmt.Sprintf(`[Unit]
+Description=Sync game saves on the %[1]v schedules
+
+[Service]
+Type=oneshot
+%[3]vExecStart=%[2]v schedule run --once
+`, APP_NAME, command, vault)
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Run the %[1]v sync schedules
+
+[Timer]
+OnCalendar=%[2]v
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, APP_NAME, systemdCheckCalendar)
+
+	return &SystemdUnits{Service: service, Timer: timer}
+}
+
+func GetSystemdUserDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// InstallSystemdUnits writes units to the systemd user folder and returns
+// the paths of the service and the timer. The timer still has to be enabled.
+func InstallSystemdUnits(units *SystemdUnits) (string, string, error) {
+	dir, err := GetSystemdUserDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", "", err
+	}
+
+	servicePath := filepath.Join(dir, SystemdUnitName+".service")
+	err = os.WriteFile(servicePath, []byte(units.Service), 0644)
+	if err != nil {
+		return "", "", err
+	}
+
+	timerPath := filepath.Join(dir, SystemdUnitName+".timer")
+	err = os.WriteFile(timerPath, []byte(units.Timer), 0644)
+	if err != nil {
+		return "", "", err
+	}
+
+	return servicePath, timerPath, nil
+}
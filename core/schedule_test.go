@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	assert.NoError(t, (&SyncSchedule{Name: "often", Every: "2h"}).Validate())
+	assert.NoError(t, (&SyncSchedule{Name: "nightly", At: "03:00", Jitter: "10m"}).Validate())
+	assert.Error(t, (&SyncSchedule{Every: "2h"}).Validate())
+	assert.Error(t, (&SyncSchedule{Name: "both", Every: "2h", At: "03:00"}).Validate())
+	assert.Error(t, (&SyncSchedule{Name: "neither"}).Validate())
+	assert.Error(t, (&SyncSchedule{Name: "fast", Every: "10s"}).Validate())
+	assert.Error(t, (&SyncSchedule{Name: "late", At: "25:00"}).Validate())
+	assert.Error(t, (&SyncSchedule{Name: "jitter", Every: "2h", Jitter: "-1m"}).Validate())
+}
+
+func TestScheduleDueAt(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+
+	every := &SyncSchedule{Name: "often", Every: "2h", Created: now.Add(-time.Hour)}
+	assert.Equal(t, now, every.DueAt(time.Time{}, now))
+	assert.Equal(t, now.Add(time.Hour), every.DueAt(now.Add(-time.Hour), now))
+	// Runs missed while asleep are caught up once
+	assert.Equal(t, now.Add(-8*time.Hour), every.DueAt(now.Add(-10*time.Hour), now))
+
+	daily := &SyncSchedule{Name: "nightly", At: "03:00", Created: now}
+	tomorrow := time.Date(2024, 3, 11, 3, 0, 0, 0, time.Local)
+	assert.Equal(t, tomorrow, daily.DueAt(time.Time{}, now))
+	assert.Equal(t, tomorrow, daily.DueAt(time.Date(2024, 3, 10, 3, 0, 5, 0, time.Local), now))
+	// The computer was off at 03:00
+	assert.Equal(t, time.Date(2024, 3, 10, 3, 0, 0, 0, time.Local), daily.DueAt(time.Date(2024, 3, 9, 3, 0, 5, 0, time.Local), now))
+	// Schedules added by hand run right away
+	assert.Equal(t, now, (&SyncSchedule{Name: "nightly", At: "03:00"}).DueAt(time.Time{}, now))
+
+	jittered := &SyncSchedule{Name: "nightly", At: "03:00", Created: now, Jitter: "10m"}
+	planned := jittered.PlannedAt(time.Time{}, now)
+	assert.False(t, planned.Before(tomorrow))
+	assert.True(t, planned.Before(tomorrow.Add(10*time.Minute)))
+	assert.Equal(t, planned, jittered.PlannedAt(time.Time{}, now.Add(time.Hour)))
+}
+
+func TestRunSchedulerOnce(t *testing.T) {
+	dm := setupRunGame(t, fakeSyncRclone)
+
+	now := time.Now().Round(0)
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{
+		Cloud: DROPBOX,
+		Schedules: []*SyncSchedule{
+			{Name: "due", Every: "2h", Selected: true, Created: now.Add(-24 * time.Hour)},
+			{Name: "later", Every: "2h", Created: now.Add(-24 * time.Hour)},
+			{Name: "invalid", Every: "soon"},
+		},
+	}))
+	assert.NoError(t, recordScheduleRun(&ScheduleRun{Schedule: "due", Started: now.Add(-3 * time.Hour)}))
+	assert.NoError(t, recordScheduleRun(&ScheduleRun{Schedule: "later", Started: now.Add(-time.Hour)}))
+
+	channels := MakeDefaultChannelProvider()
+	var schedulerErr error
+	go func() {
+		schedulerErr = RunScheduler(context.Background(), MakeCloudManager(), dm, &SchedulerOptions{Once: true, SyncOptions: &Options{}}, channels)
+		close(channels.Logs)
+	}()
+	warnings := []string{}
+	for msg := range channels.Logs {
+		if msg.Err != nil {
+			warnings = append(warnings, msg.Err.Error())
+		}
+	}
+	assert.NoError(t, schedulerErr)
+	assert.Len(t, warnings, 1)
+	assert.True(t, strings.HasPrefix(warnings[0], "Skipping schedule invalid"), warnings[0])
+
+	runs, err := GetScheduleRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hollow"}, runs["due"].Games)
+	assert.Empty(t, runs["due"].Failed)
+	assert.False(t, runs["due"].Started.Before(now))
+	assert.True(t, now.Add(-time.Hour).Equal(runs["later"].Started))
+	assert.NotContains(t, runs, "invalid")
+}
+
+func TestMakeSystemdUnits(t *testing.T) {
+	units := MakeSystemdUnits("/home/me/My Apps/opencloudsave%1", "")
+	assert.Contains(t, units.Service, `ExecStart="/home/me/My Apps/opencloudsave%%1" schedule run --once`)
+	assert.Contains(t, units.Service, "--vault-passphrase-file <FILE>", "The units should tell how to unlock the vault")
+	assert.Contains(t, units.Timer, "Persistent=true")
+
+	units = MakeSystemdUnits("/usr/bin/opencloudsave", "/home/me/.vault passphrase")
+	assert.Contains(t, units.Service, `ExecStart=/usr/bin/opencloudsave --vault-passphrase-file "/home/me/.vault passphrase" schedule run --once`)
+}
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+var ErrSecretStoreLocked = errors.New("secret vault is locked, set OPENCLOUDSAVE_VAULT_PASSPHRASE or unlock it with a passphrase")
+
+// SecretStore keeps provider credentials out of the perfs and the rclone
+// config. Secrets are only handed to rclone at runtime.
+type SecretStore interface {
+	Get(key string) (string, error)
+	Set(key string, value string) error
+	Delete(key string) error
+}
+
+// MemorySecretStore is a SecretStore that only lives for the current
+// process. It is used by tests.
+type MemorySecretStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func NewMemorySecretStore() *MemorySecretStore {
+	return &MemorySecretStore{
+		secrets: make(map[string]string),
+	}
+}
+
+func (ms *MemorySecretStore) Get(key string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (ms *MemorySecretStore) Set(key string, value string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.secrets[key] = value
+	return nil
+}
+
+func (ms *MemorySecretStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.secrets, key)
+	return nil
+}
+
+// FallbackSecretStore keeps secrets in the OS keyring, and also looks them up
+// in the vault. The keyring is not reachable in every session, e.g. over SSH
+// or in Game Mode, where secrets were saved in the vault instead.
+type FallbackSecretStore struct {
+	keyring SecretStore
+	vault   *VaultSecretStore
+}
+
+func NewFallbackSecretStore(keyring SecretStore, vault *VaultSecretStore) *FallbackSecretStore {
+	return &FallbackSecretStore{
+		keyring: keyring,
+		vault:   vault,
+	}
+}
+
+func (fb *FallbackSecretStore) Get(key string) (string, error) {
+	value, err := fb.keyring.Get(key)
+	if err == nil {
+		return value, nil
+	}
+
+	value, verr := fb.vault.Get(key)
+	if verr == nil {
+		return value, nil
+	}
+
+	return "", err
+}
+
+func (fb *FallbackSecretStore) Set(key string, value string) error {
+	return fb.keyring.Set(key, value)
+}
+
+// Delete removes key from both stores, so it does not come back from the
+// vault.
+func (fb *FallbackSecretStore) Delete(key string) error {
+	err := fb.keyring.Delete(key)
+	if fb.vault.IsLocked() {
+		return err
+	}
+
+	verr := fb.vault.Delete(key)
+	if err == nil {
+		err = verr
+	}
+	return err
+}
+
+var secretStoreMtx sync.Mutex
+var secretStore SecretStore
+var secretEnvironment []string
+
+// GetSecretStore returns the OS keyring when one is available, falling back
+// to an encrypted vault file protected by a passphrase.
+func GetSecretStore() SecretStore {
+	secretStoreMtx.Lock()
+	defer secretStoreMtx.Unlock()
+
+	if secretStore == nil {
+		vault := NewDefaultVaultSecretStore()
+		keyring := getPlatformSecretStore()
+		if keyring == nil {
+			secretStore = vault
+		} else {
+			secretStore = NewFallbackSecretStore(keyring, vault)
+		}
+	}
+
+	return secretStore
+}
+
+func SetSecretStore(store SecretStore) {
+	secretStoreMtx.Lock()
+	defer secretStoreMtx.Unlock()
+
+	secretStore = store
+	secretEnvironment = nil
+}
+
+// IsSecretStoreLocked reports whether the secret store needs a passphrase
+// before it can be used.
+func IsSecretStoreLocked() bool {
+	vault, ok := GetSecretStore().(*VaultSecretStore)
+	return ok && vault.IsLocked()
+}
+
+// HasLockedSecrets reports whether secrets were stored in the vault and can
+// not be read until it is unlocked.
+func HasLockedSecrets() bool {
+	vault, ok := GetSecretStore().(*VaultSecretStore)
+	return ok && vault.IsLocked() && vault.exists()
+}
+
+// CheckSecretsUnlocked fails with ErrSecretStoreLocked when HasLockedSecrets.
+// Runs nobody can enter the passphrase for check it before syncing, instead
+// of failing every sync that needs a secret.
+func CheckSecretsUnlocked() error {
+	if HasLockedSecrets() {
+		return WithErrorKind(ErrorKindVaultLocked, ErrSecretStoreLocked)
+	}
+
+	return nil
+}
+
+// UnlockSecretStore unlocks the vault, also when it is only looked up after
+// the OS keyring.
+func UnlockSecretStore(passphrase string) error {
+	switch store := GetSecretStore().(type) {
+	case *VaultSecretStore:
+		return store.Unlock(passphrase)
+	case *FallbackSecretStore:
+		return store.vault.Unlock(passphrase)
+	default:
+		return nil
+	}
+}
+
+func invalidateSecretEnvironment() {
+	secretStoreMtx.Lock()
+	defer secretStoreMtx.Unlock()
+
+	secretEnvironment = nil
+}
+
+func getStorageSecretKey(storage Storage, field string) string {
+	return storage.GetName() + "/" + field
+}
+
+func SetStorageSecret(storage Storage, field string, value string) error {
+	defer invalidateSecretEnvironment()
+	registerLogSecret(value)
+	return GetSecretStore().Set(getStorageSecretKey(storage, field), value)
+}
+
+func GetStorageSecret(storage Storage, field string) (string, error) {
+	return GetSecretStore().Get(getStorageSecretKey(storage, field))
+}
+
+// DeleteStorageSecrets removes every secret of the provider that owns storage.
+func DeleteStorageSecrets(storage Storage) {
+	defer invalidateSecretEnvironment()
+	for _, provider := range GetConfiguredStorageProviders() {
+		if provider.Storage().GetName() != storage.GetName() {
+			continue
+		}
+
+		for _, field := range provider.Fields {
+			if field.Secret {
+				GetSecretStore().Delete(getStorageSecretKey(storage, field.Key))
+			}
+		}
+	}
+}
+
+// getRcloneEnvName returns the environment variable rclone reads to override
+// a parameter of a remote in its config.
+func getRcloneEnvName(remote string, parameter string) string {
+	name := strings.ReplaceAll(remote+"_"+parameter, "-", "_")
+	return "RCLONE_CONFIG_" + strings.ToUpper(name)
+}
+
+// getSecretEnvironment builds the environment passed to rclone so that stored
+// secrets reach the remotes without being written to the rclone config.
+func getSecretEnvironment() []string {
+	secretStoreMtx.Lock()
+	cached := secretEnvironment
+	secretStoreMtx.Unlock()
+	if cached != nil {
+		return cached
+	}
+
+	store := GetSecretStore()
+	env := []string{}
+	for _, provider := range GetConfiguredStorageProviders() {
+		storage := provider.Storage()
+		for _, field := range provider.Fields {
+			if !field.Secret {
+				continue
+			}
+
+			value, err := store.Get(getStorageSecretKey(storage, field.Key))
+			if err != nil {
+				if !errors.Is(err, ErrSecretNotFound) && ErrorLogger != nil {
+					ErrorLogger.Println(err)
+				}
+				continue
+			}
+
+			registerLogSecret(value)
+			env = append(env, getRcloneEnvName(storage.GetName(), field.Key)+"="+value)
+		}
+	}
+
+	secretStoreMtx.Lock()
+	secretEnvironment = env
+	secretStoreMtx.Unlock()
+	return env
+}
+
+func getRcloneEnvironment() []string {
+	return append(os.Environ(), getSecretEnvironment()...)
+}
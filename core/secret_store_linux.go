@@ -0,0 +1,102 @@
+//go:build linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SecretServiceStore stores secrets in the desktop keyring through the
+// Secret Service API, using libsecret's secret-tool.
+type SecretServiceStore struct {
+}
+
+const secretServiceApplication = "opencloudsave"
+
+func (ss *SecretServiceStore) run(stdin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(context.Background(), "secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool %v failed: %v: %w", args[0], stderr.String(), err)
+	}
+
+	return stdout.String(), nil
+}
+
+func (ss *SecretServiceStore) Get(key string) (string, error) {
+	value, err := ss.run("", "lookup", "application", secretServiceApplication, "key", key)
+	if err != nil {
+		// secret-tool exits with 1 when nothing matches
+		var exiterr *exec.ExitError
+		if errors.As(err, &exiterr) && exiterr.ExitCode() == 1 {
+			return "", ErrSecretNotFound
+		}
+		return "", err
+	}
+
+	return value, nil
+}
+
+func (ss *SecretServiceStore) Set(key string, value string) error {
+	_, err := ss.run(value, "store", "--label=OpenCloudSave "+key, "application", secretServiceApplication, "key", key)
+	return err
+}
+
+func (ss *SecretServiceStore) Delete(key string) error {
+	_, err := ss.run("", "clear", "application", secretServiceApplication, "key", key)
+	return err
+}
+
+// How long the Secret Service may take to answer before the vault is used
+const secretServiceProbeTimeout = 2 * time.Second
+
+// isSecretServiceRunning reports whether a Secret Service answers on the
+// session bus. Without a keyring daemon, secret-tool fails or waits for one
+// to be started.
+func isSecretServiceRunning() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), secretServiceProbeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "secret-tool", "search", "application", secretServiceApplication)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return false
+	}
+
+	// secret-tool exits with 1 and no message when nothing matches
+	var exiterr *exec.ExitError
+	return err == nil || errors.As(err, &exiterr) && exiterr.ExitCode() == 1 && strings.TrimSpace(stderr.String()) == ""
+}
+
+func getPlatformSecretStore() SecretStore {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil
+	}
+
+	_, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return nil
+	}
+
+	if !isSecretServiceRunning() {
+		return nil
+	}
+
+	return &SecretServiceStore{}
+}
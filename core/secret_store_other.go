@@ -0,0 +1,7 @@
+//go:build !linux
+
+package core
+
+func getPlatformSecretStore() SecretStore {
+	return nil
+}
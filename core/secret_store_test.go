@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useMemorySecretStore(t *testing.T) *MemorySecretStore {
+	store := NewMemorySecretStore()
+	SetSecretStore(store)
+	t.Cleanup(func() {
+		SetSecretStore(nil)
+	})
+	return store
+}
+
+func TestPbkdf2(t *testing.T) {
+	key := pbkdf2([]byte("password"), []byte("salt"), 1, 32)
+	assert.Equal(t, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b", hex.EncodeToString(key))
+
+	key = pbkdf2([]byte("password"), []byte("salt"), 2, 32)
+	assert.Equal(t, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43", hex.EncodeToString(key))
+}
+
+func TestMemorySecretStore(t *testing.T) {
+	store := NewMemorySecretStore()
+
+	_, err := store.Get("missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	assert.NoError(t, store.Set("key", "value"))
+	value, err := store.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	assert.NoError(t, store.Delete("key"))
+	_, err = store.Get("key")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SecretVaultFilename)
+
+	locked := NewVaultSecretStore(path, "")
+	assert.True(t, locked.IsLocked())
+	assert.ErrorIs(t, locked.Set("key", "value"), ErrSecretStoreLocked, "A locked vault can not be written")
+
+	vault := NewVaultSecretStore(path, "correct horse")
+	assert.NoError(t, vault.Set("opencloudsave-ftp/pass", "hunter2"))
+	assert.NoError(t, vault.Set("opencloudsave-nextcloud/pass", "swordfish"))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2", "The vault should be encrypted")
+	assert.NotContains(t, string(data), "opencloudsave-ftp", "The vault should be encrypted")
+
+	reopened := NewVaultSecretStore(path, "correct horse")
+	value, err := reopened.Get("opencloudsave-ftp/pass")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	assert.NoError(t, reopened.Delete("opencloudsave-ftp/pass"))
+	_, err = reopened.Get("opencloudsave-ftp/pass")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	wrong := NewVaultSecretStore(path, "battery staple")
+	_, err = wrong.Get("opencloudsave-nextcloud/pass")
+	assert.ErrorIs(t, err, ErrVaultPassphrase)
+
+	_, err = locked.Get("opencloudsave-nextcloud/pass")
+	assert.ErrorIs(t, err, ErrSecretStoreLocked)
+
+	assert.ErrorIs(t, locked.Unlock("battery staple"), ErrVaultPassphrase)
+	assert.True(t, locked.IsLocked(), "A failed unlock should leave the vault locked")
+	assert.NoError(t, locked.Unlock("correct horse"))
+	value, err = locked.Get("opencloudsave-nextcloud/pass")
+	assert.NoError(t, err)
+	assert.Equal(t, "swordfish", value)
+}
+
+func TestProviderSecretsStayOutOfRcloneConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	store := useMemorySecretStore(t)
+
+	provider, err := GetStorageProvider(NEXT)
+	assert.NoError(t, err)
+
+	storage, err := provider.ApplySettings(map[string]string{
+		"url":          "https://cloud.example.com",
+		"bearer_token": "secret-token",
+	})
+	assert.NoError(t, err)
+
+	value, err := store.Get(getStorageSecretKey(storage, "bearer_token"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", value)
+
+	cmd := storage.GetCreationCommand(context.Background())
+	assert.NotContains(t, strings.Join(cmd.Args, " "), "secret-token", "Secrets should not be passed to rclone config create")
+	assert.Contains(t, cmd.Env, "RCLONE_CONFIG_OPENCLOUDSAVE_NEXTCLOUD_BEARER_TOKEN=secret-token", "Secrets should reach rclone through its environment")
+
+	DeleteStorageSecrets(storage)
+	_, err = store.Get(getStorageSecretKey(storage, "bearer_token"))
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestLockedSecretsStopHeadlessRuns(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+	path := filepath.Join(t.TempDir(), SecretVaultFilename)
+	SetSecretStore(NewVaultSecretStore(path, ""))
+	t.Cleanup(func() {
+		SetSecretStore(nil)
+	})
+
+	assert.NoError(t, CheckSecretsUnlocked(), "An empty vault holds nothing to unlock")
+
+	assert.NoError(t, NewVaultSecretStore(path, "correct horse").Set("opencloudsave-ftp/pass", "hunter2"))
+	assert.True(t, HasLockedSecrets())
+
+	ctx := context.Background()
+	dm := MakeGameDefManager(filepath.Join(t.TempDir(), UserOverrideFilename))
+	err := RunDaemon(ctx, MakeCloudManager(), dm, &DaemonOptions{SyncOptions: &Options{}}, MakeDefaultChannelProvider())
+	assert.ErrorIs(t, err, ErrSecretStoreLocked)
+	assert.Equal(t, ErrorKindVaultLocked, GetErrorKind(err))
+
+	err = RunScheduler(ctx, MakeCloudManager(), dm, &SchedulerOptions{Once: true, SyncOptions: &Options{}}, MakeDefaultChannelProvider())
+	assert.ErrorIs(t, err, ErrSecretStoreLocked)
+
+	err = ServeAPI(ctx, DefaultAPIAddress, NewAPIServer(MakeCloudManager(), dm, &Options{}, "token"), nil)
+	assert.ErrorIs(t, err, ErrSecretStoreLocked)
+
+	assert.NoError(t, UnlockSecretStore("correct horse"))
+	assert.NoError(t, CheckSecretsUnlocked())
+}
+
+func TestFallbackSecretStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SecretVaultFilename)
+	assert.NoError(t, NewVaultSecretStore(path, "correct horse").Set("opencloudsave-ftp/pass", "hunter2"))
+
+	keyring := NewMemorySecretStore()
+	vault := NewVaultSecretStore(path, "")
+	store := NewFallbackSecretStore(keyring, vault)
+	_, err := store.Get("opencloudsave-ftp/pass")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	// Secrets saved in a session without the keyring are still found
+	assert.NoError(t, vault.Unlock("correct horse"))
+	value, err := store.Get("opencloudsave-ftp/pass")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	assert.NoError(t, store.Set("opencloudsave-nextcloud/pass", "swordfish"))
+	value, err = keyring.Get("opencloudsave-nextcloud/pass")
+	assert.NoError(t, err)
+	assert.Equal(t, "swordfish", value, "New secrets should go to the keyring")
+
+	assert.NoError(t, store.Delete("opencloudsave-ftp/pass"))
+	_, err = store.Get("opencloudsave-ftp/pass")
+	assert.ErrorIs(t, err, ErrSecretNotFound, "Deleted secrets should not come back from the vault")
+}
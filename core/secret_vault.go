@@ -0,0 +1,268 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const SecretVaultFilename = "secrets.vault"
+const VaultPassphraseEnv = "OPENCLOUDSAVE_VAULT_PASSPHRASE"
+
+const vaultKeyIterations = 200000
+const vaultKeyLength = 32
+
+var ErrVaultPassphrase = errors.New("incorrect secret vault passphrase")
+
+type vaultFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// VaultSecretStore keeps secrets in a file encrypted with AES-GCM, using a
+// key derived from the user's passphrase.
+type VaultSecretStore struct {
+	mu         sync.Mutex
+	path       string
+	passphrase string
+
+	// The derived key is cached since deriving it is deliberately slow
+	salt []byte
+	key  []byte
+}
+
+func NewVaultSecretStore(path string, passphrase string) *VaultSecretStore {
+	return &VaultSecretStore{
+		path:       path,
+		passphrase: passphrase,
+	}
+}
+
+func NewDefaultVaultSecretStore() *VaultSecretStore {
+	path := ""
+	dir, err := getCloudPerfDir()
+	if err == nil {
+		path = filepath.Join(dir, SecretVaultFilename)
+	}
+
+	return NewVaultSecretStore(path, os.Getenv(VaultPassphraseEnv))
+}
+
+// pbkdf2 derives a key from password as defined in RFC 8018, using
+// HMAC-SHA256 as the pseudorandom function.
+func pbkdf2(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+
+		t := key[len(key)-hashLength:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+
+	return key[:keyLength]
+}
+
+func (vs *VaultSecretStore) getCipher(salt []byte) (cipher.AEAD, error) {
+	if vs.key == nil || !bytes.Equal(vs.salt, salt) {
+		vs.salt = salt
+		vs.key = pbkdf2([]byte(vs.passphrase), salt, vaultKeyIterations, vaultKeyLength)
+	}
+
+	block, err := aes.NewCipher(vs.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (vs *VaultSecretStore) IsLocked() bool {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	return vs.passphrase == ""
+}
+
+// Unlock sets the passphrase. If the vault already exists the passphrase is
+// checked against it.
+func (vs *VaultSecretStore) Unlock(passphrase string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	defer invalidateSecretEnvironment()
+
+	previous := vs.passphrase
+	vs.passphrase = passphrase
+	vs.key = nil
+	_, err := vs.read()
+	if err != nil {
+		vs.passphrase = previous
+		vs.key = nil
+		return err
+	}
+
+	return nil
+}
+
+func (vs *VaultSecretStore) exists() bool {
+	_, err := os.Stat(vs.path)
+	return err == nil
+}
+
+func (vs *VaultSecretStore) read() (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := os.ReadFile(vs.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if vs.passphrase == "" {
+		return nil, ErrSecretStoreLocked
+	}
+
+	vault := &vaultFile{}
+	err = json.Unmarshal(data, vault)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := vs.getCipher(vault.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, vault.Nonce, vault.Data, nil)
+	if err != nil {
+		return nil, ErrVaultPassphrase
+	}
+
+	err = json.Unmarshal(plaintext, &secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+func (vs *VaultSecretStore) write(secrets map[string]string) error {
+	if vs.passphrase == "" {
+		return ErrSecretStoreLocked
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	vault := &vaultFile{
+		Salt: vs.salt,
+	}
+	if vs.key == nil {
+		vault.Salt = make([]byte, 16)
+		_, err = rand.Read(vault.Salt)
+		if err != nil {
+			return err
+		}
+	}
+
+	aead, err := vs.getCipher(vault.Salt)
+	if err != nil {
+		return err
+	}
+
+	vault.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(vault.Nonce)
+	if err != nil {
+		return err
+	}
+	vault.Data = aead.Seal(nil, vault.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(vault)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(vs.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(vs.path, data, 0600)
+}
+
+func (vs *VaultSecretStore) Get(key string) (string, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	secrets, err := vs.read()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (vs *VaultSecretStore) Set(key string, value string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	secrets, err := vs.read()
+	if err != nil {
+		return err
+	}
+
+	secrets[key] = value
+	return vs.write(secrets)
+}
+
+func (vs *VaultSecretStore) Delete(key string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	secrets, err := vs.read()
+	if err != nil {
+		return err
+	}
+
+	_, ok := secrets[key]
+	if !ok {
+		return nil
+	}
+
+	delete(secrets, key)
+	return vs.write(secrets)
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const SessionsFilename = "sessions.json"
+
+// Older sessions are dropped from the record
+const maxRecordedSessions = 100
+
+const DefaultSessionScanInterval = 2 * time.Second
+
+// How often the matchers are built again, in scans, to pick up games that
+// were installed since
+const sessionMatcherRefreshScans = 30
+
+const (
+	SessionSyncDone    = "synced"
+	SessionSyncSkipped = "skipped"
+	SessionSyncFailed  = "failed"
+)
+
+// SessionSync is the result of the sync before or after a game session.
+type SessionSync struct {
+	Result    string `json:"result"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"errorKind,omitempty"`
+	Bytes     int64  `json:"bytes"`
+	Files     int    `json:"files"`
+}
+
+// GameSession is a time a game was running, along with the syncs around it.
+type GameSession struct {
+	Game    string       `json:"game"`
+	Pids    []int        `json:"pids,omitempty"`
+	Started time.Time    `json:"started"`
+	Ended   *time.Time   `json:"ended,omitempty"`
+	Before  *SessionSync `json:"before,omitempty"`
+	After   *SessionSync `json:"after,omitempty"`
+}
+
+type SessionOptions struct {
+	// How often running processes are looked for
+	ScanInterval time.Duration
+	// Passed on to RequestMainOperation for every sync
+	SyncOptions *Options
+}
+
+var sessionsMtx sync.Mutex
+
+func getSessionsPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + SessionsFilename, nil
+}
+
+// GetSessions returns the recorded sessions, oldest first.
+func GetSessions() ([]*GameSession, error) {
+	sessionsMtx.Lock()
+	defer sessionsMtx.Unlock()
+	return readSessions()
+}
+
+func readSessions() ([]*GameSession, error) {
+	result := []*GameSession{}
+	path, err := getSessionsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RecordSession adds session to the recorded sessions.
+func RecordSession(session *GameSession) error {
+	sessionsMtx.Lock()
+	defer sessionsMtx.Unlock()
+
+	sessions, err := readSessions()
+	if err != nil {
+		return err
+	}
+
+	sessions = append(sessions, session)
+	if len(sessions) > maxRecordedSessions {
+		sessions = sessions[len(sessions)-maxRecordedSessions:]
+	}
+
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getSessionsPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func skippedSessionSync(reason string) *SessionSync {
+	return &SessionSync{Result: SessionSyncSkipped, Reason: reason}
+}
+
+// syncForSession syncs gamename and summarizes the sync. Its messages are
+// passed on to logs.
+func syncForSession(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *Options, gamename string, logs chan Message) *SessionSync {
+	syncops := *ops
+	syncops.Gamenames = []string{gamename}
+	syncops.LeaseWait = DefaultLeaseWait
+	// Makes rclone report how much was transferred, which is recorded
+	syncops.Output = OutputJSON
+
+	channels := MakeDefaultChannelProvider()
+	go func() {
+		RequestMainOperation(ctx, cm, &syncops, dm, channels)
+		close(channels.Logs)
+	}()
+
+	result := &SessionSync{Result: SessionSyncDone}
+	for msg := range channels.Logs {
+		logs <- msg
+
+		if msg.Err != nil && result.Result != SessionSyncFailed {
+			result.Result = SessionSyncFailed
+			result.Error = msg.Err.Error()
+			result.ErrorKind = GetErrorKind(msg.Err)
+		}
+
+		if msg.Event != nil && msg.Event.Phase == PhaseDone {
+			result.Bytes += msg.Event.Bytes
+			result.Files += msg.Event.Files
+		}
+	}
+
+	return result
+}
+
+// SyncBeforeSession downloads the saves of gamename when the cloud has newer
+// ones. It is skipped when the local saves changed as well, as the sync
+// would have to pick which ones to keep.
+func SyncBeforeSession(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *Options, gamename string, logs chan Message) *SessionSync {
+	status := GetSyncStatus(ctx, cm, dm, gamename)
+	if status.Error != "" {
+		return &SessionSync{Result: SessionSyncFailed, Error: status.Error, ErrorKind: status.ErrorKind}
+	}
+
+	switch status.Status {
+	case SyncStatusRemoteNewer, SyncStatusRemoteOnly:
+		LogMessage(logs, "Downloading the newer saves of %v", gamename)
+		return syncForSession(ctx, cm, dm, ops, gamename, logs)
+	case SyncStatusBothChanged:
+		return skippedSessionSync("the saves changed locally and in the cloud, sync manually to keep the right ones")
+	default:
+		return skippedSessionSync("the cloud has no newer saves")
+	}
+}
+
+// SyncAfterSession uploads the saves of gamename once it exited.
+func SyncAfterSession(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *Options, gamename string, logs chan Message) *SessionSync {
+	LogMessage(logs, "Uploading the saves of %v", gamename)
+	return syncForSession(ctx, cm, dm, ops, gamename, logs)
+}
+
+func endSession(session *GameSession, after *SessionSync) {
+	now := time.Now()
+	session.Ended = &now
+	session.After = after
+
+	err := RecordSession(session)
+	if err != nil {
+		ErrorLogger.Println(err)
+	}
+}
+
+// WatchSessions looks for the processes of the tracked games. When a game
+// starts, newer saves are downloaded from the cloud, and once it exits its
+// saves are uploaded. Every session is recorded, see GetSessions. Messages
+// of the syncs are sent to channels. WatchSessions returns once ctx is done.
+func WatchSessions(ctx context.Context, cm *CloudManager, dm GameDefManager, ops *SessionOptions, channels *ChannelProvider) error {
+	if GetCurrentStorageProvider() == nil {
+		return WithErrorKind(ErrorKindNoCloud, ErrNoCloud)
+	}
+
+	err := CheckSecretsUnlocked()
+	if err != nil {
+		return err
+	}
+
+	logs := channels.Logs
+	// A sync that started is finished even when watching is stopped
+	syncCtx := context.Background()
+	var matchers []*sessionMatcher
+	open := make(map[string]*GameSession)
+	for scan := 0; ; scan++ {
+		if scan%sessionMatcherRefreshScans == 0 {
+			matchers = getSessionMatchers(dm)
+			if scan == 0 {
+				LogMessage(logs, "Watching for %v games to start", len(matchers))
+			}
+		}
+
+		running := findRunningGames(matchers)
+		games := []string{}
+		for game := range running {
+			games = append(games, game)
+		}
+		sort.Strings(games)
+
+		for _, game := range games {
+			session, ok := open[game]
+			if ok {
+				session.Pids = mergePids(session.Pids, running[game])
+				continue
+			}
+
+			session = &GameSession{
+				Game:    game,
+				Pids:    running[game],
+				Started: time.Now(),
+			}
+			open[game] = session
+
+			if scan == 0 {
+				// It may have read its saves already
+				LogMessage(logs, "%v was already running", game)
+				session.Before = skippedSessionSync("the game was already running")
+				continue
+			}
+
+			LogMessage(logs, "%v started", game)
+			session.Before = SyncBeforeSession(syncCtx, cm, dm, ops.SyncOptions, game, logs)
+		}
+
+		for game, session := range open {
+			if _, ok := running[game]; ok {
+				continue
+			}
+
+			LogMessage(logs, "%v exited", game)
+			endSession(session, SyncAfterSession(syncCtx, cm, dm, ops.SyncOptions, game, logs))
+			delete(open, game)
+		}
+
+		select {
+		case <-ctx.Done():
+			for game, session := range open {
+				LogMessage(logs, "%v is still running, its saves are not uploaded", game)
+				endSession(session, skippedSessionSync("stopped watching while the game was running"))
+			}
+			return nil
+		case <-time.After(ops.ScanInterval):
+		}
+	}
+}
+
+func mergePids(pids []int, more []int) []int {
+	seen := make(map[int]bool)
+	for _, pid := range pids {
+		seen[pid] = true
+	}
+
+	for _, pid := range more {
+		if !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+func (s *SessionSync) String() string {
+	switch s.Result {
+	case SessionSyncSkipped:
+		return fmt.Sprintf("skipped, %v", s.Reason)
+	case SessionSyncFailed:
+		return fmt.Sprintf("failed: %v", s.Error)
+	default:
+		return fmt.Sprintf("synced %v files, %v bytes", s.Files, s.Bytes)
+	}
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andygrunwald/vdf"
+)
+
+// Where running processes are listed, replaced by tests
+var procRoot = "/proc"
+
+// Environment variables Proton points at the prefix of the running game,
+// which is kept in steamapps/compatdata/<appid>
+var compatDataVariables = []string{"STEAM_COMPAT_DATA_PATH=", "WINEPREFIX="}
+
+// sessionMatcher tells whether a process belongs to a game.
+type sessionMatcher struct {
+	game        string
+	installDirs []string
+	appId       string
+}
+
+// getSessionMatchers returns the matchers of the tracked games that have an
+// install folder or a Steam app id.
+func getSessionMatchers(dm GameDefManager) []*sessionMatcher {
+	steamRoots := getSteamRoots()
+	matchers := []*sessionMatcher{}
+	for gamename, gamedef := range dm.GetGameDefMap() {
+		if gamedef.Hidden {
+			continue
+		}
+
+		matcher := &sessionMatcher{
+			game:  gamename,
+			appId: strings.TrimSpace(gamedef.SteamId),
+		}
+		if gamedef.InstallPath != "" {
+			matcher.installDirs = append(matcher.installDirs, resolvePath(gamedef.InstallPath))
+		}
+		if matcher.appId != "" {
+			matcher.installDirs = append(matcher.installDirs, getSteamInstallDirs(steamRoots, matcher.appId)...)
+		}
+
+		if len(matcher.installDirs) > 0 || matcher.appId != "" {
+			matchers = append(matchers, matcher)
+		}
+	}
+
+	return matchers
+}
+
+// resolvePath resolves symlinks in path, as /proc lists resolved paths.
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	return resolved
+}
+
+// getSteamInstallDirs returns the folders Steam installed appId to, read
+// from the app manifests of every library.
+func getSteamInstallDirs(steamRoots []string, appId string) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, root := range steamRoots {
+		libraries, err := readSteamLibraries(root)
+		if err != nil {
+			continue
+		}
+
+		for _, library := range libraries {
+			installDir, err := readSteamInstallDir(library, appId)
+			if err != nil {
+				continue
+			}
+
+			path := resolvePath(filepath.Join(library, "steamapps", "common", installDir))
+			if !seen[path] {
+				seen[path] = true
+				result = append(result, path)
+			}
+		}
+	}
+
+	return result
+}
+
+func readSteamInstallDir(library string, appId string) (string, error) {
+	file, err := os.Open(filepath.Join(library, "steamapps", fmt.Sprintf("appmanifest_%v.acf", appId)))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	manifestMap, err := vdf.NewParser(file).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(manifestMap)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := AppManifest{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return "", err
+	}
+
+	if manifest.AppState.InstallDir == "" {
+		return "", fmt.Errorf("app manifest of %v has no install folder", appId)
+	}
+
+	return manifest.AppState.InstallDir, nil
+}
+
+func isBelow(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+func isCompatDataOf(path string, appId string) bool {
+	path = filepath.ToSlash(path)
+	marker := "/compatdata/" + appId
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), marker) ||
+		strings.Contains(path, marker+"/")
+}
+
+// matches tells whether a process with the executable exe, the arguments
+// args and the environment env belongs to the game.
+func (m *sessionMatcher) matches(exe string, args []string, env []string) bool {
+	for _, dir := range m.installDirs {
+		if isBelow(exe, dir) {
+			return true
+		}
+		for _, arg := range args {
+			if isBelow(arg, dir) {
+				return true
+			}
+		}
+	}
+
+	if m.appId == "" {
+		return false
+	}
+
+	// Games run by Proton are started by Wine, so their executable is not
+	// in their install folder
+	for _, arg := range args {
+		if isCompatDataOf(arg, m.appId) {
+			return true
+		}
+	}
+	for _, variable := range env {
+		for _, prefix := range compatDataVariables {
+			if strings.HasPrefix(variable, prefix) && isCompatDataOf(strings.TrimPrefix(variable, prefix), m.appId) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func splitNul(data []byte) []string {
+	result := []string{}
+	for _, part := range bytes.Split(data, []byte{0}) {
+		if len(part) > 0 {
+			result = append(result, string(part))
+		}
+	}
+	return result
+}
+
+// findRunningGames returns the ids of the processes of every running game,
+// by the games. Processes of other users can not be read and are skipped.
+func findRunningGames(matchers []*sessionMatcher) map[string][]int {
+	result := make(map[string][]int)
+	if len(matchers) == 0 {
+		return result
+	}
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return result
+	}
+
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		dir := filepath.Join(procRoot, entry.Name())
+		exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+		cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+		environ, _ := os.ReadFile(filepath.Join(dir, "environ"))
+		if exe == "" && len(cmdline) == 0 && len(environ) == 0 {
+			continue
+		}
+
+		args := splitNul(cmdline)
+		env := splitNul(environ)
+		for _, matcher := range matchers {
+			if matcher.matches(exe, args, env) {
+				result[matcher.game] = append(result[matcher.game], pid)
+			}
+		}
+	}
+
+	return result
+}
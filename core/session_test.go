@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useFakeProc(t *testing.T) string {
+	dir := t.TempDir()
+	previous := procRoot
+	procRoot = dir
+	t.Cleanup(func() {
+		procRoot = previous
+	})
+	return dir
+}
+
+func addFakeProcess(t *testing.T, proc string, pid string, exe string, args []string, env []string) {
+	dir := filepath.Join(proc, pid)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	if exe != "" {
+		assert.NoError(t, os.Symlink(exe, filepath.Join(dir, "exe")))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(args, "\x00")+"\x00"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "environ"), []byte(strings.Join(env, "\x00")+"\x00"), 0644))
+}
+
+func TestFindRunningGames(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	steam := filepath.Join(home, ".local", "share", "Steam")
+	assert.NoError(t, os.MkdirAll(filepath.Join(steam, "steamapps", "common", "Celeste"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(steam, "steamapps", "libraryfolders.vdf"), []byte(`"libraryfolders"
+{
+	"0"
+	{
+		"path"		"`+steam+`"
+	}
+}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(steam, "steamapps", "appmanifest_504230.acf"), []byte(`"AppState"
+{
+	"appid"		"504230"
+	"installdir"		"Celeste"
+}`), 0644))
+
+	installDirs := getSteamInstallDirs(getSteamRoots(), "504230")
+	assert.Equal(t, []string{resolvePath(filepath.Join(steam, "steamapps", "common", "Celeste"))}, installDirs)
+	assert.Empty(t, getSteamInstallDirs(getSteamRoots(), "1"))
+
+	hollow := t.TempDir()
+	matchers := []*sessionMatcher{
+		{game: "Celeste", installDirs: installDirs, appId: "504230"},
+		{game: "Hades", appId: "1145360"},
+		{game: "Hollow", installDirs: []string{hollow}},
+	}
+
+	proc := useFakeProc(t)
+	addFakeProcess(t, proc, "100", filepath.Join(installDirs[0], "Celeste.bin.x86_64"), []string{"./Celeste.bin.x86_64"}, nil)
+	addFakeProcess(t, proc, "200", "/usr/bin/wine64-preloader", []string{"Z:\\Hades.exe"},
+		[]string{"HOME=" + home, "STEAM_COMPAT_DATA_PATH=" + filepath.Join(steam, "steamapps", "compatdata", "1145360")})
+	addFakeProcess(t, proc, "201", "/usr/bin/wineserver", []string{"wineserver"},
+		[]string{"WINEPREFIX=" + filepath.Join(steam, "steamapps", "compatdata", "1145360", "pfx")})
+	addFakeProcess(t, proc, "300", "/usr/bin/mono", []string{"mono", filepath.Join(hollow, "hollow.exe")}, nil)
+	addFakeProcess(t, proc, "400", "/usr/bin/wine64-preloader", []string{"Z:\\Other.exe"},
+		[]string{"STEAM_COMPAT_DATA_PATH=" + filepath.Join(steam, "steamapps", "compatdata", "11453600")})
+	addFakeProcess(t, proc, "self", "/usr/bin/bash", []string{"bash"}, nil)
+
+	running := findRunningGames(matchers)
+	assert.Equal(t, map[string][]int{
+		"Celeste": {100},
+		"Hades":   {200, 201},
+		"Hollow":  {300},
+	}, running)
+}
+
+func TestWatchSessions(t *testing.T) {
+	install := t.TempDir()
+	hollow := testGameDef("Hollow", t.TempDir())
+	hollow.InstallPath = install
+	dm := setupRunGame(t, fakeSyncRclone, hollow)
+
+	proc := useFakeProc(t)
+	ops := &SessionOptions{ScanInterval: 50 * time.Millisecond, SyncOptions: &Options{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channels := MakeDefaultChannelProvider()
+	stopped := make(chan error)
+	go func() {
+		stopped <- WatchSessions(ctx, MakeCloudManager(), dm, ops, channels)
+		close(channels.Logs)
+	}()
+
+	var mtx sync.Mutex
+	messages := []string{}
+	go func() {
+		for msg := range channels.Logs {
+			mtx.Lock()
+			messages = append(messages, msg.Message)
+			mtx.Unlock()
+		}
+	}()
+	logged := func(message string, times int) func() bool {
+		return func() bool {
+			mtx.Lock()
+			defer mtx.Unlock()
+			count := 0
+			for _, m := range messages {
+				if strings.HasPrefix(m, message) {
+					count++
+				}
+			}
+			return count >= times
+		}
+	}
+
+	assert.Eventually(t, logged("Watching for", 1), 5*time.Second, 10*time.Millisecond)
+	addFakeProcess(t, proc, "100", filepath.Join(install, "hollow"), []string{"./hollow"}, nil)
+	assert.Eventually(t, logged("Hollow started", 1), 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.RemoveAll(filepath.Join(proc, "100")))
+	assert.Eventually(t, logged("Hollow exited", 1), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		sessions, err := GetSessions()
+		return err == nil && len(sessions) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Sessions still open when stopping are recorded without uploading
+	addFakeProcess(t, proc, "200", filepath.Join(install, "hollow"), []string{"./hollow"}, nil)
+	assert.Eventually(t, logged("Hollow started", 2), 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	assert.NoError(t, <-stopped)
+
+	sessions, err := GetSessions()
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	first := sessions[0]
+	assert.Equal(t, "Hollow", first.Game)
+	assert.Equal(t, []int{100}, first.Pids)
+	assert.NotNil(t, first.Ended)
+	assert.Equal(t, &SessionSync{Result: SessionSyncSkipped, Reason: "the cloud has no newer saves"}, first.Before)
+	assert.Equal(t, &SessionSync{Result: SessionSyncDone, Bytes: 3072, Files: 4}, first.After)
+
+	second := sessions[1]
+	assert.Equal(t, []int{200}, second.Pids)
+	assert.Equal(t, SessionSyncSkipped, second.After.Result)
+}
+
+func TestRecordSessionKeepsLatest(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for i := 0; i < maxRecordedSessions+5; i++ {
+		assert.NoError(t, RecordSession(&GameSession{Game: "Celeste", Pids: []int{i}}))
+	}
+
+	sessions, err := GetSessions()
+	assert.NoError(t, err)
+	assert.Len(t, sessions, maxRecordedSessions)
+	assert.Equal(t, []int{5}, sessions[0].Pids)
+}
@@ -2,7 +2,10 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
+	"path"
+	"strings"
 )
 
 type Storage interface {
@@ -10,13 +13,53 @@ type Storage interface {
 	GetCreationCommand(ctx context.Context) *exec.Cmd
 }
 
+// UpdatableStorage is implemented by storages whose rclone remote can be
+// reconfigured in place with `rclone config update`.
+type UpdatableStorage interface {
+	Storage
+	GetUpdateCommand(ctx context.Context) *exec.Cmd
+}
+
+// PrefixedStorage is implemented by storages that keep all data below a
+// folder of the remote.
+type PrefixedStorage interface {
+	Storage
+	GetPathPrefix() string
+}
+
+// ExternalStorage is implemented by storages whose rclone remote belongs to
+// the user. OpenCloudSave must never delete such a remote.
+type ExternalStorage interface {
+	Storage
+	IsExternal() bool
+}
+
+func isExternalStorage(storage Storage) bool {
+	external, ok := storage.(ExternalStorage)
+	return ok && external.IsExternal()
+}
+
+// getRemoteLocation returns the rclone path of remotePath on storage.
+func getRemoteLocation(storage Storage, remotePath string) string {
+	prefixed, ok := storage.(PrefixedStorage)
+	if ok && prefixed.GetPathPrefix() != "" {
+		trailingSlash := strings.HasSuffix(remotePath, "/")
+		remotePath = path.Join(prefixed.GetPathPrefix(), remotePath)
+		if trailingSlash {
+			remotePath += "/"
+		}
+	}
+
+	return fmt.Sprintf("%v:%v", storage.GetName(), remotePath)
+}
+
+// GetAllStorageProviders returns the active storage of every registered
+// provider.
 func GetAllStorageProviders() []Storage {
-	return []Storage{
-		GetGoogleDriveStorage(),
-		GetDropBoxStorage(),
-		GetOneDriveStorage(),
-		GetBoxStorage(),
-		GetFtpDriveStorage(),
-		GetNextCloudStorage(),
+	result := []Storage{}
+	for _, provider := range GetConfiguredStorageProviders() {
+		result = append(result, provider.Storage())
 	}
+
+	return result
 }
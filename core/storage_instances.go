@@ -0,0 +1,439 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const StorageInstancesFilename = "storage_instances.json"
+
+// StorageInstance is a named account of a storage provider, e.g. a personal
+// and a family Google Drive. Every instance has its own rclone remote.
+//
+// The instance whose Id equals its provider's Id is the provider's default
+// account. It keeps the provider's original remote, so clouds set up before
+// named accounts existed keep working. It only has an entry here once it has
+// been renamed. Instances hold no secrets and are synced along with the games
+// referring to them.
+type StorageInstance struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+}
+
+var storageInstancesMtx sync.Mutex
+
+var instanceStorageMtx sync.Mutex
+var instanceStorages = make(map[string]Storage)
+
+var instanceIdSanitizer = regexp.MustCompile("[^a-z0-9]+")
+
+func getStorageInstancesPath() (string, error) {
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + StorageInstancesFilename, nil
+}
+
+func readStorageInstances() ([]*StorageInstance, error) {
+	result := []*StorageInstance{}
+	path, err := getStorageInstancesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func writeStorageInstances(instances []*StorageInstance) error {
+	defer invalidateSecretEnvironment()
+
+	data, err := json.Marshal(instances)
+	if err != nil {
+		return err
+	}
+
+	dir, err := getCloudPerfDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path, err := getStorageInstancesPath()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func getPersistedStorageInstances() []*StorageInstance {
+	storageInstancesMtx.Lock()
+	defer storageInstancesMtx.Unlock()
+
+	instances, err := readStorageInstances()
+	if err != nil {
+		if ErrorLogger != nil {
+			ErrorLogger.Println(err)
+		}
+		return []*StorageInstance{}
+	}
+
+	return instances
+}
+
+func getRegisteredStorageProvider(id string) (*StorageProvider, error) {
+	for _, provider := range GetStorageProviders() {
+		if provider.Id == id {
+			return provider, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown cloud provider %v", id)
+}
+
+// GetStorageInstances returns the named accounts, along with the default
+// accounts currently used as the primary or secondary cloud.
+func GetStorageInstances() []*StorageInstance {
+	instances := getPersistedStorageInstances()
+	cloudperfs := GetCurrentCloudPerfsOrDefault()
+	for _, id := range []string{cloudperfs.Cloud, cloudperfs.SecondaryCloud} {
+		if id == "" || findStorageInstance(instances, id) != nil {
+			continue
+		}
+
+		provider, err := getRegisteredStorageProvider(id)
+		if err != nil {
+			continue
+		}
+
+		instances = append(instances, &StorageInstance{
+			Id:       provider.Id,
+			Name:     provider.DisplayName,
+			Provider: provider.Id,
+		})
+	}
+
+	return instances
+}
+
+func findStorageInstance(instances []*StorageInstance, id string) *StorageInstance {
+	for _, instance := range instances {
+		if instance.Id == id {
+			return instance
+		}
+	}
+
+	return nil
+}
+
+func getInstanceStorage(id string) Storage {
+	instanceStorageMtx.Lock()
+	defer instanceStorageMtx.Unlock()
+
+	return instanceStorages[id]
+}
+
+func setInstanceStorage(id string, storage Storage) {
+	instanceStorageMtx.Lock()
+	defer instanceStorageMtx.Unlock()
+
+	if storage == nil {
+		delete(instanceStorages, id)
+	} else {
+		instanceStorages[id] = storage
+	}
+}
+
+// getProvider returns a StorageProvider bound to the instance. Its Id is the
+// instance id, so settings and secrets are kept per instance.
+func (instance *StorageInstance) getProvider() (*StorageProvider, error) {
+	base, err := getRegisteredStorageProvider(instance.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := *base
+	provider.Id = instance.Id
+	provider.DisplayName = instance.Name
+	if instance.Id == base.Id {
+		return &provider, nil
+	}
+
+	if base.NewStorage == nil {
+		return nil, fmt.Errorf("%v does not support more than one account", base.DisplayName)
+	}
+
+	remote := remoteNamePrefix + instance.Id
+	provider.Configure = func(settings map[string]string) (Storage, error) {
+		storage := base.NewStorage(remote, settings)
+		setInstanceStorage(instance.Id, storage)
+		return storage, nil
+	}
+	provider.Storage = func() Storage {
+		storage := getInstanceStorage(instance.Id)
+		if storage == nil {
+			storage = base.NewStorage(remote, GetProviderSettings(instance.Id))
+			setInstanceStorage(instance.Id, storage)
+		}
+		return storage
+	}
+
+	return &provider, nil
+}
+
+// GetConfiguredStorageProviders returns every registered provider followed
+// by a provider for each named account.
+func GetConfiguredStorageProviders() []*StorageProvider {
+	instances := getPersistedStorageInstances()
+	result := []*StorageProvider{}
+	for _, provider := range GetStorageProviders() {
+		instance := findStorageInstance(instances, provider.Id)
+		if instance != nil {
+			renamed, err := instance.getProvider()
+			if err == nil {
+				provider = renamed
+			}
+		}
+		result = append(result, provider)
+	}
+
+	for _, instance := range instances {
+		if instance.Id == instance.Provider {
+			continue
+		}
+
+		provider, err := instance.getProvider()
+		if err != nil {
+			if ErrorLogger != nil {
+				ErrorLogger.Println(err)
+			}
+			continue
+		}
+		result = append(result, provider)
+	}
+
+	return result
+}
+
+func makeStorageInstanceId(provider string, name string) string {
+	slug := instanceIdSanitizer.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = "account"
+	}
+
+	return provider + "-" + slug
+}
+
+func validateStorageInstanceName(instances []*StorageInstance, id string, name string) error {
+	if name == "" {
+		return fmt.Errorf("an account needs a name")
+	}
+
+	for _, instance := range instances {
+		if instance.Id != id && strings.EqualFold(instance.Name, name) {
+			return fmt.Errorf("an account named %v already exists", name)
+		}
+	}
+
+	return nil
+}
+
+// AddStorageInstance creates a named account of a provider. Its remote is
+// created by the first sync of a game using it, see RequestMainOperation.
+func AddStorageInstance(providerId string, name string) (*StorageInstance, error) {
+	name = strings.TrimSpace(name)
+	provider, err := getRegisteredStorageProvider(providerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.NewStorage == nil {
+		return nil, fmt.Errorf("%v does not support more than one account", provider.DisplayName)
+	}
+
+	storageInstancesMtx.Lock()
+	defer storageInstancesMtx.Unlock()
+
+	instances, err := readStorageInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateStorageInstanceName(instances, "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	baseId := makeStorageInstanceId(provider.Id, name)
+	id := baseId
+	for i := 2; findStorageInstance(instances, id) != nil; i++ {
+		id = fmt.Sprintf("%v-%v", baseId, i)
+	}
+
+	instance := &StorageInstance{
+		Id:       id,
+		Name:     name,
+		Provider: provider.Id,
+	}
+
+	return instance, writeStorageInstances(append(instances, instance))
+}
+
+// RenameStorageInstance changes the name of an account. The id, and with it
+// the rclone remote, stays the same.
+func RenameStorageInstance(id string, name string) error {
+	name = strings.TrimSpace(name)
+	storageInstancesMtx.Lock()
+	defer storageInstancesMtx.Unlock()
+
+	instances, err := readStorageInstances()
+	if err != nil {
+		return err
+	}
+
+	err = validateStorageInstanceName(instances, id, name)
+	if err != nil {
+		return err
+	}
+
+	instance := findStorageInstance(instances, id)
+	if instance == nil {
+		provider, err := getRegisteredStorageProvider(id)
+		if err != nil {
+			return err
+		}
+
+		instance = &StorageInstance{
+			Id:       provider.Id,
+			Provider: provider.Id,
+		}
+		instances = append(instances, instance)
+	}
+
+	instance.Name = name
+	return writeStorageInstances(instances)
+}
+
+// RemoveStorageInstance deletes an account along with its rclone remote,
+// settings and secrets. Accounts in use as the primary or secondary cloud
+// can not be removed.
+func RemoveStorageInstance(ctx context.Context, cm *CloudManager, id string) error {
+	cloudperfs := GetCurrentCloudPerfsOrDefault()
+	if id == cloudperfs.Cloud || id == cloudperfs.SecondaryCloud {
+		return fmt.Errorf("account %v is in use, please pick another cloud first", id)
+	}
+
+	provider, err := GetStorageProvider(id)
+	if err != nil {
+		return err
+	}
+
+	err = cm.DeleteCloudEntry(ctx, provider.Storage())
+	if err != nil && cm.ContainsStorageDrive(ctx, provider.Storage()) {
+		return err
+	}
+
+	err = deleteProviderSettings(id)
+	if err != nil {
+		return err
+	}
+
+	storageInstancesMtx.Lock()
+	defer storageInstancesMtx.Unlock()
+
+	instances, err := readStorageInstances()
+	if err != nil {
+		return err
+	}
+
+	remaining := []*StorageInstance{}
+	for _, instance := range instances {
+		if instance.Id != id {
+			remaining = append(remaining, instance)
+		}
+	}
+
+	setInstanceStorage(id, nil)
+	return writeStorageInstances(remaining)
+}
+
+// GetGameStorage returns the account a game syncs to. Games without an
+// account of their own use the current cloud, as do games whose account is
+// unknown to this device.
+func GetGameStorage(gamedef *GameDef) (Storage, error) {
+	cloudperfs, err := GetCurrentCloudPerfs()
+	if err != nil {
+		return nil, err
+	}
+
+	if gamedef == nil || gamedef.Storage == "" || gamedef.Storage == cloudperfs.Cloud {
+		return GetCloudStorage(cloudperfs.Cloud)
+	}
+
+	storage, err := GetCloudStorage(gamedef.Storage)
+	if err != nil {
+		if WarnLogger != nil {
+			WarnLogger.Printf("Syncing to %v instead of %v: %v", cloudperfs.Cloud, gamedef.Storage, err)
+		}
+		return GetCloudStorage(cloudperfs.Cloud)
+	}
+
+	return storage, nil
+}
+
+// GetOrAddStorageInstance returns the account of a provider with the given
+// name, adding it when it does not exist yet.
+func GetOrAddStorageInstance(providerId string, name string) (*StorageInstance, error) {
+	for _, instance := range getPersistedStorageInstances() {
+		if instance.Provider == providerId && strings.EqualFold(instance.Name, strings.TrimSpace(name)) {
+			return instance, nil
+		}
+	}
+
+	return AddStorageInstance(providerId, name)
+}
+
+// SetGameStorage sets the account a game syncs to. An empty id makes the
+// game use the current cloud again.
+func SetGameStorage(dm GameDefManager, gamename string, id string) error {
+	gamedef, ok := dm.GetGameDefMap()[gamename]
+	if !ok {
+		return fmt.Errorf("failed to find game (%v)", gamename)
+	}
+
+	if id != "" {
+		_, err := GetStorageProvider(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	gamedef.Storage = id
+	return dm.CommitUserOverrides()
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageInstances(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+	store := useMemorySecretStore(t)
+
+	family, err := AddStorageInstance(GOOGLE, "Family Drive")
+	assert.NoError(t, err)
+	assert.Equal(t, "google-family-drive", family.Id)
+
+	_, err = AddStorageInstance(GOOGLE, "family drive")
+	assert.Error(t, err, "Account names should be unique")
+
+	_, err = AddStorageInstance(EXTERNAL, "Other")
+	assert.Error(t, err, "Providers without NewStorage only support one account")
+
+	provider, err := GetStorageProvider(family.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Family Drive", provider.DisplayName)
+	assert.Equal(t, "opencloudsave-google-family-drive", provider.Storage().GetName())
+	assert.Equal(t, "opencloudsave-googledrive", GetGoogleDriveStorage().GetName(), "The default account should keep its remote")
+
+	work, err := AddStorageInstance(NEXT, "Work")
+	assert.NoError(t, err)
+	workProvider, err := GetStorageProvider(work.Id)
+	assert.NoError(t, err)
+	storage, err := workProvider.ApplySettings(map[string]string{
+		"url":          "https://work.example.com",
+		"bearer_token": "work-token",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "opencloudsave-nextcloud-work", storage.GetName())
+	assert.Equal(t, "https://work.example.com", GetProviderSettings(work.Id)["url"], "Settings should be kept per account")
+	assert.Equal(t, map[string]string{}, GetProviderSettings(NEXT))
+	assert.Contains(t, getSecretEnvironment(), "RCLONE_CONFIG_OPENCLOUDSAVE_NEXTCLOUD_WORK_BEARER_TOKEN=work-token")
+
+	assert.NoError(t, RenameStorageInstance(GOOGLE, "Personal Drive"))
+	provider, err = GetStorageProvider(GOOGLE)
+	assert.NoError(t, err)
+	assert.Equal(t, "Personal Drive", provider.DisplayName)
+	assert.Equal(t, "opencloudsave-googledrive", provider.Storage().GetName(), "Renaming should not change the remote")
+	assert.Error(t, RenameStorageInstance(family.Id, "personal drive"))
+
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: GOOGLE}))
+	gameStorage, err := GetGameStorage(&GameDef{Storage: family.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, "opencloudsave-google-family-drive", gameStorage.GetName())
+	gameStorage, err = GetGameStorage(&GameDef{})
+	assert.NoError(t, err)
+	assert.Equal(t, "opencloudsave-googledrive", gameStorage.GetName(), "Games without an account should use the current cloud")
+	gameStorage, err = GetGameStorage(&GameDef{Storage: "google-elsewhere"})
+	assert.NoError(t, err)
+	assert.Equal(t, "opencloudsave-googledrive", gameStorage.GetName(), "Games with an account unknown to this device should use the current cloud")
+
+	cm := MakeCloudManager()
+	assert.Error(t, RemoveStorageInstance(context.Background(), cm, GOOGLE), "The current cloud can not be removed")
+	assert.NoError(t, RemoveStorageInstance(context.Background(), cm, family.Id))
+	_, err = GetStorageProvider(family.Id)
+	assert.Error(t, err, "Removed accounts should no longer resolve")
+
+	err = store.Set(getStorageSecretKey(storage, "bearer_token"), "work-token")
+	assert.NoError(t, err)
+	DeleteStorageSecrets(storage)
+	_, err = store.Get(getStorageSecretKey(storage, "bearer_token"))
+	assert.ErrorIs(t, err, ErrSecretNotFound, "Secrets of named accounts should be deleted with them")
+}
+
+func TestSyncCreatesAccountRemote(t *testing.T) {
+	commands := filepath.Join(t.TempDir(), "commands")
+	dm := setupRunGame(t, fmt.Sprintf(`#!/bin/sh
+echo "$*" >> %v
+case "$*" in
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`, commands))
+	useMemorySecretStore(t)
+
+	family, err := AddStorageInstance(GOOGLE, "Family")
+	assert.NoError(t, err)
+	dm.GetGameDefMap()["Hollow"].Storage = family.Id
+
+	events := runSync(context.Background(), dm, &Options{Gamenames: []string{"Hollow"}})
+	assert.Equal(t, PhaseDone, events[len(events)-1].Phase)
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "config create opencloudsave-google-family drive")
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SyncStatusUpToDate     = "up_to_date"
+	SyncStatusLocalNewer   = "local_newer"
+	SyncStatusRemoteNewer  = "remote_newer"
+	SyncStatusBothChanged  = "both_changed"
+	SyncStatusLocalOnly    = "local_only"
+	SyncStatusRemoteOnly   = "remote_only"
+	SyncStatusNotInstalled = "not_installed"
+)
+
+// Many clouds store modification times with a precision of a second, so
+// times this close together are treated as the same.
+const modTimeWindow = 2 * time.Second
+
+// rclone exits with 3 when the listed directory does not exist
+const rcloneDirNotFoundExitCode = 3
+
+// How many games are compared at once
+const syncStatusParallelism = 4
+
+// SideSummary describes the save files on one side of a sync.
+type SideSummary struct {
+	Files  int        `json:"files"`
+	Size   int64      `json:"size"`
+	Newest *time.Time `json:"newest,omitempty"`
+}
+
+// GameSyncStatus tells how the local saves of a game compare to the saves in
+// the cloud.
+type GameSyncStatus struct {
+	Game      string       `json:"game"`
+	Status    string       `json:"status,omitempty"`
+	Local     *SideSummary `json:"local,omitempty"`
+	Remote    *SideSummary `json:"remote,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ErrorKind string       `json:"errorKind,omitempty"`
+}
+
+type syncStatusFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func (s *SideSummary) add(file syncStatusFile) {
+	s.Files++
+	s.Size += file.size
+	if s.Newest == nil || file.modTime.After(*s.Newest) {
+		modTime := file.modTime
+		s.Newest = &modTime
+	}
+}
+
+// listFilesRecursive lists every file below location with rclone. found is
+// false when location does not exist.
+func (cm *CloudManager) listFilesRecursive(ctx context.Context, storage Storage, location string, includes []string) ([]CloudFile, bool, error) {
+	args := []string{"lsjson", "-R", "--files-only"}
+	for _, include := range includes {
+		args = append(args, fmt.Sprintf("--include=%v", include))
+	}
+	args = append(args, location)
+
+	cmd := makeStorageCommand(ctx, storage, getCloudApp(), args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok && exiterr.ExitCode() == rcloneDirNotFoundExitCode {
+			return nil, false, nil
+		}
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+
+		return nil, false, fmt.Errorf(stderr.String())
+	}
+
+	files := []CloudFile{}
+	err = json.Unmarshal([]byte(stdout.String()), &files)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return files, true, nil
+}
+
+// addListing adds files to listing, keyed by their path relative to the
+// listed folder.
+func addListing(listing map[string]syncStatusFile, files []CloudFile) error {
+	for _, file := range files {
+		modTime, err := time.Parse(time.RFC3339Nano, file.ModTime)
+		if err != nil {
+			return fmt.Errorf("failed to read the modification time of %v: %v", file.Path, err)
+		}
+
+		listing[file.Path] = syncStatusFile{size: file.Size, modTime: modTime}
+	}
+
+	return nil
+}
+
+// compareListings classifies the saves of a game from the files on both
+// sides.
+func compareListings(local map[string]syncStatusFile, remote map[string]syncStatusFile) string {
+	if len(local) == 0 && len(remote) == 0 {
+		return SyncStatusUpToDate
+	}
+	if len(remote) == 0 {
+		return SyncStatusLocalOnly
+	}
+	if len(local) == 0 {
+		return SyncStatusRemoteOnly
+	}
+
+	localAhead := false
+	remoteAhead := false
+	for path, localFile := range local {
+		remoteFile, ok := remote[path]
+		if !ok {
+			localAhead = true
+			continue
+		}
+
+		diff := localFile.modTime.Sub(remoteFile.modTime)
+		switch {
+		case diff > modTimeWindow:
+			localAhead = true
+		case diff < -modTimeWindow:
+			remoteAhead = true
+		case localFile.size != remoteFile.size:
+			// Changed on both sides at about the same time
+			localAhead = true
+			remoteAhead = true
+		}
+	}
+
+	for path := range remote {
+		if _, ok := local[path]; !ok {
+			remoteAhead = true
+		}
+	}
+
+	switch {
+	case localAhead && remoteAhead:
+		return SyncStatusBothChanged
+	case localAhead:
+		return SyncStatusLocalNewer
+	case remoteAhead:
+		return SyncStatusRemoteNewer
+	default:
+		return SyncStatusUpToDate
+	}
+}
+
+// GetSyncStatus compares the local saves of gamename with its saves in the
+// cloud. Only file listings are read, nothing is transferred.
+func GetSyncStatus(ctx context.Context, cm *CloudManager, dm GameDefManager, gamename string) *GameSyncStatus {
+	result := &GameSyncStatus{Game: gamename}
+	fail := func(kind string, err error) *GameSyncStatus {
+		err = WithErrorKind(kind, err)
+		result.Error = err.Error()
+		result.ErrorKind = GetErrorKind(err)
+		return result
+	}
+
+	gamedef := dm.GetGameDefMap()[gamename]
+	if gamedef == nil {
+		return fail(ErrorKindUnknownGame, fmt.Errorf("failed to find game (%v)", gamename))
+	}
+
+	storage, err := GetGameStorage(gamedef)
+	if err != nil {
+		return fail(ErrorKindStorage, err)
+	}
+
+	remotePath, err := GetGameRemotePath(gamename, gamedef)
+	if err != nil {
+		return fail(ErrorKindPaths, err)
+	}
+
+	syncpaths, err := dm.GetSyncpathForGame(gamename)
+	if err != nil {
+		return fail(ErrorKindPaths, err)
+	}
+
+	local := make(map[string]syncStatusFile)
+	installed := false
+	// Every path syncs into the same cloud folder, so the cloud side is only
+	// filtered when every path is
+	includes := []string{}
+	for _, syncpath := range syncpaths {
+		if syncpath.Include != "" {
+			includes = append(includes, syncpath.Include)
+		}
+
+		if _, err := os.Stat(syncpath.Path); err != nil {
+			continue
+		}
+		installed = true
+
+		var pathIncludes []string
+		if syncpath.Include != "" {
+			pathIncludes = []string{syncpath.Include}
+		}
+		files, _, err := cm.listFilesRecursive(ctx, nil, syncpath.Path, pathIncludes)
+		if err != nil {
+			return fail(ErrorKindPaths, err)
+		}
+		err = addListing(local, files)
+		if err != nil {
+			return fail(ErrorKindPaths, err)
+		}
+	}
+	if len(includes) != len(syncpaths) {
+		includes = nil
+	}
+
+	remote := make(map[string]syncStatusFile)
+	location := getRemoteLocation(storage, remotePath)
+	files, _, err := cm.listFilesRecursive(ctx, storage, location, includes)
+	if err != nil {
+		return fail(ErrorKindSync, checkAuthError(storage, err.Error()))
+	}
+	err = addListing(remote, files)
+	if err != nil {
+		return fail(ErrorKindSync, err)
+	}
+	// The lease of a device syncing the game is not a save
+	delete(remote, LeaseFilename)
+
+	result.Local = &SideSummary{}
+	for _, file := range local {
+		result.Local.add(file)
+	}
+	result.Remote = &SideSummary{}
+	for _, file := range remote {
+		result.Remote.add(file)
+	}
+
+	if !installed {
+		result.Status = SyncStatusNotInstalled
+		return result
+	}
+
+	result.Status = compareListings(local, remote)
+	return result
+}
+
+// GetSyncStatuses compares the saves of every game in gamenames. The
+// statuses are returned in the order of gamenames.
+func GetSyncStatuses(ctx context.Context, cm *CloudManager, dm GameDefManager, gamenames []string) ([]*GameSyncStatus, error) {
+	if GetCurrentStorageProvider() == nil {
+		return nil, WithErrorKind(ErrorKindNoCloud, ErrNoCloud)
+	}
+
+	statuses := make([]*GameSyncStatus, len(gamenames))
+	limit := make(chan struct{}, syncStatusParallelism)
+	var wg sync.WaitGroup
+	for i, gamename := range gamenames {
+		wg.Add(1)
+		go func(i int, gamename string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			statuses[i] = GetSyncStatus(ctx, cm, dm, strings.TrimSpace(gamename))
+		}(i, gamename)
+	}
+	wg.Wait()
+
+	return statuses, nil
+}
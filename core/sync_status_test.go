@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Cloud locations are told apart from local folders by the colon of the
+// remote name
+const fakeListingRclone = `#!/bin/sh
+case "$*" in
+*copy*|*sync*|*move*|*delete*|*purge*)
+	echo "transferred files" >&2
+	exit 1
+	;;
+*:*Synced*)
+	echo '[{"Path":"save.sav","Name":"save.sav","Size":10,"ModTime":"2023-05-01T10:00:01Z","IsDir":false}]'
+	;;
+*Synced*)
+	echo '[{"Path":"save.sav","Name":"save.sav","Size":10,"ModTime":"2023-05-01T10:00:00.5Z","IsDir":false}]'
+	;;
+*:*Diverged*)
+	cat <<'END'
+[{"Path":"a.sav","Name":"a.sav","Size":5,"ModTime":"2023-05-01T10:00:00Z","IsDir":false},
+{"Path":"b/b.sav","Name":"b.sav","Size":7,"ModTime":"2023-05-03T10:00:00Z","IsDir":false}]
+END
+	;;
+*Diverged*)
+	echo '[{"Path":"a.sav","Name":"a.sav","Size":6,"ModTime":"2023-05-02T10:00:00Z","IsDir":false}]'
+	;;
+*:*Fresh*)
+	echo "directory not found" >&2
+	exit 3
+	;;
+*Fresh*)
+	echo '[{"Path":"a.sav","Name":"a.sav","Size":6,"ModTime":"2023-05-02T10:00:00Z","IsDir":false}]'
+	;;
+*:*Absent*)
+	echo '[{"Path":"a.sav","Name":"a.sav","Size":6,"ModTime":"2023-05-02T10:00:00Z","IsDir":false}]'
+	;;
+*)
+	echo "listed an unknown folder" >&2
+	exit 1
+	;;
+esac
+`
+
+func TestCompareListings(t *testing.T) {
+	at := func(seconds int) syncStatusFile {
+		return syncStatusFile{size: 10, modTime: time.Unix(int64(1000+seconds), 0)}
+	}
+
+	assert.Equal(t, SyncStatusUpToDate, compareListings(nil, nil))
+	assert.Equal(t, SyncStatusLocalOnly, compareListings(map[string]syncStatusFile{"a": at(0)}, nil))
+	assert.Equal(t, SyncStatusRemoteOnly, compareListings(nil, map[string]syncStatusFile{"a": at(0)}))
+	assert.Equal(t, SyncStatusUpToDate, compareListings(
+		map[string]syncStatusFile{"a": at(0)},
+		map[string]syncStatusFile{"a": at(1)}),
+		"Clouds may round modification times")
+	assert.Equal(t, SyncStatusLocalNewer, compareListings(
+		map[string]syncStatusFile{"a": at(10), "b": at(0)},
+		map[string]syncStatusFile{"a": at(0)}))
+	assert.Equal(t, SyncStatusRemoteNewer, compareListings(
+		map[string]syncStatusFile{"a": at(0)},
+		map[string]syncStatusFile{"a": at(10)}))
+	assert.Equal(t, SyncStatusBothChanged, compareListings(
+		map[string]syncStatusFile{"a": at(10)},
+		map[string]syncStatusFile{"a": at(0), "b": at(0)}))
+	assert.Equal(t, SyncStatusBothChanged, compareListings(
+		map[string]syncStatusFile{"a": {size: 1, modTime: at(0).modTime}},
+		map[string]syncStatusFile{"a": at(0)}))
+}
+
+func TestGetSyncStatuses(t *testing.T) {
+	useFakeRclone(t, fakeListingRclone)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(filepath.Join(t.TempDir(), "test.log")))
+
+	dm := MakeGameDefManager(filepath.Join(t.TempDir(), UserOverrideFilename))
+	_, err := GetSyncStatuses(context.Background(), MakeCloudManager(), dm, []string{"Synced"})
+	assert.ErrorIs(t, err, ErrNoCloud)
+
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+	root := t.TempDir()
+	games := []*GameDef{}
+	for _, game := range []string{"Synced", "Diverged", "Fresh", "Absent"} {
+		path := filepath.Join(root, game)
+		if game != "Absent" {
+			assert.NoError(t, os.Mkdir(path, 0755))
+		}
+		games = append(games, testGameDef(game, path))
+	}
+	dm = makeTestGameDefManager(t, games...)
+
+	statuses, err := GetSyncStatuses(context.Background(), MakeCloudManager(), dm, []string{"Synced", "Diverged", "Fresh", "Absent", "Missing"})
+	assert.NoError(t, err, "Nothing should be transferred")
+	assert.Len(t, statuses, 5)
+
+	assert.Equal(t, "Synced", statuses[0].Game)
+	assert.Equal(t, SyncStatusUpToDate, statuses[0].Status)
+	assert.Empty(t, statuses[0].Error)
+
+	diverged := statuses[1]
+	assert.Equal(t, SyncStatusBothChanged, diverged.Status)
+	assert.Equal(t, 1, diverged.Local.Files)
+	assert.Equal(t, int64(6), diverged.Local.Size)
+	assert.Equal(t, time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC), diverged.Local.Newest.UTC())
+	assert.Equal(t, 2, diverged.Remote.Files)
+	assert.Equal(t, int64(12), diverged.Remote.Size)
+	assert.Equal(t, time.Date(2023, 5, 3, 10, 0, 0, 0, time.UTC), diverged.Remote.Newest.UTC())
+
+	assert.Equal(t, SyncStatusLocalOnly, statuses[2].Status, "A missing cloud folder should not fail")
+	assert.Equal(t, 0, statuses[2].Remote.Files)
+	assert.Nil(t, statuses[2].Remote.Newest)
+
+	assert.Equal(t, SyncStatusNotInstalled, statuses[3].Status)
+	assert.Equal(t, 1, statuses[3].Remote.Files)
+
+	assert.Empty(t, statuses[4].Status)
+	assert.Equal(t, ErrorKindUnknownGame, statuses[4].ErrorKind)
+}
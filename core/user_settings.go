@@ -1,12 +1,32 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
 	"path/filepath"
 	"sync"
 )
 
+// The folder the settings are copied to while they are synced, so they are
+// not locked while rclone runs
+const SettingsSyncDirname = "settings_sync"
+
+// The settings files that are left out of the settings sync. They hold the
+// state of this device, except for the hooks, which anyone able to write to
+// the cloud could otherwise use to run commands on every device.
+var localSettingsFiles = []string{
+	ProviderSettingsFilename,
+	AuthStateFilename,
+	SessionsFilename,
+	ScheduleRunsFilename,
+	APIHistoryFilename,
+	HooksFilename,
+}
+
 type SyncRequest struct {
 	ctx      context.Context
 	path     string
@@ -78,10 +98,211 @@ func (usm *UserSettingsManager) syncUserSettings(ctx context.Context, userOverri
 	}
 
 	path := filepath.Dir(userOverride)
+	return syncSettingsFiles(ctx, usm.cm, storage, path, "*.json", GetRemoteRoot()+"user_settings/")
+}
+
+func getSettingsSyncDir(dir string) (string, error) {
+	perfDir, err := getCloudPerfDir()
+	if err != nil {
+		return "", err
+	}
+
+	// The settings may be kept outside of the config folder
+	hash := fnv.New32a()
+	hash.Write([]byte(filepath.Clean(dir)))
+	return filepath.Join(perfDir, SettingsSyncDirname, fmt.Sprintf("%08x", hash.Sum32())), nil
+}
+
+// syncSettingsFiles syncs the files of dir matching pattern with remotePath.
+// They are copied to a folder of their own first, so the settings lock is
+// only held while the files are copied and not while rclone runs. Files
+// changed by this device during the sync are kept, and synced the next time.
+func syncSettingsFiles(ctx context.Context, cm *CloudManager, storage Storage, dir string, pattern string, remotePath string) error {
+	staging, err := getSettingsSyncDir(dir)
+	if err != nil {
+		return err
+	}
+
+	lockPath, err := getSettingsSyncLockPath()
+	if err != nil {
+		return err
+	}
+
+	// Other processes syncing the settings use the same folder
+	lock, _, err := waitLock(ctx, "the settings sync", lockPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	staged, err := stageSettingsFiles(dir, staging, pattern)
+	if err != nil {
+		return err
+	}
+
 	ops := GetDefaultCloudOptions()
-	ops.Include = "*.json"
-	_, err := usm.cm.PerformSyncOperation(ctx, storage, ops, path, ToplevelCloudFolder+"user_settings/")
-	return err
+	ops.Include = "/" + pattern
+	// Copies other versions left in the cloud are neither read nor removed
+	for _, name := range localSettingsFiles {
+		ops.Excludes = append(ops.Excludes, "/"+name)
+	}
+	_, err = cm.PerformSyncOperation(ctx, storage, ops, staging, remotePath)
+	if err != nil {
+		return err
+	}
+
+	return unstageSettingsFiles(dir, staging, pattern, staged)
+}
+
+// stageSettingsFiles copies the files of dir matching pattern to staging,
+// keeping their modification times. It returns what the copied files held.
+func stageSettingsFiles(dir string, staging string, pattern string) (map[string][]byte, error) {
+	err := os.MkdirAll(staging, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make(map[string][]byte)
+	err = withSettingsLock(func() error {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !isSyncedSettingsFile(entry, pattern) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			err = writeSettingsFile(filepath.Join(staging, entry.Name()), data, info)
+			if err != nil {
+				return err
+			}
+			staged[entry.Name()] = data
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Files removed from dir since the last sync are removed from the cloud
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		matched, _ := filepath.Match(pattern, entry.Name())
+		if _, ok := staged[entry.Name()]; matched && !ok {
+			err = os.Remove(filepath.Join(staging, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return staged, nil
+}
+
+// unstageSettingsFiles copies the files the sync changed in staging back to
+// dir. Files of dir that changed since they were staged are left alone.
+func unstageSettingsFiles(dir string, staging string, pattern string, staged map[string][]byte) error {
+	return withSettingsLock(func() error {
+		entries, err := os.ReadDir(staging)
+		if err != nil {
+			return err
+		}
+
+		synced := make(map[string]bool)
+		for _, entry := range entries {
+			if !isSyncedSettingsFile(entry, pattern) {
+				continue
+			}
+			synced[entry.Name()] = true
+
+			data, err := os.ReadFile(filepath.Join(staging, entry.Name()))
+			if err != nil {
+				return err
+			}
+			previous, wasStaged := staged[entry.Name()]
+			if wasStaged && bytes.Equal(data, previous) {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if !isSettingsFileUnchanged(path, previous, wasStaged) {
+				InfoLogger.Printf("Keeping %v, it changed while the settings were synced", path)
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			err = writeSettingsFile(path, data, info)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Bidirectional syncs remove the files removed from the cloud
+		for name, previous := range staged {
+			path := filepath.Join(dir, name)
+			if !synced[name] && isSettingsFileUnchanged(path, previous, true) {
+				err = os.Remove(path)
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func isSyncedSettingsFile(entry os.DirEntry, pattern string) bool {
+	if matched, _ := filepath.Match(pattern, entry.Name()); !matched || !entry.Type().IsRegular() {
+		return false
+	}
+
+	for _, name := range localSettingsFiles {
+		if entry.Name() == name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSettingsFileUnchanged reports whether path still holds what was staged,
+// or still does not exist when it was not staged.
+func isSettingsFileUnchanged(path string, staged []byte, wasStaged bool) bool {
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return !wasStaged
+	}
+
+	return err == nil && wasStaged && bytes.Equal(current, staged)
+}
+
+func writeSettingsFile(path string, data []byte, info os.FileInfo) error {
+	err := os.WriteFile(path, data, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// rclone tells which side changed by the modification times
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
 }
 
 func (usm *UserSettingsManager) RequestSync(ctx context.Context, path string) error {
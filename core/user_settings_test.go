@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSettingsRclone downloads remote.json and a newer user_overrides.json.
+// The sync waits until the file %[2]v exists, after creating %[1]v.
+const fakeSettingsRclone = `#!/bin/sh
+for arg; do dest=$arg; done
+case " $* " in
+*" copy "*)
+	echo remote > "$dest/remote.json"
+	echo remote > "$dest/user_overrides.json"
+	;;
+*" sync "*)
+	touch %[1]v
+	while [ ! -f %[2]v ]; do sleep 0.01; done
+	;;
+*lsjson*)
+	echo "[]"
+	;;
+esac
+`
+
+func TestSyncSettingsFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(t.TempDir()+"/test.log"))
+	signals := t.TempDir()
+	started, done := filepath.Join(signals, "started"), filepath.Join(signals, "done")
+	useFakeRclone(t, fmt.Sprintf(fakeSettingsRclone, started, done))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+
+	dir, err := getCloudPerfDir()
+	assert.NoError(t, err)
+	overrides := filepath.Join(dir, UserOverrideFilename)
+	assert.NoError(t, os.WriteFile(overrides, []byte("local\n"), 0644))
+
+	result := make(chan error)
+	go func() {
+		result <- syncSettingsFiles(context.Background(), MakeCloudManager(), GetCurrentStorageProvider(), dir, "*.json", "opencloudsaves/user_settings/")
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(started)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The settings are not locked while rclone runs
+	_, err = GetCurrentCloudPerfs()
+	assert.NoError(t, err)
+	path, err := getSettingsLockPath()
+	assert.NoError(t, err)
+	lock, _, err := tryLock("the settings", path)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+
+	assert.NoError(t, os.WriteFile(overrides, []byte("edited\n"), 0644))
+	assert.NoError(t, os.WriteFile(done, nil, 0644))
+	assert.NoError(t, <-result)
+
+	// New files are downloaded, while edits made during the sync are kept
+	data, err := os.ReadFile(filepath.Join(dir, "remote.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "remote\n", string(data))
+	data, err = os.ReadFile(overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited\n", string(data))
+}
+
+func TestLocalSettingsAreNotSynced(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, InitLoggingWithPath(t.TempDir()+"/test.log"))
+	commands := filepath.Join(t.TempDir(), "commands")
+	useFakeRclone(t, fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %v\n", commands))
+	assert.NoError(t, saveCloudPerfs(&CloudPerfs{Cloud: DROPBOX}))
+
+	dir, err := getCloudPerfDir()
+	assert.NoError(t, err)
+	for _, name := range append(localSettingsFiles, StorageInstancesFilename) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600))
+	}
+	assert.NoError(t, syncSettingsFiles(context.Background(), MakeCloudManager(), GetCurrentStorageProvider(), dir, "*.json", "opencloudsaves/user_settings/"))
+
+	staging, err := getSettingsSyncDir(dir)
+	assert.NoError(t, err)
+	log, err := os.ReadFile(commands)
+	assert.NoError(t, err)
+	for _, name := range []string{ProviderSettingsFilename, AuthStateFilename, SessionsFilename, ScheduleRunsFilename, APIHistoryFilename, HooksFilename} {
+		assert.NoFileExists(t, filepath.Join(staging, name))
+		assert.Contains(t, string(log), "--filter=- /"+name)
+	}
+	assert.FileExists(t, filepath.Join(staging, "opencloud_perfs.json"))
+	assert.FileExists(t, filepath.Join(staging, StorageInstancesFilename), "Games refer to accounts on every device")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"opencloudsave/core"
+)
+
+type daemonCommand struct {
+	QuietPeriod    time.Duration `long:"quiet-period" default:"10s" description:"How long saves must be left unchanged before they are synced"`
+	RescanInterval time.Duration `long:"rescan-interval" default:"1m" description:"How often new save folders are looked for"`
+}
+
+func (c *daemonCommand) Execute(args []string) error {
+	if c.QuietPeriod <= 0 || c.RescanInterval <= 0 {
+		return core.WithErrorKind(core.ErrorKindUsage, fmt.Errorf("--quiet-period and --rescan-interval must be positive"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ops := &core.DaemonOptions{
+		QuietPeriod:    c.QuietPeriod,
+		RescanInterval: c.RescanInterval,
+		SyncOptions: &core.Options{
+			DryRun:  globalOps.DryRun,
+			Verbose: globalOps.Verbose,
+			Output:  globalOps.Output,
+		},
+	}
+
+	channels := core.MakeDefaultChannelProvider()
+	var err error
+	go func() {
+		err = core.RunDaemon(ctx, core.MakeCloudManager(), core.MakeGameDefManager(getUserOverrideLocation()), ops, channels)
+		close(channels.Logs)
+	}()
+
+	printMessages(channels.Logs)
+	return err
+}
+
+// printMessages prints the messages of a long running command until logs is
+// closed, or their progress events as JSON lines.
+func printMessages(logs chan core.Message) {
+	for msg := range logs {
+		if isJSONOutput() {
+			if msg.Event != nil {
+				writeJSON(msg.Event)
+			}
+			continue
+		}
+
+		if msg.Err != nil {
+			fmt.Fprintln(os.Stderr, msg.Err)
+		} else if msg.Message != "" {
+			fmt.Println(msg.Message)
+		}
+	}
+}
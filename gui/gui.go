@@ -140,6 +140,9 @@ type GuiGamedef struct {
 	MacOS       []GuiDatapath
 	Linux       []GuiDatapath
 	CustomFlags string
+	RemotePath  string
+	Storage     string
+	InstallPath string
 }
 
 // @TODO the issue with this is that when we refresh, we will
@@ -156,6 +159,9 @@ func convertGameDefToGuiGameDef(def *core.GameDef) *GuiGamedef {
 	resultDef := &GuiGamedef{
 		Name:        def.DisplayName,
 		CustomFlags: def.CustomFlags,
+		RemotePath:  def.RemotePath,
+		Storage:     def.Storage,
+		InstallPath: def.InstallPath,
 	}
 
 	for _, path := range def.WinPath {
@@ -211,6 +217,9 @@ func commitGamedef(gamedef GuiGamedef) {
 		DarwinPath:  []*core.Datapath{},
 		LinuxPath:   []*core.Datapath{},
 		CustomFlags: gamedef.CustomFlags,
+		RemotePath:  strings.TrimSpace(gamedef.RemotePath),
+		Storage:     gamedef.Storage,
+		InstallPath: strings.TrimSpace(gamedef.InstallPath),
 	}
 
 	for _, def := range gamedef.Windows {
@@ -309,7 +318,7 @@ func commitDeleteAllDrives() {
 	}()
 }
 
-func commitCloudService(service int) error {
+func commitCloudService(service string) error {
 	cloudperfs := core.GetCurrentCloudPerfsOrDefault()
 	cloudperfs.Cloud = service
 	err := core.CommitCloudPerfs(cloudperfs)
@@ -330,15 +339,384 @@ func commitCloudService(service int) error {
 	return nil
 }
 
-func getCloudService() (int, error) {
+func getCloudService() (string, error) {
 	cloudperfs, err := core.GetCurrentCloudPerfs()
 	if err != nil {
-		return -1, nil
+		return "", nil
 	}
 
 	return cloudperfs.Cloud, nil
 }
 
+func getStorageProviders() (string, error) {
+	result, err := json.Marshal(core.GetConfiguredStorageProviders())
+	return string(result), err
+}
+
+// getAccountProviders returns the providers that support more than one
+// account.
+func getAccountProviders() (string, error) {
+	providers := []*core.StorageProvider{}
+	for _, provider := range core.GetStorageProviders() {
+		if provider.NewStorage != nil {
+			providers = append(providers, provider)
+		}
+	}
+
+	result, err := json.Marshal(providers)
+	return string(result), err
+}
+
+func getStorageInstances() (string, error) {
+	result, err := json.Marshal(core.GetStorageInstances())
+	return string(result), err
+}
+
+func addStorageInstance(provider string, name string) (string, error) {
+	instance, err := core.AddStorageInstance(provider, name)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(instance)
+	return string(result), err
+}
+
+func getHeadlessAuthorization(id string) (string, error) {
+	storage, err := core.GetCloudStorage(id)
+	if err != nil {
+		return "", err
+	}
+
+	authorization, err := core.GetHeadlessAuthorization(storage)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(authorization)
+	return string(result), err
+}
+
+func commitHeadlessAuthorization(id string, token string) error {
+	storage, err := core.GetCloudStorage(id)
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	err = cm.AuthorizeStorageDrive(context.Background(), storage, token)
+	if err != nil {
+		core.ErrorLogger.Println(err)
+		return err
+	}
+
+	err = core.UpdateCloudProvider(id)
+	if err != nil {
+		return err
+	}
+
+	go runQueuedSyncs(cm, storage)
+	return nil
+}
+
+type GuiReauthStorage struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func getStoragesNeedingReauth() (string, error) {
+	result := []GuiReauthStorage{}
+	for _, id := range core.GetStoragesNeedingReauth() {
+		provider, err := core.GetStorageProvider(id)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, GuiReauthStorage{
+			Id:   provider.Id,
+			Name: provider.DisplayName,
+		})
+	}
+
+	resultJson, err := json.Marshal(result)
+	return string(resultJson), err
+}
+
+func runQueuedSyncs(cm *core.CloudManager, storage core.Storage) {
+	channels := core.MakeDefaultChannelProvider()
+	go core.ConsoleLogger(channels.Logs)
+	err := core.CompleteReauth(context.Background(), cm, core.MakeDefaultGameDefManager(), storage, channels)
+	if err != nil {
+		core.ErrorLogger.Println(err)
+	}
+}
+
+func commitReconnectStorage(id string) error {
+	storage, err := core.GetCloudStorage(id)
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	w := GetRootWindow()
+	go func() {
+		err := cm.ReconnectStorageDrive(context.Background(), storage)
+		msg := core.Message{
+			Message:  "Reconnected, queued syncs will run again",
+			Err:      err,
+			Finished: true,
+		}
+		if err != nil {
+			core.ErrorLogger.Println(err)
+			msg.Message = err.Error()
+		} else {
+			runQueuedSyncs(cm, storage)
+		}
+
+		resultJson, _ := json.Marshal(msg)
+		w.Dispatch(func() {
+			w.Eval(fmt.Sprintf("OnReconnectComplete(%v)", string(resultJson)))
+		})
+	}()
+	return nil
+}
+
+func removeStorageInstance(id string) error {
+	return core.RemoveStorageInstance(context.Background(), core.MakeCloudManager(), id)
+}
+
+func commitStorageProviderSettings(id string, jsonInput string) error {
+	settings := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonInput), &settings)
+	if err != nil {
+		return err
+	}
+
+	provider, err := core.GetStorageProvider(id)
+	if err != nil {
+		return err
+	}
+
+	// Settings only apply when the remote is created, so drop any existing remote
+	cm := core.MakeCloudManager()
+	cm.DeleteCloudEntry(context.Background(), provider.Storage())
+
+	_, err = provider.ApplySettings(settings)
+	return err
+}
+
+type GuiProviderSettings struct {
+	Provider *core.StorageProvider `json:"provider"`
+	Settings map[string]string     `json:"settings"`
+}
+
+func getCurrentStorageProviderSettings() (string, error) {
+	cloudperfs, err := core.GetCurrentCloudPerfs()
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := core.GetStorageProvider(cloudperfs.Cloud)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(GuiProviderSettings{
+		Provider: provider,
+		Settings: core.GetProviderSettings(provider.Id),
+	})
+	return string(result), err
+}
+
+func updateCurrentStorageProviderSettings(jsonInput string) error {
+	settings := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonInput), &settings)
+	if err != nil {
+		return err
+	}
+
+	cloudperfs, err := core.GetCurrentCloudPerfs()
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	_, err = core.ConfigureStorageProvider(context.Background(), cm, cloudperfs.Cloud, settings)
+	return err
+}
+
+func getSecondaryCloudService() (string, error) {
+	cloudperfs, err := core.GetCurrentCloudPerfs()
+	if err != nil {
+		return "", nil
+	}
+
+	return cloudperfs.SecondaryCloud, nil
+}
+
+func commitSecondaryCloudService(service string) error {
+	err := core.UpdateSecondaryCloudProvider(service)
+	if err != nil {
+		return err
+	}
+
+	if service == "" {
+		return nil
+	}
+
+	storage, err := core.GetCloudStorage(service)
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	go func() {
+		err := cm.CreateDriveIfNotExists(context.Background(), storage)
+		if err != nil {
+			core.ErrorLogger.Println(err)
+		}
+	}()
+	return nil
+}
+
+func getRemoteRoot() string {
+	return core.GetRemoteRoot()
+}
+
+func commitRemoteRoot(root string) error {
+	storage, err := core.GetCurrentCloudStorage()
+	if err != nil {
+		return err
+	}
+
+	cm := core.MakeCloudManager()
+	w := GetRootWindow()
+	go func() {
+		ctx := context.Background()
+		result := ""
+		err := cm.CreateDriveIfNotExists(ctx, storage)
+		if err == nil {
+			result, err = core.UpdateRemoteRoot(ctx, cm, core.MakeDefaultGameDefManager(), root)
+		}
+
+		msg := core.Message{
+			Message:  result,
+			Err:      err,
+			Finished: true,
+		}
+		if err != nil {
+			core.ErrorLogger.Println(err)
+			msg.Message = err.Error()
+		}
+
+		resultJson, _ := json.Marshal(msg)
+		w.Dispatch(func() {
+			w.Eval(fmt.Sprintf("OnRemoteRootUpdated(%v)", string(resultJson)))
+		})
+	}()
+	return nil
+}
+
+func commitRunDoctor() {
+	w := GetRootWindow()
+	go func() {
+		checks := core.RunDoctor(context.Background(), core.MakeCloudManager())
+		resultJson, _ := json.Marshal(checks)
+		w.Dispatch(func() {
+			w.Eval(fmt.Sprintf("OnDoctorComplete(%v)", string(resultJson)))
+		})
+	}()
+}
+
+// commitLoadSyncStatus compares the saves of the tracked games with the
+// cloud without syncing them. The result is passed to OnSyncStatusLoaded.
+func commitLoadSyncStatus() {
+	w := GetRootWindow()
+	go func() {
+		dm := core.MakeDefaultGameDefManager()
+		games := []string{}
+		for key, gamedef := range dm.GetGameDefMap() {
+			if !gamedef.Hidden {
+				games = append(games, key)
+			}
+		}
+
+		statuses, err := core.GetSyncStatuses(context.Background(), core.MakeCloudManager(), dm, games)
+		if err != nil {
+			core.ErrorLogger.Println(err)
+			return
+		}
+
+		resultJson, _ := json.Marshal(statuses)
+		w.Dispatch(func() {
+			w.Eval(fmt.Sprintf("OnSyncStatusLoaded(%v)", string(resultJson)))
+		})
+	}()
+}
+
+func commitExportDiagnostics() (string, error) {
+	path, err := dialog.File().Filter("Zip archive", "zip").Title("Save Diagnostics").SetStartFile(core.DefaultDiagnosticsFilename()).Save()
+	if err == dialog.ErrCancelled {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if filepath.Ext(path) == "" {
+		path += ".zip"
+	}
+
+	err = core.ExportDiagnostics(context.Background(), core.MakeDefaultGameDefManager(), path)
+	if err != nil {
+		core.ErrorLogger.Println(err)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func commitSecondaryCloudOperation(restore bool) error {
+	secondary, err := core.GetSecondaryCloudStorage()
+	if err != nil {
+		return err
+	}
+
+	if secondary == nil {
+		return fmt.Errorf("no secondary cloud set")
+	}
+
+	cm := core.MakeCloudManager()
+	dm := core.MakeDefaultGameDefManager()
+	w := GetRootWindow()
+	go func() {
+		var result string
+		var err error
+		ctx := context.Background()
+		if restore {
+			result, err = cm.RestoreFromSecondary(ctx, dm, secondary)
+		} else {
+			result, err = cm.HealSecondary(ctx, dm, secondary)
+		}
+
+		msg := core.Message{
+			Message:  result,
+			Err:      err,
+			Finished: true,
+		}
+		if err != nil {
+			core.ErrorLogger.Println(err)
+			msg.Message = err.Error()
+		}
+
+		resultJson, _ := json.Marshal(msg)
+		w.Dispatch(func() {
+			w.Eval(fmt.Sprintf("OnSecondaryCloudOperationComplete(%v)", string(resultJson)))
+		})
+	}()
+	return nil
+}
+
 func getSyncDryRun(name string) error {
 	ops := &core.Options{
 		DryRun:    []bool{true},
@@ -391,48 +769,6 @@ func clearUserSettings() error {
 	return os.Remove(path)
 }
 
-func commitFTPSettings(jsonInput string) error {
-	ftp := &core.FtpStorage{}
-	err := json.Unmarshal([]byte(jsonInput), ftp)
-	if err != nil {
-		return err
-	}
-
-	if ftp.Password != "" {
-		cm := core.MakeCloudManager()
-		obscuredpw, err := cm.ObscurePassword(context.Background(), ftp.Password)
-		if err != nil {
-			return err
-		}
-
-		ftp.Password = obscuredpw
-	}
-
-	core.SetFtpDriveStorage(ftp)
-	return nil
-}
-
-func commitNextCloudSettings(jsonInput string) error {
-	nextCloud := &core.NextCloudStorage{}
-	err := json.Unmarshal([]byte(jsonInput), nextCloud)
-	if err != nil {
-		return err
-	}
-
-	if nextCloud.Pass != "" {
-		cm := core.MakeCloudManager()
-		obscuredpw, err := cm.ObscurePassword(context.Background(), nextCloud.Pass)
-		if err != nil {
-			return err
-		}
-
-		nextCloud.Pass = obscuredpw
-	}
-
-	core.SetNextCloudStorage(nextCloud)
-	return nil
-}
-
 func cancelPendingSync(gameName string) {
 	core.InfoLogger.Println("Cancel sync of " + gameName)
 	chanelMutex.Lock()
@@ -445,14 +781,6 @@ func cancelPendingSync(gameName string) {
 	cleanupPendingChannel(gameName)
 }
 
-func deleteCurrentNextCloudSettings() {
-	core.DeleteNextCloudStorage(context.Background())
-}
-
-func deleteCurrentFTPSettings() {
-	core.DeleteFtpDriveStorage(context.Background())
-}
-
 func getShouldNotPromptForLargeSyncs() bool {
 	cloudperfs := core.GetCurrentCloudPerfsOrDefault()
 	return cloudperfs.ShouldNotPromptForLargeSyncs
@@ -535,15 +863,40 @@ func bindFunctions(w webview.WebView) {
 		return setCloudSelectScreen(w)
 	})
 	w.Bind("getCloudService", getCloudService)
+	w.Bind("getStorageProviders", getStorageProviders)
+	w.Bind("commitStorageProviderSettings", commitStorageProviderSettings)
+	w.Bind("getAccountProviders", getAccountProviders)
+	w.Bind("getStorageInstances", getStorageInstances)
+	w.Bind("addStorageInstance", addStorageInstance)
+	w.Bind("renameStorageInstance", core.RenameStorageInstance)
+	w.Bind("removeStorageInstance", removeStorageInstance)
+	w.Bind("getHeadlessAuthorization", getHeadlessAuthorization)
+	w.Bind("commitHeadlessAuthorization", commitHeadlessAuthorization)
+	w.Bind("getStoragesNeedingReauth", getStoragesNeedingReauth)
+	w.Bind("commitReconnectStorage", commitReconnectStorage)
+	w.Bind("isSecretStoreLocked", core.IsSecretStoreLocked)
+	w.Bind("hasLockedSecrets", core.HasLockedSecrets)
+	w.Bind("unlockSecretStore", core.UnlockSecretStore)
+	w.Bind("getCurrentStorageProviderSettings", getCurrentStorageProviderSettings)
+	w.Bind("updateCurrentStorageProviderSettings", updateCurrentStorageProviderSettings)
+	w.Bind("getRemoteRoot", getRemoteRoot)
+	w.Bind("commitRemoteRoot", commitRemoteRoot)
+	w.Bind("commitRunDoctor", commitRunDoctor)
+	w.Bind("commitLoadSyncStatus", commitLoadSyncStatus)
+	w.Bind("commitExportDiagnostics", commitExportDiagnostics)
+	w.Bind("getSecondaryCloudService", getSecondaryCloudService)
+	w.Bind("commitSecondaryCloudService", commitSecondaryCloudService)
+	w.Bind("commitHealSecondaryCloud", func() error {
+		return commitSecondaryCloudOperation(false)
+	})
+	w.Bind("commitRestoreFromSecondaryCloud", func() error {
+		return commitSecondaryCloudOperation(true)
+	})
 	w.Bind("getSyncDryRun", getSyncDryRun)
 	w.Bind("getShouldPerformDryRun", getShouldPerformDryRun)
 	w.Bind("getCloudPerfs", getCloudPerfs)
 	w.Bind("commitCloudPerfs", commitCloudPerfs)
 	w.Bind("clearUserSettings", clearUserSettings)
-	w.Bind("commitFTPSettings", commitFTPSettings)
-	w.Bind("deleteCurrentNextCloudSettings", deleteCurrentNextCloudSettings)
-	w.Bind("commitNextCloudSettings", commitNextCloudSettings)
-	w.Bind("deleteCurrentFTPSettings", deleteCurrentFTPSettings)
 	w.Bind("getShouldNotPromptForLargeSyncs", getShouldNotPromptForLargeSyncs)
 	w.Bind("cancelPendingSync", cancelPendingSync)
 	w.Bind("getMultisyncSelectedGames", getMultisyncSelectedGames)
@@ -23,6 +23,10 @@ async function onSyncButtonSuccess(element, name) {
 }
 
 async function onSyncButtonClicked(element, name) {
+    if (!await unlockSecretsBeforeSync()) {
+        return;
+    }
+
     const sizeEl = document.getElementById(`${name}-total-size`);
     const size = sizeEl !== null ? parseInt(sizeEl.innerText) : 0;
     const shouldNotPrompt = await getShouldNotPromptForLargeSyncs();
@@ -85,6 +89,14 @@ function deserGamedef(gamedef) {
     const flags = document.getElementById('flags');
     flags.value = gamedef.CustomFlags || "";
 
+    const remotePath = document.getElementById('remote-path');
+    remotePath.value = gamedef.RemotePath || "";
+
+    const installPath = document.getElementById('install-path');
+    installPath.value = gamedef.InstallPath || "";
+
+    loadGameStorageOptions(gamedef.Storage || "");
+
     ["Windows", "MacOS", "Linux"].forEach(element => {
         const def = gamedef[element];
         if (!def) {
@@ -117,16 +129,34 @@ function deserGamedef(gamedef) {
     });
 }
 
+async function loadGameStorageOptions(selected) {
+    const select = document.getElementById('game-storage');
+    select.innerHTML = '<option value="">Current cloud</option>';
+    JSON.parse(await getStorageInstances()).forEach(instance => {
+        const option = document.createElement('option');
+        option.value = instance.id;
+        option.innerText = instance.name;
+        select.appendChild(option);
+    });
+    select.value = selected;
+}
+
 async function submitGamedef() {
     document.getElementById('id01').style.display='none';
     gamenameEl = document.getElementById('gamename');
     const flags = document.getElementById('flags').value || "";
+    const remotePath = document.getElementById('remote-path').value || "";
+    const installPath = document.getElementById('install-path').value || "";
+    const storage = document.getElementById('game-storage').value || "";
     let result = {
         Name: gamenameEl.value,
         Windows: [],
         MacOS: [],
         Linux: [],
         CustomFlags: flags,
+        RemotePath: remotePath,
+        Storage: storage,
+        InstallPath: installPath,
     };
 
     ["Windows", "MacOS", "Linux"].forEach(element => {
@@ -231,8 +261,83 @@ async function onGameImportClicked(name) {
     });
 }
 
+async function loadReauthBanner() {
+    const banner = document.getElementById('reauth-banner');
+    const storages = JSON.parse(await getStoragesNeedingReauth());
+    banner.innerHTML = "";
+    banner.style.display = storages.length > 0 ? 'block' : 'none';
+
+    storages.forEach(storage => {
+        const row = document.createElement("p");
+        row.innerText = `The sign in to ${storage.name} expired or was revoked. Syncs to it will run again once you reconnect. `;
+
+        const button = document.createElement("button");
+        button.className = "contentbutton noticebutton";
+        button.innerText = "Reconnect";
+        button.onclick = async () => {
+            row.innerText = `Reconnecting ${storage.name}, please sign in using your browser...`;
+            window.OnReconnectComplete = (result) => {
+                row.innerText = result.Message;
+                if (!result.Err) {
+                    setTimeout(loadReauthBanner, 3000);
+                }
+            };
+            await commitReconnectStorage(storage.id).catch(e => {
+                row.innerText = `${e}`;
+            });
+        };
+
+        row.appendChild(button);
+        banner.appendChild(row);
+    });
+}
+
+const syncStatusLabels = {
+    up_to_date: "Up to date",
+    local_newer: "Local saves are newer",
+    remote_newer: "Cloud saves are newer",
+    both_changed: "Changed locally and in the cloud",
+    local_only: "Not in the cloud yet",
+    remote_only: "Only in the cloud",
+    not_installed: "Not installed",
+};
+
+function formatSyncSide(label, side) {
+    if (!side || side.files === 0) {
+        return `${label}: no files`;
+    }
+
+    return `${label}: ${side.files} files, ${side.size} bytes, newest ${new Date(side.newest).toLocaleString()}`;
+}
+
+window.OnSyncStatusLoaded = (statuses) => {
+    const badges = {};
+    document.querySelectorAll('.sync-status').forEach(badge => {
+        badges[badge.dataset.game] = badge;
+    });
+
+    statuses.forEach(status => {
+        const badge = badges[status.game];
+        if (!badge) {
+            return;
+        }
+
+        if (status.error) {
+            badge.innerText = "Status unavailable";
+            badge.title = status.error;
+            return;
+        }
+
+        badge.innerText = syncStatusLabels[status.status] || status.status;
+        badge.title = `${formatSyncSide("Local", status.local)}\n${formatSyncSide("Cloud", status.remote)}`;
+        badge.classList.toggle('sync-status-attention', status.status === "remote_newer" || status.status === "both_changed");
+    });
+};
+
 setTimeout(async () => { 
     setupAccordionHandler();
+    await loadReauthBanner();
+    await commitLoadSyncStatus();
     await require('html/fuzzy-search.js');
 });
 
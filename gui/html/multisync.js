@@ -113,6 +113,10 @@ async function onMultisyncUnselectAllClicked() {
 }
 
 async function onSyncSelectedClicked() {
+    if (!await unlockSecretsBeforeSync()) {
+        return;
+    }
+
     const multisyncInput = document.getElementById('multisync-input');
     multisyncInput.value = "";
     await onChangeSearchMultisync(multisyncInput);
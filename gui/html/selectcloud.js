@@ -39,121 +39,211 @@ async function cloudSelected(cloudService) {
 }
 
 
-async function setCurrentCloud() {
-    const service = await getCloudService();
-    await setCloud(service);
+let storageProviders = [];
+
+async function loadStorageProviders() {
+    storageProviders = JSON.parse(await getStorageProviders());
+
+    const container = document.getElementById("cloudproviders");
+    container.innerHTML = "";
+    storageProviders.forEach(provider => {
+        const btncont = document.createElement("div");
+        btncont.className = "cloudbtncont";
+
+        const btn = document.createElement("div");
+        btn.className = "cloudproviderbtn";
+        btn.onclick = () => onProviderClicked(provider.id);
+
+        const text = document.createElement("p");
+        text.innerText = provider.displayName;
+
+        btn.appendChild(text);
+        btncont.appendChild(btn);
+        container.appendChild(btncont);
+    });
 }
 
-async function setCloud(service, postfix = "") {
-    const currentCloudEl = document.getElementById("currentcloudcont");
-    const closeModal = document.getElementById("closemodal");
-    const prefix = "Current Cloud Storage: ";
-    let value = "";
-
-    switch (service) {
-        case -1: 
-            currentCloudEl.style.display = 'none';
-            closeModal.style.display = 'none';
-            break;
-        case 0:
-            value = "Google Cloud";
-            break;
-        case 1:
-            value = "One Drive";
-            break;
-        case 2:
-            value = "Drop Box";
-            break;
-        case 3:
-            value = "Box";
-            break;
-        case 4: 
-            value = "Next Cloud";
-            break;
-        case 5:
-            value = "Custom FTP Server";
-            break;
-        default:
-            currentCloudEl.style.display = 'none';
-            closeModal.style.display = 'none';
-            break;
+function findStorageProvider(id) {
+    return storageProviders.find(provider => provider.id === id);
+}
+
+async function onProviderClicked(id) {
+    const provider = findStorageProvider(id);
+    if (provider.oauth && document.getElementById("headless-auth").checked) {
+        await showHeadlessAuth(provider);
+    } else if (provider.fields && provider.fields.length > 0) {
+        await showProviderSettings(provider);
+    } else {
+        await cloudSelected(id);
     }
+}
+
+let pendingSettingsProvider = null;
+let pendingHeadlessProvider = null;
+
+async function showHeadlessAuth(provider) {
+    pendingHeadlessProvider = provider;
+    const authorization = JSON.parse(await getHeadlessAuthorization(provider.id));
+
+    document.getElementById("headless-auth-title").innerText = `Sign in to ${provider.displayName} on another device`;
+    document.getElementById("headless-auth-instructions").innerText = authorization.instructions;
+    document.getElementById("headless-auth-command").innerText = authorization.command;
+    document.getElementById("headless-auth-token").value = "";
+    document.getElementById("headless-auth-error").style.display = 'none';
+
+    const modal = document.getElementById("headless-auth-modal");
+    modal.style = 'display: block';
+}
 
-    if (value !== "") {
-        currentCloudEl.innerText = prefix + value + postfix;
+async function onHeadlessAuthConfirm() {
+    const provider = pendingHeadlessProvider;
+    const errorEl = document.getElementById("headless-auth-error");
+    const token = document.getElementById("headless-auth-token").value;
+
+    errorEl.innerText = "Checking token...";
+    errorEl.style.display = 'block';
+    try {
+        await commitHeadlessAuthorization(provider.id, token);
+    } catch (e) {
+        errorEl.innerText = `Unable to sign in to ${provider.displayName}: ${e}`;
+        return;
     }
+
+    onHeadlessAuthClose();
+    initializeGui();
 }
 
-function setNextCloud() {
-    const nextCloudModal = document.getElementById('nextcloud-modal');
-    nextCloudModal.style = 'display: block';
+function onHeadlessAuthClose() {
+    const modal = document.getElementById("headless-auth-modal");
+    modal.style = 'display: none';
+    pendingHeadlessProvider = null;
 }
 
-async function onNextCloudConfirm() {
-    const url = document.getElementById('NextCloud-Url');
-    const userName = document.getElementById('NextCloud-Username');
-    const password = document.getElementById('NextCloud-Password');
-    const bearerToken = document.getElementById('NextCloud-BearerToken');
-    const nextCloudSettings = {
-        url: url.value,
-        user: userName.value,
-        bearer_token: bearerToken.value,
-        pass: password.value,
-    };
+function makeVaultPassphraseRow(id) {
+    const row = document.createElement("p");
+    row.className = "modal-row";
+
+    const label = document.createElement("label");
+    label.className = "modal-label";
+    label.htmlFor = id;
+    label.innerText = "Vault Passphrase:";
 
-    await deleteCurrentNextCloudSettings();
-    await commitNextCloudSettings(JSON.stringify(nextCloudSettings));
-    await cloudSelected(4);
+    const input = document.createElement("input");
+    input.className = "modal-input";
+    input.id = id;
+    input.type = "password";
+    input.placeholder = "Protects your saved passwords";
+
+    row.appendChild(label);
+    row.appendChild(input);
+    return row;
 }
 
-async function onNextCloudClose() {
-    const nextCloudModal = document.getElementById('nextcloud-modal');
-    nextCloudModal.style = 'display: none';
-
-    const url = document.getElementById('NextCloud-Url');
-    const userName = document.getElementById('NextCloud-Username');
-    const password = document.getElementById('NextCloud-Password');
-    const bearerToken = document.getElementById('NextCloud-BearerToken');
-    url.value = "";
-    userName.value = "";
-    password.value = "";
-    bearerToken.value = "";
+async function showProviderSettings(provider) {
+    pendingSettingsProvider = provider;
+
+    const title = document.getElementById("provider-settings-title");
+    title.innerText = `${provider.displayName} Settings`;
+
+    const form = document.getElementById("provider-settings-form");
+    form.innerHTML = "";
+    provider.fields.forEach(field => {
+        const row = document.createElement("p");
+        row.className = "modal-row";
+
+        const label = document.createElement("label");
+        label.className = "modal-label";
+        label.htmlFor = `provider-field-${field.key}`;
+        label.innerText = `${field.label}:`;
+
+        const input = document.createElement("input");
+        input.className = "modal-input";
+        input.id = `provider-field-${field.key}`;
+        input.placeholder = field.default || (field.required ? "(Required)" : "(Optional)");
+        if (field.secret) {
+            input.type = "password";
+        }
+
+        row.appendChild(label);
+        row.appendChild(input);
+        if (field.directory) {
+            input.onclick = async () => {
+                const path = await openDirDialog();
+                if (path) {
+                    input.value = path;
+                }
+            };
+        }
+
+        form.appendChild(row);
+    });
+
+    if (provider.fields.some(field => field.secret) && await isSecretStoreLocked()) {
+        form.appendChild(makeVaultPassphraseRow("provider-vault-passphrase"));
+    }
+
+    const modal = document.getElementById("provider-settings-modal");
+    modal.style = 'display: block';
 }
 
-function setFtpServer() {
-    const ftpModal = document.getElementById('ftp-modal');
-    ftpModal.style = 'display: block';
+async function onProviderSettingsConfirm() {
+    const provider = pendingSettingsProvider;
+    const errorEl = document.getElementById('providersettings-error');
+    errorEl.style.display = 'none';
+
+    const settings = {};
+    provider.fields.forEach(field => {
+        const input = document.getElementById(`provider-field-${field.key}`);
+        if (input.value !== "") {
+            settings[field.key] = input.value;
+        }
+    });
+
+    const passphrase = document.getElementById("provider-vault-passphrase");
+    const passphraseValue = passphrase ? passphrase.value : "";
+
+    onProviderSettingsClose();
+    try {
+        if (passphraseValue !== "") {
+            await unlockSecretStore(passphraseValue);
+        }
+        await commitStorageProviderSettings(provider.id, JSON.stringify(settings));
+    } catch (e) {
+        errorEl.innerText = `Unable to use ${provider.displayName}: ${e}`;
+        errorEl.style.display = 'block';
+        return;
+    }
+
+    await cloudSelected(provider.id);
 }
 
-async function onFtpConfirm() {
-    const hostName = document.getElementById('HostName');
-    const port = document.getElementById('Port');
-    const userName = document.getElementById('UserName');
-    const password = document.getElementById('Password');
-    const ftpSettings = {
-        host: hostName.value,
-        port: port.value,
-        userName: userName.value,
-        password: password.value,
-    };
+function onProviderSettingsClose() {
+    const modal = document.getElementById("provider-settings-modal");
+    modal.style = 'display: none';
 
-    await deleteCurrentFTPSettings();
-    await commitFTPSettings(JSON.stringify(ftpSettings));
-    await cloudSelected(5);
+    const form = document.getElementById("provider-settings-form");
+    form.innerHTML = "";
 }
 
-async function onFtpClose() {
-    const ftpModal = document.getElementById('ftp-modal');
-    ftpModal.style = 'display: none';
-
-    const hostName = document.getElementById('HostName');
-    const port = document.getElementById('Port');
-    const userName = document.getElementById('UserName');
-    const password = document.getElementById('Password');
-    hostName.value = "";
-    port.value = "";
-    userName.value = "";
-    password.value = "";
+async function setCurrentCloud() {
+    await loadStorageProviders();
+    const service = await getCloudService();
+    await setCloud(service);
+}
+
+async function setCloud(service, postfix = "") {
+    const currentCloudEl = document.getElementById("currentcloudcont");
+    const closeModal = document.getElementById("closemodal");
+    const prefix = "Current Cloud Storage: ";
+    const provider = findStorageProvider(service);
+
+    if (!provider) {
+        currentCloudEl.style.display = 'none';
+        closeModal.style.display = 'none';
+        return;
+    }
+
+    currentCloudEl.innerText = prefix + provider.displayName + postfix;
 }
 
-setCurrentCloud();
\ No newline at end of file
+setCurrentCloud();
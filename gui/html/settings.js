@@ -20,6 +20,285 @@ async function onSettingsModalOpen() {
 
     const doNotPromptSwitch = document.getElementById('settings-should-not-prompt-large');
     doNotPromptSwitch.checked = currentSettings.shouldNotPromptForLargeSyncs;
+
+    const secondaryCloudSelect = document.getElementById('settings-secondary-cloud');
+    secondaryCloudSelect.innerHTML = '<option value="">None</option>';
+    JSON.parse(await getStorageProviders()).forEach(provider => {
+        const option = document.createElement('option');
+        option.value = provider.id;
+        option.innerText = provider.displayName;
+        secondaryCloudSelect.appendChild(option);
+    });
+    secondaryCloudSelect.value = await getSecondaryCloudService();
+
+    document.getElementById('settings-remote-root').value = await getRemoteRoot();
+    document.getElementById('settings-remote-root-status').innerText = "";
+
+    await loadProviderSettings();
+    await loadAccounts();
+}
+
+async function loadAccounts() {
+    const form = document.getElementById('settings-accounts-form');
+    form.innerHTML = "";
+    const current = await getCloudService();
+    JSON.parse(await getStorageInstances()).forEach(instance => {
+        const row = document.createElement("p");
+        row.className = "modal-row";
+
+        const input = document.createElement("input");
+        input.className = "modal-input";
+        input.value = instance.name;
+
+        const rename = document.createElement("button");
+        rename.className = "contentbutton";
+        rename.innerText = "Rename";
+        rename.onclick = () => onRenameAccountClicked(instance.id, input.value);
+
+        const remove = document.createElement("button");
+        remove.className = "contentbutton";
+        remove.innerText = "Remove";
+        remove.disabled = instance.id === current;
+        remove.onclick = () => onRemoveAccountClicked(instance);
+
+        row.appendChild(input);
+        row.appendChild(rename);
+        row.appendChild(remove);
+        form.appendChild(row);
+    });
+
+    const providerSelect = document.getElementById('settings-account-provider');
+    providerSelect.innerHTML = "";
+    JSON.parse(await getAccountProviders()).forEach(provider => {
+        const option = document.createElement('option');
+        option.value = provider.id;
+        option.innerText = provider.displayName;
+        providerSelect.appendChild(option);
+    });
+}
+
+async function onAddAccountClicked() {
+    const statusEl = document.getElementById('settings-accounts-status');
+    const provider = document.getElementById('settings-account-provider').value;
+    const name = document.getElementById('settings-account-name');
+
+    try {
+        await addStorageInstance(provider, name.value);
+        name.value = "";
+        statusEl.innerText = "Account added. Use Change Cloud to sign in and switch to it.";
+    } catch (e) {
+        statusEl.innerText = `Unable to add account: ${e}`;
+    }
+    await loadAccounts();
+}
+
+async function onRenameAccountClicked(id, name) {
+    const statusEl = document.getElementById('settings-accounts-status');
+    try {
+        await renameStorageInstance(id, name);
+        statusEl.innerText = "";
+    } catch (e) {
+        statusEl.innerText = `Unable to rename account: ${e}`;
+    }
+    await loadAccounts();
+}
+
+async function onRemoveAccountClicked(instance) {
+    const statusEl = document.getElementById('settings-accounts-status');
+    makeConfirmationPopup({
+        title: `Remove ${instance.name}`,
+        subtitle: "This removes the account from Open Cloud Saves. Saves already stored in it are not deleted. Are you sure?",
+        onConfirm: async () => {
+            try {
+                await removeStorageInstance(instance.id);
+                statusEl.innerText = "";
+            } catch (e) {
+                statusEl.innerText = `Unable to remove account: ${e}`;
+            }
+            await loadAccounts();
+        }
+    })
+}
+
+async function loadProviderSettings() {
+    const container = document.getElementById('settings-provider-cont');
+    const current = JSON.parse(await getCurrentStorageProviderSettings().catch(() => "null"));
+    if (!current || !current.provider.fields || current.provider.fields.length === 0) {
+        container.style.display = 'none';
+        return;
+    }
+
+    const title = document.getElementById('settings-provider-title');
+    title.innerText = `${current.provider.displayName} Settings`;
+
+    const form = document.getElementById('settings-provider-form');
+    form.innerHTML = "";
+    current.provider.fields.forEach(field => {
+        const row = document.createElement("p");
+        row.className = "modal-row";
+
+        const label = document.createElement("label");
+        label.className = "modal-label";
+        label.htmlFor = `settings-provider-field-${field.key}`;
+        label.innerText = `${field.label}:`;
+
+        const input = document.createElement("input");
+        input.className = "modal-input";
+        input.id = `settings-provider-field-${field.key}`;
+        input.dataset.key = field.key;
+        if (field.secret) {
+            input.type = "password";
+            input.placeholder = "(Unchanged)";
+        } else {
+            input.value = current.settings[field.key] || "";
+            input.placeholder = field.default || "";
+        }
+
+        row.appendChild(label);
+        row.appendChild(input);
+        form.appendChild(row);
+    });
+
+    if (current.provider.fields.some(field => field.secret) && await isSecretStoreLocked()) {
+        const row = document.createElement("p");
+        row.className = "modal-row";
+        row.innerHTML = '<label class="modal-label" for="settings-vault-passphrase">Vault Passphrase:</label>' +
+            '<input class="modal-input" id="settings-vault-passphrase" type="password" placeholder="Protects your saved passwords">';
+        form.appendChild(row);
+    }
+
+    container.style.display = 'block';
+}
+
+async function onProviderSettingsSaved() {
+    const statusEl = document.getElementById('settings-provider-status');
+    const form = document.getElementById('settings-provider-form');
+    const settings = {};
+    form.querySelectorAll('input').forEach(input => {
+        if (input.value !== "" && input.dataset.key) {
+            settings[input.dataset.key] = input.value;
+        }
+    });
+
+    try {
+        statusEl.innerText = "Saving...";
+        const passphrase = document.getElementById('settings-vault-passphrase');
+        if (passphrase && passphrase.value !== "") {
+            await unlockSecretStore(passphrase.value);
+        }
+        await updateCurrentStorageProviderSettings(JSON.stringify(settings));
+        statusEl.innerText = "Saved";
+        await loadProviderSettings();
+    } catch (e) {
+        statusEl.innerText = `Unable to save settings: ${e}`;
+    }
+}
+
+async function onRemoteRootSaved() {
+    const input = document.getElementById('settings-remote-root');
+    const statusEl = document.getElementById('settings-remote-root-status');
+    window.OnRemoteRootUpdated = async (result) => {
+        statusEl.innerText = result.Err ? result.Message : "Cloud save folder updated.";
+        input.value = await getRemoteRoot();
+    };
+
+    makeConfirmationPopup({
+        title: "Move Cloud Save Folder",
+        subtitle: `Every save in your cloud will be moved to ${input.value || "opencloudsaves/"}. Are you sure?`,
+        onConfirm: async () => {
+            try {
+                statusEl.innerText = "Moving cloud save folder...";
+                await commitRemoteRoot(input.value);
+            } catch (e) {
+                statusEl.innerText = `Unable to move cloud save folder: ${e}`;
+            }
+        }
+    })
+}
+
+async function onRunDoctorClicked() {
+    const resultsEl = document.getElementById('settings-doctor-results');
+    window.OnDoctorComplete = (checks) => {
+        resultsEl.innerHTML = "";
+        checks.forEach(check => {
+            const row = document.createElement("div");
+            row.className = "doctor-check";
+
+            const status = document.createElement("span");
+            status.className = `doctor-${check.status}`;
+            status.innerText = `[${check.status.toUpperCase()}] `;
+            row.appendChild(status);
+            row.appendChild(document.createTextNode(`${check.name}: ${check.message}`));
+
+            if (check.hint && check.status !== "pass") {
+                const hint = document.createElement("div");
+                hint.className = "doctor-hint";
+                hint.innerText = check.hint;
+                row.appendChild(hint);
+            }
+
+            resultsEl.appendChild(row);
+        });
+    };
+
+    resultsEl.innerText = "Running diagnostics...";
+    await commitRunDoctor();
+}
+
+async function onExportDiagnosticsClicked() {
+    const resultsEl = document.getElementById('settings-doctor-results');
+    try {
+        const path = await commitExportDiagnostics();
+        if (path) {
+            resultsEl.innerText = `Diagnostics written to ${path}. Passwords, tokens and your username have been removed.`;
+        }
+    } catch (e) {
+        resultsEl.innerText = `Unable to export diagnostics: ${e}`;
+    }
+}
+
+async function onSecondaryCloudChanged(element) {
+    const statusEl = document.getElementById('settings-secondary-cloud-status');
+    statusEl.innerText = "";
+
+    try {
+        await commitSecondaryCloudService(element.value);
+    } catch (e) {
+        statusEl.innerText = `Unable to set secondary cloud: ${e}`;
+        element.value = await getSecondaryCloudService();
+    }
+}
+
+async function runSecondaryCloudOperation(operation, pendingText) {
+    if (!await unlockSecretsBeforeSync()) {
+        return;
+    }
+
+    const statusEl = document.getElementById('settings-secondary-cloud-status');
+    window.OnSecondaryCloudOperationComplete = (result) => {
+        statusEl.innerText = result.Message;
+    };
+
+    try {
+        statusEl.innerText = pendingText;
+        await operation();
+    } catch (e) {
+        statusEl.innerText = `${e}`;
+    }
+}
+
+async function onHealSecondaryCloudClicked() {
+    await runSecondaryCloudOperation(commitHealSecondaryCloud, "Checking secondary cloud...");
+}
+
+async function onRestoreFromSecondaryCloudClicked() {
+    makeConfirmationPopup({
+        title: "Restore from Secondary Cloud",
+        subtitle: "This will copy every save stored in your secondary cloud into your current cloud. Are you sure?",
+        onConfirm: async () => {
+            await runSecondaryCloudOperation(commitRestoreFromSecondaryCloud, "Restoring from secondary cloud...");
+        }
+    })
 }
 
 async function onDryRunToggle(element) {
@@ -6,6 +6,41 @@ const CurrentSyncState = {
     retryDryRun: false,
 };
 
+// Asks for the vault passphrase when saved passwords are locked, as syncs
+// would fail without them. Resolves to false if the user cancelled.
+async function unlockSecretsBeforeSync() {
+    if (!await hasLockedSecrets()) {
+        return true;
+    }
+
+    const modal = document.getElementById('vault-unlock-modal');
+    const input = document.getElementById('vault-unlock-passphrase');
+    const errorEl = document.getElementById('vault-unlock-error');
+    input.value = "";
+    errorEl.style.display = 'none';
+    modal.style.display = 'block';
+
+    return new Promise((resolve) => {
+        document.getElementById('vault-unlock-confirm').onclick = async () => {
+            try {
+                await unlockSecretStore(input.value);
+            } catch (e) {
+                errorEl.innerText = `Unable to unlock the vault: ${e}`;
+                errorEl.style.display = 'block';
+                return;
+            }
+
+            modal.style.display = 'none';
+            resolve(true);
+        };
+
+        document.getElementById('vault-unlock-cancel').onclick = () => {
+            modal.style.display = 'none';
+            resolve(false);
+        };
+    });
+}
+
 function recordSyncMessage(message) {
     const multisync = document.getElementById('bisync-line-cont');
     const lineDiv = document.createElement('div');
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"opencloudsave/core"
+)
+
+type leaseStatus struct {
+	Game  string      `json:"game"`
+	Lease *core.Lease `json:"lease"`
+	Stale bool        `json:"stale"`
+}
+
+type leaseShowCommand struct {
+	Args struct {
+		Games []string `positional-arg-name:"GAME" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *leaseShowCommand) Execute(args []string) error {
+	ctx := context.Background()
+	cm := core.MakeCloudManager()
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+
+	statuses := []*leaseStatus{}
+	for _, game := range c.Args.Games {
+		game = strings.TrimSpace(game)
+		lease, err := core.GetGameLease(ctx, cm, dm, game)
+		if err != nil {
+			return err
+		}
+
+		status := &leaseStatus{Game: game, Lease: lease}
+		if lease != nil {
+			status.Stale = lease.IsStale(time.Now())
+		}
+		statuses = append(statuses, status)
+	}
+
+	printResult(map[string]interface{}{"leases": statuses}, func() {
+		for _, status := range statuses {
+			lease := status.Lease
+			switch {
+			case lease == nil:
+				fmt.Printf("%v: no device is syncing it\n", status.Game)
+			case status.Stale:
+				fmt.Printf("%v: the lease of %v expired at %v, see lease break\n", status.Game, lease.Device, lease.Expires().Local().Format(time.RFC1123))
+			default:
+				fmt.Printf("%v: %v is syncing it since %v, the lease expires at %v\n", status.Game, lease.Device, lease.Timestamp.Local().Format(time.RFC1123), lease.Expires().Local().Format(time.RFC1123))
+			}
+		}
+	})
+	return nil
+}
+
+type leaseBreakCommand struct {
+	Force bool `long:"force" description:"Break leases that did not expire yet, while their device may still be syncing"`
+	Args  struct {
+		Games []string `positional-arg-name:"GAME" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *leaseBreakCommand) Execute(args []string) error {
+	ctx := context.Background()
+	cm := core.MakeCloudManager()
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+
+	broken := []*leaseStatus{}
+	for _, game := range c.Args.Games {
+		game = strings.TrimSpace(game)
+		lease, err := core.BreakGameLease(ctx, cm, dm, game, c.Force)
+		if err != nil {
+			return err
+		}
+		broken = append(broken, &leaseStatus{Game: game, Lease: lease, Stale: lease.IsStale(time.Now())})
+	}
+
+	printResult(map[string]interface{}{"broken": broken}, func() {
+		for _, status := range broken {
+			fmt.Printf("Broke the lease of %v taken by %v\n", status.Game, status.Lease.Device)
+		}
+	})
+	return nil
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"runtime"
-	"strconv"
+	"strings"
 
 	"opencloudsave/core"
 	"opencloudsave/gui"
@@ -21,23 +26,56 @@ func main() {
 	}
 
 	ops := &core.Options{}
-	_, err := flags.Parse(ops)
+	parser := newParser(ops)
+	handled := false
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		setup(ops)
+		handled = true
 
-	if err != nil {
-		log.Fatal(err)
+		var err error
+		if command == nil {
+			warnDeprecatedFlags(parser)
+			activeCommand = getLegacyCommandName(parser)
+			core.SetLockHolder(getLockHolder(activeCommand))
+			err = runLegacy(ops)
+		} else {
+			activeCommand = getCommandName(parser)
+			core.SetLockHolder(getLockHolder(activeCommand))
+			err = command.Execute(args)
+		}
+
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			// The command run by run already reported how it failed
+			writeCommandResult(nil)
+			os.Exit(exitErr.code)
+		}
+
+		writeCommandResult(err)
+		return err
 	}
 
-	// TMP
-	if len(ops.Experimental) > 0 {
-		grm := core.GetGameRecordManager()
-		grm.VisitGameRecords(func(key string, grm *core.GameRecord) error {
-			fmt.Println(key)
-			return nil
-		})
-		return
+	_, err := parser.Parse()
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+
+		if !handled {
+			activeCommand = getCommandName(parser)
+			writeCommandResult(err)
+		}
+
+		// go-flags already printed the error, but failed commands are logged too
+		if core.ErrorLogger != nil {
+			core.ErrorLogger.Println(err)
+		}
+		os.Exit(1)
 	}
-	// TMP
+}
 
+// setup initializes logging and the secret store, which every command needs.
+func setup(ops *core.Options) {
 	if len(ops.LogLocation) > 0 {
 		err := core.InitLoggingWithPath(ops.LogLocation[0])
 		if err != nil {
@@ -52,57 +90,321 @@ func main() {
 
 	core.InfoLogger.Println("Launching with version " + core.VersionRevision)
 
-	if len(ops.SetCloud) > 0 {
-		cloud, err := strconv.Atoi(ops.SetCloud[0])
+	err := core.MigrateRcloneConfig(context.Background())
+	if err != nil {
+		core.ErrorLogger.Println(err)
+	}
+
+	if len(ops.VaultPassphrase) > 0 {
+		passphrase, err := os.ReadFile(ops.VaultPassphrase[0])
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		cloudperfs := core.GetCurrentCloudPerfsOrDefault()
-		cloudperfs.Cloud = cloud
-		err = core.CommitCloudPerfs(cloudperfs)
+		err = core.UnlockSecretStore(strings.TrimSpace(string(passphrase)))
 		if err != nil {
 			log.Fatal(err)
 		}
+	}
+}
 
-		fmt.Println("Cloud Set!")
-		return
+// runLegacy runs the flat flags that predate the subcommands on top of the
+// commands replacing them, or the GUI when no flag asks for anything else.
+func runLegacy(ops *core.Options) error {
+	var err error
+	if len(ops.Experimental) > 0 {
+		return (&recordsSearchCommand{}).Execute(nil)
 	}
 
-	storage, _ := core.GetCurrentCloudStorage()
-	noGui := len(ops.NoGUI) == 1 && ops.NoGUI[0]
-	userOverrideLocation := ""
-	if len(ops.UserOverride) > 0 {
-		userOverrideLocation = ops.UserOverride[0]
+	if len(ops.Doctor) > 0 && ops.Doctor[0] {
+		return (&doctorCommand{}).Execute(nil)
 	}
 
-	cm := core.MakeCloudManager()
-	if len(ops.SyncUserSettings) > 0 && ops.SyncUserSettings[0] {
-		if storage == nil {
-			log.Fatal("Attempting to sync cloud data with no cloud provider set. Please set a cloud provider via --set-cloud <CLOUD_PROVIDER>")
+	if len(ops.Diagnostics) > 0 {
+		command := &diagnosticsCommand{}
+		command.Args.Zip = ops.Diagnostics[0]
+		return command.Execute(nil)
+	}
+
+	if len(ops.ListClouds) > 0 {
+		return (&cloudShowCommand{}).Execute(nil)
+	}
+
+	if len(ops.RenameCloud) > 0 {
+		for id, name := range ops.RenameCloud {
+			command := &cloudRenameCommand{}
+			command.Args.Id = id
+			command.Args.Name = name
+			err = command.Execute(nil)
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+
+	if len(ops.RemoveCloud) > 0 {
+		command := &cloudRemoveCommand{}
+		command.Args.Id = ops.RemoveCloud[0]
+		return command.Execute(nil)
+	}
 
-		err = cm.CreateDriveIfNotExists(context.Background(), storage)
+	if len(ops.GameCloud) > 0 {
+		for game, id := range ops.GameCloud {
+			command := &editCommand{}
+			command.Args.Game = game
+			command.Cloud = id
+			err = command.Execute(nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(ops.LocalStorage) > 0 {
+		command := &cloudSetCommand{Settings: map[string]string{"path": ops.LocalStorage[0]}}
+		command.Args.Cloud = core.LOCAL
+		return command.Execute(nil)
+	}
+
+	cloud := ""
+	if len(ops.SetCloud) > 0 {
+		cloud, err = core.ResolveStorageProviderId(ops.SetCloud[0])
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		if len(ops.CloudName) > 0 {
+			instance, err := core.GetOrAddStorageInstance(cloud, ops.CloudName[0])
+			if err != nil {
+				return err
+			}
+			cloud = instance.Id
+		}
+	} else if len(ops.CloudName) > 0 {
+		return fmt.Errorf("--cloud-name requires the provider of the account, e.g. --set-cloud google --cloud-name Family")
+	}
+
+	if len(ops.CloudSettings) > 0 {
+		configured := cloud
+		if configured == "" {
+			configured = core.GetCurrentCloudPerfsOrDefault().Cloud
 		}
 
-		err = core.GetUserSettingsManager().RequestSync(context.Background(), userOverrideLocation)
+		_, err = core.ConfigureStorageProvider(context.Background(), core.MakeCloudManager(), configured, ops.CloudSettings)
 		if err != nil {
-			core.ErrorLogger.Println(err)
-			log.Fatal(err)
+			return err
 		}
 
-		core.InfoLogger.Println("Cloud Settings In Sync")
-		return
+		if cloud == "" {
+			printResult(map[string]interface{}{"cloud": configured}, func() {
+				fmt.Println("Cloud Settings Saved!")
+			})
+			return nil
+		}
 	}
 
-	dm := core.MakeGameDefManager(userOverrideLocation)
-	if noGui {
-		channels := core.MakeDefaultChannelProvider()
-		go core.ConsoleLogger(channels.Logs)
-		core.RequestMainOperation(context.Background(), cm, ops, dm, channels)
-	} else {
-		gui.GuiMain(ops, dm)
+	if cloud == "" {
+		cloud = core.GetCurrentCloudPerfsOrDefault().Cloud
+	}
+
+	if len(ops.AuthorizeRemote) > 0 && ops.AuthorizeRemote[0] {
+		return authorizeHeadless(cloud)
+	}
+
+	if len(ops.Reconnect) > 0 && ops.Reconnect[0] {
+		return reconnect(cloud)
+	}
+
+	if len(ops.SetCloud) > 0 {
+		command := &cloudSetCommand{}
+		command.Args.Cloud = cloud
+		return command.Execute(nil)
+	}
+
+	if len(ops.SetSecondary) > 0 {
+		command := &cloudSecondaryCommand{}
+		command.Args.Cloud = ops.SetSecondary[0]
+		if command.Args.Cloud == "-1" {
+			command.Args.Cloud = "none"
+		}
+		return command.Execute(nil)
+	}
+
+	if len(ops.RemoteRoot) > 0 {
+		command := &cloudRootCommand{}
+		command.Args.Path = ops.RemoteRoot[0]
+		return command.Execute(nil)
+	}
+
+	if len(ops.SyncUserSettings) > 0 && ops.SyncUserSettings[0] {
+		return (&settingsSyncCommand{}).Execute(nil)
+	}
+
+	if len(ops.RestoreSecondary) > 0 && ops.RestoreSecondary[0] {
+		return (&restoreCommand{}).Execute(nil)
+	}
+
+	if len(ops.HealSecondary) > 0 && ops.HealSecondary[0] {
+		return (&cloudHealCommand{}).Execute(nil)
+	}
+
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	if len(ops.PrintGameDefs) > 0 {
+		if isJSONOutput() {
+			return (&listCommand{All: true}).Execute(nil)
+		}
+
+		result, err := json.Marshal(dm.GetGameDefMap())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(result))
+		return nil
+	}
+
+	if len(ops.AddCustomGames) > 0 {
+		games := []string{}
+		for key, value := range ops.AddCustomGames {
+			err = dm.AddUserOverride(key, value)
+			if err != nil {
+				return err
+			}
+			games = append(games, key)
+		}
+
+		printResult(map[string]interface{}{"games": games}, func() {
+			fmt.Println("Game Added!")
+		})
+		return nil
+	}
+
+	if len(ops.NoGUI) == 1 && ops.NoGUI[0] {
+		if len(ops.Gamenames) == 0 {
+			return nil
+		}
+
+		// Scripts and launchers run --no-gui, which never asked before syncing
+		command := &syncCommand{noConfirm: true}
+		command.Args.Games = ops.Gamenames
+		return command.Execute(nil)
+	}
+
+	gui.GuiMain(ops, dm)
+	return nil
+}
+
+// authorizeHeadless signs in to cloud with a token from `rclone authorize`
+// run on another computer.
+func authorizeHeadless(cloud string) error {
+	storage, err := core.GetCloudStorage(cloud)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := core.GetHeadlessAuthorization(storage)
+	if err != nil {
+		return err
+	}
+
+	// Keep stdout for the result when it is read by a program
+	out := os.Stdout
+	if isJSONOutput() {
+		out = os.Stderr
+	}
+
+	fmt.Fprintln(out, authorization.Instructions)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "\t"+authorization.Command)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Paste the token, then press Enter on an empty line:")
+
+	input := readPastedToken(os.Stdin)
+	err = core.MakeCloudManager().AuthorizeStorageDrive(context.Background(), storage, input)
+	if err != nil {
+		return err
 	}
+
+	err = core.UpdateCloudProvider(cloud)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"cloud": cloud}, func() {
+		fmt.Println("Cloud Authorized!")
+	})
+	return runQueuedSyncs(storage)
+}
+
+// reconnect signs in to cloud again, keeping its settings.
+func reconnect(cloud string) error {
+	storage, err := core.GetCloudStorage(cloud)
+	if err != nil {
+		return err
+	}
+
+	err = core.MakeCloudManager().ReconnectStorageDrive(context.Background(), storage)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"cloud": cloud}, func() {
+		fmt.Println("Cloud Reconnected!")
+	})
+	return runQueuedSyncs(storage)
+}
+
+func syncUserSettings(userOverrideLocation string) error {
+	storage, _ := core.GetCurrentCloudStorage()
+	if storage == nil {
+		return fmt.Errorf("attempting to sync cloud data with no cloud provider set. Please set a cloud provider with `cloud set`")
+	}
+
+	err := core.MakeCloudManager().CreateDriveIfNotExists(context.Background(), storage)
+	if err != nil {
+		return err
+	}
+
+	err = core.GetUserSettingsManager().RequestSync(context.Background(), userOverrideLocation)
+	if err != nil {
+		core.ErrorLogger.Println(err)
+		return err
+	}
+
+	core.InfoLogger.Println("Cloud Settings In Sync")
+	printResult(nil, func() {
+		fmt.Println("Cloud Settings In Sync")
+	})
+	return nil
+}
+
+// readPastedToken reads the output of `rclone authorize` until rclone's end
+// marker, an empty line after the token, or the end of input.
+func readPastedToken(reader io.Reader) string {
+	var input strings.Builder
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "<---End paste") {
+			break
+		}
+		if strings.TrimSpace(line) == "" && strings.Contains(input.String(), "}") {
+			break
+		}
+
+		input.WriteString(line)
+		input.WriteString("\n")
+	}
+
+	return input.String()
+}
+
+// runQueuedSyncs syncs the games that failed while storage needed to be
+// reconnected.
+func runQueuedSyncs(storage core.Storage) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	channels := core.MakeDefaultChannelProvider()
+	go core.ConsoleLogger(channels.Logs)
+	return core.CompleteReauth(context.Background(), core.MakeCloudManager(), dm, storage, channels)
 }
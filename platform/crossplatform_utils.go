@@ -1,6 +1,7 @@
 package platform
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os/exec"
@@ -25,3 +26,13 @@ func OpenBrowser(url string) {
 	}
 
 }
+
+// ShellCommand returns a command running command with the shell of the
+// platform, so users can give pipes and arguments like in a terminal.
+func ShellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,6 @@
+package platform
+
+import "errors"
+
+// ErrLocked is returned by TryLockFile when another process holds the lock.
+var ErrLocked = errors.New("the file is locked by another process")
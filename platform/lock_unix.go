@@ -0,0 +1,34 @@
+//go:build linux || darwin
+
+package platform
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// TryLockFile takes an exclusive advisory lock on f without waiting. The lock
+// is released when f is closed, or when the process exits.
+func TryLockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
+
+func UnlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// ProcessExists tells whether a process with the id pid is running.
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// Signal 0 only checks that the process can be signaled
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
@@ -0,0 +1,52 @@
+//go:build windows
+
+package platform
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows locks keep other processes from reading the locked bytes, so a
+// byte far past the content is locked
+func lockOverlapped() *windows.Overlapped {
+	return &windows.Overlapped{Offset: ^uint32(0)}
+}
+
+// TryLockFile takes an exclusive lock on f without waiting. The lock is
+// released when f is closed, or when the process exits.
+func TryLockFile(f *os.File) error {
+	overlapped := lockOverlapped()
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+	return err
+}
+
+func UnlockFile(f *os.File) error {
+	overlapped := lockOverlapped()
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, overlapped)
+}
+
+// The exit code of processes that did not exit yet
+const stillActive = 259
+
+// ProcessExists tells whether a process with the id pid is running.
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Processes of other users can not be opened, but they exist
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(handle)
+
+	var code uint32
+	err = windows.GetExitCodeProcess(handle, &code)
+	return err == nil && code == stillActive
+}
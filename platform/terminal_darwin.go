@@ -0,0 +1,8 @@
+//go:build darwin
+
+package platform
+
+import "golang.org/x/sys/unix"
+
+const ioctlReadTermios = unix.TIOCGETA
+const ioctlWriteTermios = unix.TIOCSETA
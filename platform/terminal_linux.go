@@ -0,0 +1,8 @@
+//go:build linux
+
+package platform
+
+import "golang.org/x/sys/unix"
+
+const ioctlReadTermios = unix.TCGETS
+const ioctlWriteTermios = unix.TCSETS
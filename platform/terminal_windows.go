@@ -0,0 +1,42 @@
+//go:build windows
+
+package platform
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// IsTerminal tells whether f is a console a user can answer prompts on.
+func IsTerminal(f *os.File) bool {
+	var mode uint32
+	err := windows.GetConsoleMode(windows.Handle(f.Fd()), &mode)
+	return err == nil
+}
+
+// ReadPassword reads a line from the console f without echoing it.
+func ReadPassword(f *os.File) (string, error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	err := windows.GetConsoleMode(handle, &mode)
+	if err != nil {
+		return "", err
+	}
+
+	hidden := mode&^windows.ENABLE_ECHO_INPUT | windows.ENABLE_LINE_INPUT | windows.ENABLE_PROCESSED_INPUT
+	err = windows.SetConsoleMode(handle, hidden)
+	if err != nil {
+		return "", err
+	}
+	defer windows.SetConsoleMode(handle, mode)
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
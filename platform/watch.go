@@ -0,0 +1,20 @@
+package platform
+
+import "errors"
+
+// ErrWatchUnsupported is returned by NewWatcher where folders can not be
+// watched for changes.
+var ErrWatchUnsupported = errors.New("watching folders is not supported on this platform")
+
+// ErrWatchLimit is returned when the system limit of watched folders was
+// reached.
+var ErrWatchLimit = errors.New("the limit of watched folders was reached")
+
+// WatchEvent reports a change below a watched folder.
+type WatchEvent struct {
+	// The file or folder that changed
+	Path string
+	// Set when changes were lost because too many happened at once. Any
+	// watched folder may have changed.
+	Overflow bool
+}
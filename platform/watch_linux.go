@@ -0,0 +1,181 @@
+//go:build linux
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// Watcher reports changes to the files below the watched folders with
+// inotify. Folders created below a watched folder are watched as well.
+type Watcher struct {
+	Events chan WatchEvent
+	Errors chan error
+
+	// Fd of file, as calling file.Fd() would make reads blocking
+	fd   int
+	file *os.File
+	done chan struct{}
+	mtx  sync.Mutex
+	dirs map[int32]string
+}
+
+func NewWatcher() (*Watcher, error) {
+	// Non blocking, so closing the file stops the pending read
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		if err == unix.EMFILE {
+			return nil, fmt.Errorf("%w: %v, raise fs.inotify.max_user_instances", ErrWatchLimit, err)
+		}
+		return nil, err
+	}
+
+	w := &Watcher{
+		Events: make(chan WatchEvent, 100),
+		Errors: make(chan error, 10),
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		done:   make(chan struct{}),
+		dirs:   make(map[int32]string),
+	}
+	go w.read()
+
+	return w, nil
+}
+
+// Add watches dir and every folder below it.
+func (w *Watcher) Add(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Folders may be removed while they are walked
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			if err == unix.ENOSPC {
+				return fmt.Errorf("%w: failed to watch %v, raise fs.inotify.max_user_watches", ErrWatchLimit, path)
+			}
+			return fmt.Errorf("failed to watch %v: %v", path, err)
+		}
+
+		w.mtx.Lock()
+		w.dirs[int32(wd)] = path
+		w.mtx.Unlock()
+		return nil
+	})
+}
+
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *Watcher) send(event WatchEvent) {
+	select {
+	case w.Events <- event:
+	case <-w.done:
+	}
+}
+
+func (w *Watcher) sendError(err error) {
+	select {
+	case w.Errors <- err:
+	case <-w.done:
+	}
+}
+
+func (w *Watcher) read() {
+	defer close(w.Events)
+	defer close(w.Errors)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.sendError(err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytesBeforeNul(buf[nameStart:nameEnd]))
+			offset = nameEnd
+
+			w.handle(raw.Wd, raw.Mask, name)
+		}
+	}
+}
+
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.send(WatchEvent{Overflow: true})
+		return
+	}
+
+	w.mtx.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		// The folder was removed, its watch is gone
+		delete(w.dirs, wd)
+	}
+	w.mtx.Unlock()
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+
+	if mask&unix.IN_ISDIR != 0 && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		err := w.Add(path)
+		if err != nil {
+			w.sendError(err)
+		}
+	}
+
+	w.send(WatchEvent{Path: path})
+}
+
+func bytesBeforeNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
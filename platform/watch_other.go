@@ -0,0 +1,21 @@
+//go:build !linux
+
+package platform
+
+// Watcher reports changes to the files below the watched folders.
+type Watcher struct {
+	Events chan WatchEvent
+	Errors chan error
+}
+
+func NewWatcher() (*Watcher, error) {
+	return nil, ErrWatchUnsupported
+}
+
+func (w *Watcher) Add(dir string) error {
+	return ErrWatchUnsupported
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
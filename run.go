@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"opencloudsave/core"
+)
+
+type runCommand struct {
+	Game    string        `long:"game" required:"true" description:"The tracked game the command launches"`
+	Timeout time.Duration `long:"timeout" default:"15s" description:"How long to wait for the saves before the game is started anyway"`
+}
+
+// exitCodeError makes opencloudsave exit with the exit code of the command
+// it ran.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("the command exited with code %v", e.code)
+}
+
+func (c *runCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return core.WithErrorKind(core.ErrorKindUsage, fmt.Errorf("no command to run, e.g. run --game %v -- %%command%%", c.Game))
+	}
+	if c.Timeout <= 0 {
+		return core.WithErrorKind(core.ErrorKindUsage, fmt.Errorf("--timeout must be positive"))
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	ops := &core.RunOptions{
+		Game:    c.Game,
+		Timeout: c.Timeout,
+		SyncOptions: &core.Options{
+			DryRun:  globalOps.DryRun,
+			Verbose: globalOps.Verbose,
+		},
+	}
+
+	logs := make(chan core.Message)
+	printed := make(chan bool)
+	go func() {
+		// stdout belongs to the game, so everything is printed to stderr
+		for msg := range logs {
+			if msg.Err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", msg.Err)
+			} else if msg.Message != "" {
+				fmt.Fprintln(os.Stderr, msg.Message)
+			}
+		}
+		close(printed)
+	}()
+
+	code, err := core.RunGame(core.MakeCloudManager(), core.MakeGameDefManager(getUserOverrideLocation()), ops, cmd, logs)
+	close(logs)
+	<-printed
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		return &exitCodeError{code: code}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"opencloudsave/core"
+)
+
+type scheduleListCommand struct{}
+
+// scheduleInfo is a schedule along with its last and next run.
+type scheduleInfo struct {
+	*core.SyncSchedule
+	LastRun *core.ScheduleRun `json:"lastRun,omitempty"`
+	NextRun *time.Time        `json:"nextRun,omitempty"`
+	Invalid string            `json:"invalid,omitempty"`
+}
+
+func (c *scheduleListCommand) Execute(args []string) error {
+	schedules, err := core.GetSchedules()
+	if err != nil {
+		return err
+	}
+	runs, err := core.GetScheduleRuns()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	infos := []*scheduleInfo{}
+	for _, schedule := range schedules {
+		info := &scheduleInfo{SyncSchedule: schedule, LastRun: runs[schedule.Name]}
+		err := schedule.Validate()
+		if err != nil {
+			info.Invalid = err.Error()
+		} else {
+			lastRun := time.Time{}
+			if info.LastRun != nil {
+				lastRun = info.LastRun.Started
+			}
+			next := schedule.PlannedAt(lastRun, now)
+			info.NextRun = &next
+		}
+		infos = append(infos, info)
+	}
+
+	printResult(infos, func() {
+		if len(infos) == 0 {
+			fmt.Println("No schedules, see schedule add")
+		}
+		for _, info := range infos {
+			games := "all games"
+			if len(info.Games) > 0 {
+				games = strings.Join(info.Games, ", ")
+			} else if info.Selected {
+				games = "the games selected for multisync"
+			}
+			fmt.Printf("%v\t%v, %v\n", info.Name, info.Describe(), games)
+
+			if info.Invalid != "" {
+				fmt.Printf("\tinvalid:\t%v\n", info.Invalid)
+				continue
+			}
+			if info.LastRun != nil {
+				result := "ok"
+				if info.LastRun.Error != "" {
+					result = "failed: " + info.LastRun.Error
+				}
+				fmt.Printf("\tlast run:\t%v, %v\n", info.LastRun.Started.Local().Format("2006-01-02 15:04"), result)
+			}
+			fmt.Printf("\tnext run:\t%v\n", info.NextRun.Local().Format("2006-01-02 15:04"))
+		}
+	})
+
+	return nil
+}
+
+type scheduleAddCommand struct {
+	Every    string   `long:"every" description:"Sync every interval, e.g. 2h"`
+	At       string   `long:"at" description:"Sync daily at the local time, e.g. 03:00"`
+	Selected bool     `long:"selected" description:"Only sync the games selected for multisync"`
+	Games    []string `long:"game" description:"Only sync this game, may be given more than once"`
+	Jitter   string   `long:"jitter" description:"Delay every run by up to this long, e.g. 10m"`
+	Args     struct {
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *scheduleAddCommand) Execute(args []string) error {
+	dm := core.MakeGameDefManager(getUserOverrideLocation())
+	for _, game := range c.Games {
+		if _, ok := dm.GetGameDefMap()[game]; !ok {
+			return core.WithErrorKind(core.ErrorKindUnknownGame, fmt.Errorf("unknown game %v, see list --all", game))
+		}
+	}
+
+	schedule := &core.SyncSchedule{
+		Name:     c.Args.Name,
+		Every:    c.Every,
+		At:       c.At,
+		Selected: c.Selected,
+		Games:    c.Games,
+		Jitter:   c.Jitter,
+	}
+	err := schedule.Validate()
+	if err != nil {
+		return core.WithErrorKind(core.ErrorKindUsage, err)
+	}
+
+	err = core.AddSchedule(schedule)
+	if err != nil {
+		return err
+	}
+
+	printResult(schedule, func() {
+		fmt.Printf("Schedule %v added, syncing %v. Run schedule run or schedule systemd --install to run it\n", schedule.Name, schedule.Describe())
+	})
+	return nil
+}
+
+type scheduleRemoveCommand struct {
+	Args struct {
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *scheduleRemoveCommand) Execute(args []string) error {
+	err := core.RemoveSchedule(c.Args.Name)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"schedule": c.Args.Name}, func() {
+		fmt.Println("Schedule removed!")
+	})
+	return nil
+}
+
+type scheduleRunCommand struct {
+	Once bool `long:"once" description:"Run the schedules that are due and exit"`
+}
+
+func (c *scheduleRunCommand) Execute(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ops := &core.SchedulerOptions{
+		Once: c.Once,
+		SyncOptions: &core.Options{
+			DryRun:  globalOps.DryRun,
+			Verbose: globalOps.Verbose,
+			Output:  globalOps.Output,
+		},
+	}
+
+	channels := core.MakeDefaultChannelProvider()
+	var err error
+	go func() {
+		err = core.RunScheduler(ctx, core.MakeCloudManager(), core.MakeGameDefManager(getUserOverrideLocation()), ops, channels)
+		close(channels.Logs)
+	}()
+
+	printMessages(channels.Logs)
+	return err
+}
+
+type scheduleSystemdCommand struct {
+	Install bool `long:"install" description:"Write the units to the systemd user folder instead of printing them"`
+}
+
+func (c *scheduleSystemdCommand) Execute(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// The timer runs from another folder
+	passphraseFile := ""
+	if len(globalOps.VaultPassphrase) > 0 {
+		passphraseFile, err = filepath.Abs(globalOps.VaultPassphrase[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	units := core.MakeSystemdUnits(executable, passphraseFile)
+	if !c.Install {
+		printResult(map[string]interface{}{"service": units.Service, "timer": units.Timer}, func() {
+			fmt.Printf("# %v.service\n%v\n# %v.timer\n%v", core.SystemdUnitName, units.Service, core.SystemdUnitName, units.Timer)
+		})
+		return nil
+	}
+
+	servicePath, timerPath, err := core.InstallSystemdUnits(units)
+	if err != nil {
+		return err
+	}
+
+	printResult(map[string]interface{}{"service": servicePath, "timer": timerPath}, func() {
+		fmt.Printf("Wrote %v and %v. Enable them with:\n", servicePath, timerPath)
+		fmt.Printf("systemctl --user daemon-reload && systemctl --user enable --now %v.timer\n", core.SystemdUnitName)
+	})
+	return nil
+}
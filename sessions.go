@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"opencloudsave/core"
+)
+
+type sessionsWatchCommand struct {
+	Interval time.Duration `long:"interval" default:"2s" description:"How often running games are looked for"`
+}
+
+func (c *sessionsWatchCommand) Execute(args []string) error {
+	if c.Interval <= 0 {
+		return core.WithErrorKind(core.ErrorKindUsage, fmt.Errorf("--interval must be positive"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ops := &core.SessionOptions{
+		ScanInterval: c.Interval,
+		SyncOptions: &core.Options{
+			DryRun:  globalOps.DryRun,
+			Verbose: globalOps.Verbose,
+			Output:  globalOps.Output,
+		},
+	}
+
+	channels := core.MakeDefaultChannelProvider()
+	var err error
+	go func() {
+		err = core.WatchSessions(ctx, core.MakeCloudManager(), core.MakeGameDefManager(getUserOverrideLocation()), ops, channels)
+		close(channels.Logs)
+	}()
+
+	printMessages(channels.Logs)
+	return err
+}
+
+type sessionsListCommand struct {
+	Limit int `long:"limit" default:"20" description:"How many of the latest sessions to show"`
+}
+
+func (c *sessionsListCommand) Execute(args []string) error {
+	sessions, err := core.GetSessions()
+	if err != nil {
+		return err
+	}
+
+	if c.Limit > 0 && len(sessions) > c.Limit {
+		sessions = sessions[len(sessions)-c.Limit:]
+	}
+
+	printResult(sessions, func() {
+		for _, session := range sessions {
+			ended := "still running"
+			if session.Ended != nil {
+				ended = session.Ended.Sub(session.Started).Round(time.Second).String()
+			}
+
+			fmt.Printf("%v\t%v (%v)\n", session.Started.Local().Format("2006-01-02 15:04"), session.Game, ended)
+			if session.Before != nil {
+				fmt.Printf("\tbefore:\t%v\n", session.Before)
+			}
+			if session.After != nil {
+				fmt.Printf("\tafter:\t%v\n", session.After)
+			}
+		}
+	})
+
+	return nil
+}
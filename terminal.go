@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"opencloudsave/core"
+	"opencloudsave/platform"
+)
+
+// isInteractive tells whether a user can answer prompts.
+func isInteractive() bool {
+	return platform.IsTerminal(os.Stdin)
+}
+
+// shouldConfirm tells whether changes should be confirmed before they are
+// made. Without a terminal, e.g. when run by a script, nobody could answer.
+func shouldConfirm() bool {
+	if len(globalOps.Yes) > 0 && globalOps.Yes[0] {
+		return false
+	}
+
+	return isInteractive()
+}
+
+// prompter asks the user questions on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	// The terminal secrets are read from without echo, nil without one
+	terminal *os.File
+}
+
+func newPrompter() *prompter {
+	// Keep stdout for the result when it is read by a program
+	var out io.Writer = os.Stdout
+	if isJSONOutput() {
+		out = os.Stderr
+	}
+
+	p := &prompter{
+		in:  bufio.NewReader(os.Stdin),
+		out: out,
+	}
+	if isInteractive() {
+		p.terminal = os.Stdin
+	}
+
+	return p
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// ask returns the answer to question, or def if the answer was empty.
+func (p *prompter) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%v [%v]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%v: ", question)
+	}
+
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if answer == "" {
+		return def, nil
+	}
+
+	return answer, nil
+}
+
+func (p *prompter) askSecret(question string) (string, error) {
+	fmt.Fprintf(p.out, "%v: ", question)
+	if p.terminal == nil {
+		return p.readLine()
+	}
+
+	answer, err := platform.ReadPassword(p.terminal)
+	fmt.Fprintln(p.out)
+	return strings.TrimSpace(answer), err
+}
+
+// confirm asks a yes or no question. An empty answer picks def.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+
+	fmt.Fprintf(p.out, "%v [%v]: ", question, hint)
+	answer, err := p.readLine()
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// choose lists options and returns the index of the one picked.
+func (p *prompter) choose(question string, options []string) (int, error) {
+	for i, option := range options {
+		fmt.Fprintf(p.out, "%3v) %v\n", i+1, option)
+	}
+
+	for {
+		answer, err := p.ask(question, "")
+		if err != nil {
+			return 0, err
+		}
+
+		choice, err := strconv.Atoi(answer)
+		if err == nil && choice >= 1 && choice <= len(options) {
+			return choice - 1, nil
+		}
+
+		fmt.Fprintf(p.out, "Please enter a number between 1 and %v\n", len(options))
+	}
+}
+
+// askSettings asks for every field of provider. Current values are offered
+// as defaults, secrets left empty keep their current value.
+func (p *prompter) askSettings(provider *core.StorageProvider, existing bool) (map[string]string, error) {
+	current := core.GetProviderSettings(provider.Id)
+	settings := make(map[string]string)
+	for _, field := range provider.Fields {
+		for {
+			var value string
+			var err error
+			if field.Secret {
+				question := field.Label
+				if existing {
+					question += " (leave empty to keep the current one)"
+				}
+				value, err = p.askSecret(question)
+			} else {
+				def := current[field.Key]
+				if def == "" {
+					def = field.Default
+				}
+				value, err = p.ask(field.Label, def)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if value == "" && field.Required && !(field.Secret && existing) {
+				fmt.Fprintf(p.out, "%v is required\n", field.Label)
+				continue
+			}
+
+			if value != "" {
+				settings[field.Key] = value
+			}
+			break
+		}
+	}
+
+	return settings, nil
+}
+
+type setupCommand struct{}
+
+func (c *setupCommand) Execute(args []string) error {
+	if !isInteractive() {
+		return core.WithErrorKind(core.ErrorKindUsage, fmt.Errorf("setup needs a terminal, use `cloud set ID --setting KEY:VALUE` instead"))
+	}
+
+	ctx := context.Background()
+	cm := core.MakeCloudManager()
+	p := newPrompter()
+
+	providers := core.GetStorageProviders()
+	options := []string{}
+	for _, provider := range providers {
+		options = append(options, provider.DisplayName)
+	}
+
+	fmt.Fprintln(p.out, "Which cloud should your saves be synced to?")
+	choice, err := p.choose("Cloud", options)
+	if err != nil {
+		return err
+	}
+
+	cloud := providers[choice].Id
+	name, err := p.ask("Account name, leave empty to use the default account", "")
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		instance, err := core.GetOrAddStorageInstance(cloud, name)
+		if err != nil {
+			return err
+		}
+		cloud = instance.Id
+	}
+
+	provider, err := core.GetStorageProvider(cloud)
+	if err != nil {
+		return err
+	}
+
+	existing := cm.ContainsStorageDrive(ctx, provider.Storage())
+	if len(provider.Fields) > 0 {
+		settings, err := p.askSettings(provider, existing)
+		if err != nil {
+			return err
+		}
+
+		_, err = core.ConfigureStorageProvider(ctx, cm, cloud, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !existing {
+		browser := true
+		if provider.OAuth {
+			browser, err = p.confirm("Sign in with a browser on this device?", true)
+			if err != nil {
+				return err
+			}
+		}
+
+		if browser {
+			if provider.OAuth {
+				fmt.Fprintln(p.out, "Opening a browser to sign in...")
+			}
+			err = cm.CreateDriveIfNotExists(ctx, provider.Storage())
+		} else {
+			err = authorizeHeadless(cloud)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = core.UpdateCloudProvider(cloud)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.out, "Testing the connection...")
+	checks := core.CheckCurrentCloud(ctx, cm)
+	printResult(checks, func() {
+		printChecks(checks)
+	})
+	if core.DoctorFailed(checks) {
+		return fmt.Errorf("the cloud could not be reached, run setup again to change its settings")
+	}
+
+	if !isJSONOutput() {
+		fmt.Println("Cloud Set!")
+	}
+	return nil
+}
+
+// confirmSync shows the changes a sync of game would make and asks whether
+// to make them. Syncs without changes are not asked about.
+func confirmSync(p *prompter, dm core.GameDefManager, game string) (bool, error) {
+	fmt.Fprintf(p.out, "Checking what a sync of %v would change...\n", game)
+	changes, err := core.PlanSync(context.Background(), core.MakeCloudManager(), dm, game)
+	if err != nil {
+		return false, err
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintf(p.out, "%v is already in sync\n", game)
+		return true, nil
+	}
+
+	for _, change := range changes {
+		fmt.Fprintf(p.out, "\t%v\t%v\n", change.Action, change.Path)
+	}
+
+	return p.confirm(fmt.Sprintf("Sync %v?", game), false)
+}